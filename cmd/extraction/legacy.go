@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/admin"
+	kafkaadapter "github.com/panoramablock/thegraph-data-extraction/internal/adapters/kafka"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/metrics"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/spool"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/tracing"
+	"github.com/panoramablock/thegraph-data-extraction/internal/app"
+	"github.com/panoramablock/thegraph-data-extraction/internal/config"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+	"github.com/panoramablock/thegraph-data-extraction/pkg/client"
+	"github.com/panoramablock/thegraph-data-extraction/pkg/extraction"
+)
+
+// Extraction engines run by the binary
+const (
+	// EngineLegacy runs the pkg/extraction service, publishing one message
+	// per query response
+	EngineLegacy = "legacy"
+
+	// EnginePaginated runs the paginated extraction engine of internal/app,
+	// publishing one event per entity
+	EnginePaginated = "paginated"
+)
+
+// legacyOptions holds the command-line options of the legacy engine that are
+// not part of the application config
+type legacyOptions struct {
+	Concurrency int
+	EnableKafka bool
+	RunOnce     bool
+
+	// QueryTypes replaces the query types of the service when set
+	QueryTypes []string
+}
+
+// runLegacy extracts with the pkg/extraction service until ctx ends. Health,
+// readiness and metrics are served on config.HTTPAddr; the admin endpoints
+// need the paginated engine.
+func runLegacy(ctx context.Context, appConfig app.Config, options legacyOptions) error {
+	// Export traces of extraction runs
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Endpoint:    appConfig.TracingEndpoint,
+		Insecure:    true,
+		SampleRatio: appConfig.TracingSampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Error().Err(err).Msg("Error flushing traces")
+		}
+	}()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Validate configuration
+	if len(cfg.Endpoints) == 0 {
+		return fmt.Errorf("no endpoints configured, check your ENDPOINTS_JSON environment variable")
+	}
+	if cfg.AuthToken == "" {
+		return fmt.Errorf("no auth token provided, check your GRAPHQL_AUTH_TOKEN environment variable")
+	}
+
+	// Create GraphQL client
+	graphClient := client.NewTheGraphClient(cfg.AuthToken)
+
+	// Create extraction service
+	service := extraction.NewService(graphClient, cfg.Endpoints)
+	service.SetOutputDir(appConfig.OutputDir)
+	service.SetConcurrency(options.Concurrency)
+	if len(options.QueryTypes) > 0 {
+		service.SetQueryTypes(options.QueryTypes)
+	}
+
+	// Collect metrics for Prometheus
+	metricsRegistry := metrics.NewRegistry(metrics.Config{})
+	service.SetMetrics(metricsRegistry)
+
+	// Create the router that names topics and keys
+	routingConfig := appConfig.Routing
+	if routingConfig.Prefix == "" {
+		routingConfig.Prefix = appConfig.KafkaTopicPrefix
+	}
+	router, err := routing.NewRouter(routingConfig)
+	if err != nil {
+		return fmt.Errorf("invalid routing configuration: %w", err)
+	}
+	service.SetRouter(router)
+
+	// Ensure cleanup on exit
+	defer func() {
+		if err := service.Close(); err != nil {
+			log.Error().Err(err).Msg("Error during service shutdown")
+		}
+	}()
+
+	// Setup Kafka if enabled
+	var checks []admin.Check
+	if options.EnableKafka {
+		publisher, err := kafkaadapter.NewPublisher(kafkaadapter.PublisherConfig{
+			Brokers:       appConfig.KafkaBrokers,
+			Router:        router,
+			FlushInterval: 10 * time.Millisecond,
+			BatchSize:     100,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create Kafka publisher: %w", err)
+		}
+
+		// Spool messages to disk while the brokers are unavailable
+		spoolDir := appConfig.SpoolDir
+		if spoolDir == "" {
+			spoolDir = filepath.Join(appConfig.OutputDir, "spool")
+		}
+		spooled, err := spool.NewPublisher(publisher, spool.PublisherConfig{
+			Dir:         spoolDir,
+			MaxBytes:    appConfig.SpoolMaxBytes,
+			Unavailable: kafkaadapter.IsUnavailable,
+		})
+		if err != nil {
+			publisher.Close()
+			return fmt.Errorf("failed to open Kafka spool: %w", err)
+		}
+		service.SetPublisher(spooled)
+		metricsRegistry.ObserveSpool(spooled)
+		service.SetKafkaTopicPrefix(appConfig.KafkaTopicPrefix)
+		checks = append(checks, admin.Check{Name: "broker", Check: spooled.Ping})
+
+		topics, err := service.ResolveTopics()
+		if err != nil {
+			return fmt.Errorf("failed to resolve Kafka topics: %w", err)
+		}
+
+		// Fail fast if the brokers are unreachable, and provision the topics
+		err = kafkaadapter.EnsureTopics(ctx, appConfig.KafkaBrokers, topics, kafkaadapter.TopicConfig{
+			Create:            appConfig.KafkaCreateTopics,
+			Partitions:        appConfig.KafkaTopicPartitions,
+			ReplicationFactor: appConfig.KafkaTopicReplication,
+			Retention:         appConfig.KafkaTopicRetention,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to prepare Kafka topics: %w", err)
+		}
+
+		log.Info().
+			Strs("brokers", appConfig.KafkaBrokers).
+			Str("topicPrefix", appConfig.KafkaTopicPrefix).
+			Str("spoolDir", spoolDir).
+			Msg("Kafka publishing enabled")
+	} else {
+		log.Info().Msg("Kafka publishing disabled")
+	}
+
+	// The service start counts as a successful run
+	var lastSuccess atomic.Int64
+	lastSuccess.Store(time.Now().UnixNano())
+	if appConfig.ReadyMaxRunAge > 0 {
+		checks = append(checks, admin.Check{Name: "last_run", Check: func(ctx context.Context) error {
+			if age := time.Since(time.Unix(0, lastSuccess.Load())); age > appConfig.ReadyMaxRunAge {
+				return fmt.Errorf("last successful run finished %s ago", age.Round(time.Second))
+			}
+			return nil
+		}})
+	}
+
+	// Serve health, readiness and metrics until ctx ends
+	if appConfig.HTTPAddr != "" {
+		server := admin.NewServer(admin.ServerConfig{
+			Addr:    appConfig.HTTPAddr,
+			Checks:  checks,
+			Metrics: metricsRegistry.Handler(),
+		})
+		go func() {
+			if err := server.Serve(ctx); err != nil {
+				log.Error().Err(err).Msg("Admin server stopped")
+			}
+		}()
+	}
+
+	// Define extraction function
+	extractionFunc := func() {
+		log.Info().Msg("Starting scheduled data extraction")
+		startTime := time.Now()
+
+		if err := service.ExtractAllWithContext(ctx); err != nil {
+			log.Error().Err(err).Msg("Extraction failed")
+			return
+		}
+		lastSuccess.Store(time.Now().UnixNano())
+
+		duration := time.Since(startTime)
+		log.Info().
+			Dur("duration", duration).
+			Msg("Scheduled data extraction completed successfully")
+	}
+
+	if options.RunOnce {
+		// Run extraction once and exit
+		log.Info().
+			Int("endpoints", len(cfg.Endpoints)).
+			Int("workers", options.Concurrency).
+			Str("output", appConfig.OutputDir).
+			Msg("Running single extraction")
+
+		extractionFunc()
+		log.Info().Msg("Single extraction completed, exiting")
+		return nil
+	}
+
+	// Setup cron scheduler
+	c := cron.New() // Standard 5-field format: minute hour day month weekday
+
+	// Add extraction job to cron
+	if _, err := c.AddFunc(appConfig.Schedule.Default, extractionFunc); err != nil {
+		return fmt.Errorf("failed to add cron job with schedule %q: %w", appConfig.Schedule.Default, err)
+	}
+
+	log.Info().
+		Int("endpoints", len(cfg.Endpoints)).
+		Int("workers", options.Concurrency).
+		Str("output", appConfig.OutputDir).
+		Str("schedule", appConfig.Schedule.Default).
+		Msg("Starting cron scheduler for automatic data extraction")
+
+	// Start the cron scheduler
+	c.Start()
+	defer func() {
+		<-c.Stop().Done()
+	}()
+
+	// Run initial extraction immediately
+	log.Info().Msg("Running initial extraction...")
+	extractionFunc()
+
+	// Keep the application running until interrupted
+	log.Info().Msg("Cron scheduler started. Press Ctrl+C to stop.")
+	<-ctx.Done()
+
+	log.Info().Msg("Shutdown signal received, stopping cron scheduler...")
+	return nil
+}
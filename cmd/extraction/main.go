@@ -3,10 +3,11 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -14,50 +15,31 @@ import (
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/segmentio/kafka-go"
 
-	"github.com/panoramablock/thegraph-data-extraction/internal/config"
-	"github.com/panoramablock/thegraph-data-extraction/pkg/client"
-	"github.com/panoramablock/thegraph-data-extraction/pkg/extraction"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/scheduler"
+	"github.com/panoramablock/thegraph-data-extraction/internal/app"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/schedule"
 )
 
-// Helper functions for environment variables
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
-}
-
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if boolValue, err := strconv.ParseBool(value); err == nil {
-			return boolValue
-		}
-	}
-	return defaultValue
-}
-
 func main() {
-	// Setup graceful shutdown
+	// Setup graceful shutdown: ctx ends the extraction right away, stopCtx
+	// ends when it should drain
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	stopCtx, stop := context.WithCancel(ctx)
+	defer stop()
 
-	// Setup signal handling
-	sigChan := make(chan os.Signal, 1)
+	// Setup signal handling; a second signal aborts the drain
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
 		log.Info().Str("signal", sig.String()).Msg("Received shutdown signal")
+		stop()
+
+		sig = <-sigChan
+		log.Warn().Str("signal", sig.String()).Msg("Received second shutdown signal, aborting")
 		cancel()
 	}()
 
@@ -75,14 +57,55 @@ func main() {
 		log.Warn().Err(err).Msg("Error loading .env file")
 	}
 
+	// Re-publish dead letters instead of extracting
+	if len(os.Args) > 1 && os.Args[1] == "replay-dlq" {
+		if err := runReplayDLQ(stopCtx, os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("Dead letter replay failed")
+		}
+		return
+	}
+
+	// Print the reports of past runs instead of extracting
+	if len(os.Args) > 1 && os.Args[1] == "runs" {
+		if err := runRuns(stopCtx, os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("Failed to read run reports")
+		}
+		return
+	}
+
+	// Start from the environment and let command-line flags override it
+	config, err := app.ConfigFromEnvironment()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+
 	// Define command-line flags with environment variable fallbacks
-	outputDir := flag.String("output", getEnvOrDefault("OUTPUT_DIR", "data"), "Output directory for extracted data")
-	concurrency := flag.Int("concurrency", getEnvInt("CONCURRENCY", 8), "Number of concurrent workers")
-	kafkaBrokers := flag.String("kafka", getEnvOrDefault("KAFKA_BROKERS", "localhost:9092"), "Comma-separated list of Kafka brokers")
-	topicPrefix := flag.String("topic-prefix", getEnvOrDefault("KAFKA_TOPIC_PREFIX", "thegraph"), "Prefix for Kafka topics")
-	cronSchedule := flag.String("cron", getEnvOrDefault("CRON_SCHEDULE", "*/5 * * * *"), "Cron schedule for automatic extraction (default: every 5 minutes)")
-	runOnce := flag.Bool("once", getEnvBool("RUN_ONCE", false), "Run extraction once and exit (disable cron)")
-	enableKafka := flag.Bool("enable-kafka", getEnvBool("ENABLE_KAFKA", true), "Enable Kafka publishing")
+	outputDir := flag.String("output", config.OutputDir, "Output directory for cursors, spools and file events")
+	concurrency := flag.Int("concurrency", app.GetEnvInt("CONCURRENCY", 8), "Number of concurrent workers")
+	kafkaBrokers := flag.String("kafka", strings.Join(config.KafkaBrokers, ","), "Comma-separated list of Kafka brokers")
+	topicPrefix := flag.String("topic-prefix", config.KafkaTopicPrefix, "Prefix for Kafka topics")
+	queryTypes := flag.String("query-types", strings.Join(config.QueryTypes, ","), "Comma-separated list of query types to extract")
+	pageSize := flag.Int("page-size", config.PageSize, "Number of items per page in GraphQL queries")
+	cronSchedule := flag.String("cron", config.Schedule.Default, "Default cron schedule for automatic extraction (default: every 5 minutes)")
+	scheduleConfigPath := flag.String("schedule-config", app.GetEnvOrDefault("SCHEDULE_CONFIG", ""), "Path to a JSON schedule config setting cron schedules and priorities per endpoint and query type")
+	overlap := flag.String("overlap", app.GetEnvOrDefault("CRON_OVERLAP", scheduler.OverlapSkip), "What to do when a scheduled run starts while the previous one is running: skip, queue or cancel")
+	runOnce := flag.Bool("once", app.GetEnvBool("RUN_ONCE", false), "Run extraction once and exit (disable cron)")
+	enableKafka := flag.Bool("enable-kafka", app.GetEnvBool("ENABLE_KAFKA", true), "Enable Kafka publishing; the paginated engine writes events to files under <output>/events when disabled")
+	createTopics := flag.Bool("create-topics", config.KafkaCreateTopics, "Create missing Kafka topics at startup")
+	topicPartitions := flag.Int("topic-partitions", config.KafkaTopicPartitions, "Number of partitions for created Kafka topics")
+	topicReplication := flag.Int("topic-replication", config.KafkaTopicReplication, "Replication factor for created Kafka topics")
+	routingConfigPath := flag.String("routing-config", app.GetEnvOrDefault("ROUTING_CONFIG", ""), "Path to a JSON routing config (topic template, key strategies, partitions, deployments)")
+	topicTemplate := flag.String("topic-template", config.Routing.TopicTemplate, "Topic name template, e.g. {{.Prefix}}.{{.Chain}}.{{.Alias}}.{{.QueryType}}")
+	topicRetention := flag.Duration("topic-retention", config.KafkaTopicRetention, "Retention for created Kafka topics")
+	spoolDir := flag.String("spool-dir", config.SpoolDir, "Directory spooling messages while Kafka is unavailable (default <output>/spool)")
+	spoolMaxMB := flag.Int("spool-max-mb", int(config.SpoolMaxBytes>>20), "Disk space cap for the Kafka spool in MiB")
+	otlpEndpoint := flag.String("otlp-endpoint", config.TracingEndpoint, "OTLP/HTTP collector receiving traces, e.g. http://localhost:4318 (empty to disable)")
+	traceSampleRatio := flag.Float64("trace-sample-ratio", config.TracingSampleRatio, "Share of extraction runs traced")
+	httpAddr := flag.String("http-addr", config.HTTPAddr, "Address serving /healthz, /readyz, /metrics and /admin (empty to disable)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", config.ShutdownTimeout, "How long a shutdown lets tasks in progress finish their current page before cancelling them")
+	leaderElection := flag.String("leader-election", config.LeaderElection, "How replicas elect the one scheduling runs: none, file, kafka or postgres")
+	shardingMode := flag.String("sharding", config.Sharding, "How replicas share the endpoint and query type pairs: none, static or kafka")
+	engine := flag.String("engine", app.GetEnvOrDefault("EXTRACTION_ENGINE", EngineLegacy), "Extraction engine: legacy publishes one message per query response, paginated one event per entity")
 	flag.Parse()
 
 	log.Info().
@@ -93,81 +116,148 @@ func main() {
 		Str("cronSchedule", *cronSchedule).
 		Bool("runOnce", *runOnce).
 		Bool("enableKafka", *enableKafka).
+		Str("engine", *engine).
 		Msg("Starting TheGraph Data Extraction Service")
 
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load configuration")
+	// Apply command-line flags
+	config.OutputDir = *outputDir
+	config.QueryTypes = strings.Split(*queryTypes, ",")
+	config.PageSize = *pageSize
+	config.InitialWorkers = *concurrency
+	if config.MaxWorkers < *concurrency {
+		config.MaxWorkers = *concurrency
+	}
+	if config.MinWorkers > *concurrency {
+		config.MinWorkers = *concurrency
+	}
+	config.KafkaBrokers = strings.Split(*kafkaBrokers, ",")
+	config.KafkaTopicPrefix = *topicPrefix
+	config.KafkaCreateTopics = *createTopics
+	config.KafkaTopicPartitions = *topicPartitions
+	config.KafkaTopicReplication = *topicReplication
+	config.KafkaTopicRetention = *topicRetention
+	config.SpoolDir = *spoolDir
+	config.SpoolMaxBytes = int64(*spoolMaxMB) << 20
+	config.TracingEndpoint = *otlpEndpoint
+	config.TracingSampleRatio = *traceSampleRatio
+	config.HTTPAddr = *httpAddr
+	config.LeaderElection = *leaderElection
+	config.Sharding = *shardingMode
+
+	// Load the routing config naming topics and keys
+	if *routingConfigPath != "" {
+		routingConfig, err := routing.LoadConfig(*routingConfigPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load routing configuration")
+		}
+		config.Routing = routingConfig
+	}
+	if *topicTemplate != "" {
+		config.Routing.TopicTemplate = *topicTemplate
+	}
+
+	// Load the schedules and priorities of the tasks; -cron is the default
+	// schedule unless the config sets one
+	if *scheduleConfigPath != "" {
+		scheduleConfig, err := schedule.LoadConfig(*scheduleConfigPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load schedule configuration")
+		}
+		config.Schedule = scheduleConfig
+	}
+	if config.Schedule.Default == "" {
+		config.Schedule.Default = *cronSchedule
+	}
+
+	switch *engine {
+	case EngineLegacy:
+		// The legacy service extracts every query type unless they are set
+		options := legacyOptions{
+			Concurrency: *concurrency,
+			EnableKafka: *enableKafka,
+			RunOnce:     *runOnce,
+		}
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "query-types" {
+				options.QueryTypes = config.QueryTypes
+			}
+		})
+		if app.GetEnvOrDefault("QUERY_TYPES", "") != "" {
+			options.QueryTypes = config.QueryTypes
+		}
+		if err := runLegacy(stopCtx, config, options); err != nil {
+			log.Fatal().Err(err).Msg("Extraction service failed")
+		}
+		return
+	case EnginePaginated:
+	default:
+		log.Fatal().Str("engine", *engine).Msg("Unknown extraction engine, use legacy or paginated")
 	}
 
 	// Validate configuration
-	if len(cfg.Endpoints) == 0 {
+	if len(config.Endpoints) == 0 {
 		log.Fatal().Msg("No endpoints configured. Check your ENDPOINTS_JSON environment variable.")
 	}
-	if cfg.AuthToken == "" {
+	if config.GraphQLAuthToken == "" {
 		log.Fatal().Msg("No auth token provided. Check your GRAPHQL_AUTH_TOKEN environment variable.")
 	}
 
-	// Create GraphQL client
-	graphClient := client.NewTheGraphClient(cfg.AuthToken)
-
-	// Create extraction service
-	service := extraction.NewService(graphClient, cfg.Endpoints)
-	service.SetOutputDir(*outputDir)
-	service.SetConcurrency(*concurrency)
+	// Write events to files when Kafka is disabled
+	if !*enableKafka && (config.Broker == "" || config.Broker == app.BrokerKafka) {
+		config.Broker = app.BrokerFile
+		log.Info().Msg("Kafka publishing disabled, writing events to files")
+	}
 
-	// Setup Kafka if enabled
-	var kafkaWriter *kafka.Writer
-	if *enableKafka {
-		kafkaWriter = &kafka.Writer{
-			Addr:         kafka.TCP(strings.Split(*kafkaBrokers, ",")...),
-			Balancer:     &kafka.LeastBytes{},
-			BatchTimeout: 10 * time.Millisecond,
-			BatchSize:    100,
-		}
-		service.SetKafkaWriter(kafkaWriter)
-		service.SetKafkaTopicPrefix(*topicPrefix)
-		
-		log.Info().
-			Strs("brokers", strings.Split(*kafkaBrokers, ",")).
-			Str("topicPrefix", *topicPrefix).
-			Msg("Kafka publishing enabled")
-	} else {
-		log.Info().Msg("Kafka publishing disabled")
+	// Create the extraction engine; this checks that the brokers are
+	// reachable and provisions the topics before the first run
+	application, err := app.NewApplication(ctx, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create application")
 	}
 
 	// Ensure cleanup on exit
 	defer func() {
-		if err := service.Close(); err != nil {
+		if err := application.Close(); err != nil {
 			log.Error().Err(err).Msg("Error during service shutdown")
 		}
-		if kafkaWriter != nil {
-			if err := kafkaWriter.Close(); err != nil {
-				log.Error().Err(err).Msg("Error closing Kafka writer")
-			}
-		}
 	}()
 
-	// Create output directory if it doesn't exist
-	/* DISABLED: No longer saving files to disk
-	if err := os.MkdirAll(*outputDir, 0755); err != nil {
-		log.Fatal().Err(err).Str("dir", *outputDir).Msg("Failed to create output directory")
+	// Drain the extraction once a shutdown signal arrives
+	shutdown := func() {
+		log.Info().
+			Dur("timeout", *shutdownTimeout).
+			Msg("Shutting down, waiting for tasks in progress to finish their current page")
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, *shutdownTimeout)
+		defer shutdownCancel()
+
+		if err := application.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Shutdown did not complete cleanly")
+			return
+		}
+		log.Info().Msg("Graceful shutdown completed")
+	}
+
+	// Finish the tasks interrupted by the last shutdown before anything else
+	resumeInterrupted := func(ctx context.Context) {
+		if err := application.ExtractionService.ResumeInterrupted(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to resume interrupted tasks")
+		}
 	}
-	*/
 
-	// Define extraction function
-	extractionFunc := func() {
-		log.Info().Msg("Starting scheduled data extraction")
+	// Define extraction function running the tasks of a job
+	extractionFunc := func(ctx context.Context, job schedule.Job) {
+		log.Info().Str("job", job.Name).Msg("Starting scheduled data extraction")
 		startTime := time.Now()
 
-		if err := service.ExtractAllWithContext(ctx); err != nil {
-			log.Error().Err(err).Msg("Extraction failed")
+		if err := application.ExtractionService.ExtractTasks(ctx, job.Tasks); err != nil {
+			log.Error().Str("job", job.Name).Err(err).Msg("Extraction failed")
 			return
 		}
 
 		duration := time.Since(startTime)
 		log.Info().
+			Str("job", job.Name).
 			Dur("duration", duration).
 			Msg("Scheduled data extraction completed successfully")
 	}
@@ -175,57 +265,164 @@ func main() {
 	if *runOnce {
 		// Run extraction once and exit
 		log.Info().
-			Int("endpoints", len(cfg.Endpoints)).
+			Int("endpoints", len(config.Endpoints)).
 			Int("workers", *concurrency).
 			Str("output", *outputDir).
 			Msg("Running single extraction")
 
-		extractionFunc()
-		log.Info().Msg("Single extraction completed, exiting")
+		// Extract every task whatever its schedule, highest priority first
+		all := schedule.Job{Name: schedule.DefaultJob}
+		for _, job := range application.ExtractionService.Jobs() {
+			all.Tasks = append(all.Tasks, job.Tasks...)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			resumeInterrupted(ctx)
+			extractionFunc(ctx, all)
+		}()
+
+		select {
+		case <-done:
+			log.Info().Msg("Single extraction completed, exiting")
+		case <-stopCtx.Done():
+			shutdown()
+		}
 		return
 	}
 
-	// Setup cron scheduler
-	c := cron.New() // Standard 5-field format: minute hour day month weekday
-	
-	// Add extraction job to cron
-	_, err = c.AddFunc(*cronSchedule, extractionFunc)
-	if err != nil {
-		log.Fatal().Err(err).Str("schedule", *cronSchedule).Msg("Failed to add cron job")
+	// Setup the cron scheduler of the leader: one job per schedule. Overlap
+	// is handled per job, so a slow hourly job never holds back a job
+	// running every minute. Runs are cancelled when ctx ends.
+	schedules := application.ExtractionService.Jobs()
+	newScheduler := func(ctx context.Context) (*cron.Cron, []*scheduler.Job, error) {
+		c := cron.New() // Standard 5-field format: minute hour day month weekday
+
+		var jobs []*scheduler.Job
+		for _, job := range schedules {
+			job := job
+			extractionJob, err := scheduler.NewJob(func(ctx context.Context) {
+				extractionFunc(ctx, job)
+			}, scheduler.JobConfig{
+				Name:    job.Name,
+				Policy:  *overlap,
+				Context: ctx,
+				OnSkip: func(reason string) {
+					application.Metrics.RecordSkippedTick(job.Name, reason)
+				},
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid overlap policy: %w", err)
+			}
+
+			if _, err := c.AddJob(job.Cron, extractionJob); err != nil {
+				return nil, nil, fmt.Errorf("failed to add cron job %s with schedule %q: %w", job.Name, job.Cron, err)
+			}
+			jobs = append(jobs, extractionJob)
+		}
+		return c, jobs, nil
 	}
 
-	log.Info().
-		Int("endpoints", len(cfg.Endpoints)).
-		Int("workers", *concurrency).
-		Str("output", *outputDir).
-		Str("schedule", *cronSchedule).
-		Msg("Starting cron scheduler for automatic data extraction")
+	// Check the schedules before campaigning
+	if _, _, err := newScheduler(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Invalid schedule")
+	}
+	for _, job := range schedules {
+		log.Info().
+			Str("job", job.Name).
+			Str("schedule", job.Cron).
+			Int("tasks", len(job.Tasks)).
+			Int("topPriority", job.Tasks[0].Priority).
+			Msg("Scheduled extraction job")
+	}
 
-	// Start the cron scheduler
-	c.Start()
-	defer c.Stop()
+	// Schedule runs for as long as this instance leads. Runs in progress are
+	// cancelled when the leadership is lost; on shutdown they are drained
+	// first and the leadership is held until then.
+	lead := func(leaderCtx context.Context) {
+		c, jobs, err := newScheduler(leaderCtx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create cron scheduler")
+			return
+		}
 
-	// Run initial extraction immediately
-	log.Info().Msg("Running initial extraction...")
-	extractionFunc()
+		log.Info().
+			Int("endpoints", len(config.Endpoints)).
+			Int("workers", *concurrency).
+			Str("output", *outputDir).
+			Str("schedule", config.Schedule.Default).
+			Int("jobs", len(jobs)).
+			Str("overlap", *overlap).
+			Msg("Starting cron scheduler for automatic data extraction")
 
-	// Keep the application running until interrupted
-	log.Info().Msg("Cron scheduler started. Press Ctrl+C to stop.")
-	
-	// Wait for context cancellation (SIGINT/SIGTERM)
-	<-ctx.Done()
-	
-	log.Info().Msg("Shutdown signal received, stopping cron scheduler...")
-	
-	// Give ongoing extractions time to complete
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer shutdownCancel()
-	
-	// Wait for shutdown
-	select {
-	case <-shutdownCtx.Done():
-		log.Warn().Msg("Shutdown timeout exceeded")
-	case <-time.After(2 * time.Second):
-		log.Info().Msg("Graceful shutdown completed")
+		// Start the cron scheduler
+		c.Start()
+
+		// Run every job immediately, after the interrupted tasks; jobs share
+		// the workers and the rate budget by priority
+		var initial sync.WaitGroup
+		initial.Add(1)
+		go func() {
+			defer initial.Done()
+
+			// Wait until this instance knows the pairs it owns
+			select {
+			case <-application.Sharding.Assigned():
+			case <-leaderCtx.Done():
+				return
+			}
+			resumeInterrupted(leaderCtx)
+
+			log.Info().Msg("Running initial extraction...")
+			for _, extractionJob := range jobs {
+				initial.Add(1)
+				go func(extractionJob *scheduler.Job) {
+					defer initial.Done()
+					extractionJob.Run()
+				}(extractionJob)
+			}
+		}()
+
+		select {
+		case <-leaderCtx.Done():
+		case <-stopCtx.Done():
+		}
+
+		// Stop scheduling runs and wait for the ones in progress
+		log.Info().Msg("Stopping cron scheduler...")
+		<-c.Stop().Done()
+		initial.Wait()
+
+		// Keep leading until the shutdown has drained the runs
+		<-leaderCtx.Done()
 	}
+
+	electionCtx, stopElection := context.WithCancel(ctx)
+	defer stopElection()
+	electionDone := make(chan struct{})
+	go func() {
+		defer close(electionDone)
+		application.Leadership.Run(electionCtx, lead)
+	}()
+
+	// Take the pairs assigned to this instance
+	shardingDone := make(chan struct{})
+	go func() {
+		defer close(shardingDone)
+		application.Sharding.Run(electionCtx, application.ExtractionService)
+	}()
+
+	// Keep the application running until interrupted
+	log.Info().
+		Str("leaderElection", config.LeaderElection).
+		Str("sharding", config.Sharding).
+		Msg("Campaigning for leadership. Press Ctrl+C to stop.")
+	<-stopCtx.Done()
+
+	// Drain the runs in progress, then give the leadership and the pairs up
+	log.Info().Msg("Shutdown signal received, stopping extraction...")
+	shutdown()
+	stopElection()
+	<-electionDone
+	<-shardingDone
 }
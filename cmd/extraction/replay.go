@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/app"
+)
+
+// runReplayDLQ re-publishes dead letters once the broker has recovered
+func runReplayDLQ(ctx context.Context, args []string) error {
+	config, err := app.ConfigFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("replay-dlq", flag.ExitOnError)
+	kafkaBrokers := flags.String("kafka", strings.Join(config.KafkaBrokers, ","), "Comma-separated list of Kafka brokers")
+	outputDir := flags.String("output", config.OutputDir, "Output directory for extracted data")
+	deadLetterDir := flags.String("dlq-dir", config.DeadLetterDir, "Dead letter spool directory (default <output>/deadletter)")
+	deadLetterTopic := flags.String("dlq-topic", config.DeadLetterTopic, "Dead letter Kafka topic; replaces the spool directory when set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config.KafkaBrokers = strings.Split(*kafkaBrokers, ",")
+	config.OutputDir = *outputDir
+	config.DeadLetterDir = *deadLetterDir
+	config.DeadLetterTopic = *deadLetterTopic
+
+	// Replays are one-off, so nothing is served
+	config.HTTPAddr = ""
+
+	application, err := app.NewApplication(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer application.Close()
+
+	replayed, err := application.ExtractionService.ReplayDeadLetters(ctx)
+	log.Info().
+		Int("replayed", replayed).
+		Msg("Dead letter replay finished")
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/repository"
+	"github.com/panoramablock/thegraph-data-extraction/internal/app"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// runRuns prints the reports of past extraction runs
+func runRuns(ctx context.Context, args []string) error {
+	config, err := app.ConfigFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("runs", flag.ExitOnError)
+	outputDir := flags.String("output", config.OutputDir, "Output directory for extracted data")
+	runReportDir := flags.String("dir", config.RunReportDir, "Run report directory (default <output>/runs)")
+	limit := flags.Int("limit", 20, "Number of recent runs to list")
+	runID := flags.String("id", "", "Print the full report of this run")
+	asJSON := flags.Bool("json", false, "Print reports as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	dir := *runReportDir
+	if dir == "" {
+		dir = filepath.Join(*outputDir, "runs")
+	}
+	store, err := repository.NewFileRunStore(repository.FileRunStoreConfig{Dir: dir})
+	if err != nil {
+		return err
+	}
+
+	if *runID != "" {
+		report, err := store.GetRun(ctx, *runID)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(report)
+		}
+		printRunReport(report)
+		return nil
+	}
+
+	reports, err := store.ListRuns(ctx, *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(reports)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tSTARTED\tDURATION\tTASKS\tENTITIES\tERRORS\tSTATUS")
+	for _, report := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			report.ID,
+			report.StartedAt.Format(time.RFC3339),
+			report.FinishedAt.Sub(report.StartedAt).Round(time.Millisecond),
+			len(report.Tasks),
+			report.Entities(),
+			report.Errors(),
+			runState(report),
+		)
+	}
+	return w.Flush()
+}
+
+// printRunReport prints a run and its tasks as tables
+func printRunReport(report *entity.RunReport) {
+	fmt.Printf("Run:      %s\n", report.ID)
+	fmt.Printf("Started:  %s\n", report.StartedAt.Format(time.RFC3339))
+	fmt.Printf("Finished: %s\n", report.FinishedAt.Format(time.RFC3339))
+	fmt.Printf("Status:   %s\n", runState(report))
+	if report.Error != "" {
+		fmt.Printf("Error:    %s\n", report.Error)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tQUERY TYPE\tPAGES\tENTITIES\tRETRIES\tERRORS\tBLOCK\tCURSOR BEFORE\tCURSOR AFTER\tERROR")
+	for _, task := range report.Tasks {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			task.Endpoint,
+			task.QueryType,
+			task.Pages,
+			task.Entities,
+			task.Retries,
+			task.Errors,
+			task.Block,
+			task.CursorBefore,
+			task.CursorAfter,
+			task.Error,
+		)
+	}
+	w.Flush()
+}
+
+// runState describes the outcome of a run
+func runState(report *entity.RunReport) string {
+	if report.Succeeded() {
+		return entity.TaskSucceeded
+	}
+	return entity.TaskFailed
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,363 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// Extractor is the extraction service inspected and driven by the admin endpoints
+type Extractor interface {
+	// Tasks returns the status and cursor of every endpoint and query type
+	Tasks(ctx context.Context) ([]entity.TaskStatus, error)
+
+	// LastRun returns the status of the current or last extraction run, if any
+	LastRun() (entity.RunStatus, bool)
+
+	// LastSuccessfulRun returns the status of the last run that finished without errors, if any
+	LastSuccessfulRun() (entity.RunStatus, bool)
+
+	// StartPair starts the extraction of a query type from an endpoint in the background
+	StartPair(ctx context.Context, endpoint, queryType string) error
+
+	// Runs returns the reports of the most recent runs, newest first
+	Runs(ctx context.Context, limit int) ([]*entity.RunReport, error)
+
+	// Run returns the report of a run
+	Run(ctx context.Context, runID string) (*entity.RunReport, error)
+}
+
+// Check is a named readiness check
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a readiness check
+type CheckResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Server serves health, readiness, metrics and admin endpoints over HTTP
+type Server struct {
+	addr         string
+	extractor    Extractor
+	checks       []Check
+	maxRunAge    time.Duration
+	checkTimeout time.Duration
+	token        string
+	metrics      http.Handler
+	leading      func() bool
+	startedAt    time.Time
+
+	// runCtx is the context of extractions started through the admin endpoints
+	runCtx context.Context
+}
+
+// ServerConfig holds the configuration for the admin server
+type ServerConfig struct {
+	Addr string
+
+	// Extractor backs the admin endpoints and the last run check
+	Extractor Extractor
+
+	// Checks are run by /readyz next to the last run check
+	Checks []Check
+
+	// MaxRunAge is how long ago the last successful run may have finished
+	// for the service to be ready. The service start counts as a successful
+	// run, so a fresh process is ready until MaxRunAge has passed.
+	// Zero disables the check.
+	MaxRunAge time.Duration
+
+	// CheckTimeout bounds each readiness check (default 5s)
+	CheckTimeout time.Duration
+
+	// Token is required as a bearer token by the /admin endpoints. Without
+	// it the /admin endpoints answer 403.
+	Token string
+
+	// Metrics is served on /metrics when set
+	Metrics http.Handler
+
+	// Leading reports whether this instance is the leader running the
+	// extraction (default always). Followers skip the last run check and
+	// refuse on-demand extractions.
+	Leading func() bool
+}
+
+// NewServer creates a new admin server
+func NewServer(config ServerConfig) *Server {
+	// Set defaults for configuration
+	if config.Addr == "" {
+		config.Addr = ":9090"
+	}
+	if config.CheckTimeout <= 0 {
+		config.CheckTimeout = 5 * time.Second
+	}
+	if config.Leading == nil {
+		config.Leading = func() bool { return true }
+	}
+
+	return &Server{
+		addr:         config.Addr,
+		extractor:    config.Extractor,
+		checks:       config.Checks,
+		maxRunAge:    config.MaxRunAge,
+		checkTimeout: config.CheckTimeout,
+		token:        config.Token,
+		metrics:      config.Metrics,
+		leading:      config.Leading,
+		startedAt:    time.Now(),
+		runCtx:       context.Background(),
+	}
+}
+
+// Handler returns the HTTP handler of every endpoint
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics)
+	}
+	if s.extractor != nil {
+		mux.Handle("GET /admin/tasks", s.authorize(s.handleTasks))
+		mux.Handle("GET /admin/runs", s.authorize(s.handleRuns))
+		mux.Handle("GET /admin/runs/last", s.authorize(s.handleLastRun))
+		mux.Handle("GET /admin/runs/{id}", s.authorize(s.handleRun))
+		mux.Handle("POST /admin/tasks/{endpoint}/{queryType}/extract", s.authorize(s.handleExtract))
+	}
+	return mux
+}
+
+// Serve listens on the configured address until ctx is cancelled.
+// Extractions started through the admin endpoints run under ctx.
+func (s *Server) Serve(ctx context.Context) error {
+	s.runCtx = ctx
+
+	server := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().
+		Str("addr", s.addr).
+		Msg("Serving health, metrics and admin endpoints")
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("admin server failed: %w", err)
+	}
+	return nil
+}
+
+// Ready runs every readiness check and reports whether all of them passed
+func (s *Server) Ready(ctx context.Context) ([]CheckResult, bool) {
+	checks := s.checks
+	if s.extractor != nil && s.maxRunAge > 0 {
+		checks = append(checks[:len(checks):len(checks)], Check{Name: "last_run", Check: s.checkLastRun})
+	}
+
+	results := make([]CheckResult, 0, len(checks))
+	ready := true
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, s.checkTimeout)
+		err := check.Check(checkCtx)
+		cancel()
+
+		result := CheckResult{Name: check.Name, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			ready = false
+		}
+		results = append(results, result)
+	}
+	return results, ready
+}
+
+// checkLastRun fails when no run succeeded within the maximum run age.
+// Followers do not run extractions, so the check passes for them.
+func (s *Server) checkLastRun(ctx context.Context) error {
+	if !s.leading() {
+		return nil
+	}
+
+	lastSuccess := s.startedAt
+	if run, ok := s.extractor.LastSuccessfulRun(); ok {
+		lastSuccess = run.FinishedAt
+	}
+
+	if age := time.Since(lastSuccess); age > s.maxRunAge {
+		return fmt.Errorf("last successful run finished %s ago, more than %s", age.Round(time.Second), s.maxRunAge)
+	}
+	return nil
+}
+
+// handleHealth reports that the process is serving requests and its role
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	role := "leader"
+	if !s.leading() {
+		role = "follower"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "role": role})
+}
+
+// handleReady reports the readiness checks, failing with 503 when one of them fails
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	results, ready := s.Ready(r.Context())
+
+	status := http.StatusOK
+	body := map[string]interface{}{"status": "ready", "checks": results}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		body["status"] = "not ready"
+	}
+	writeJSON(w, status, body)
+}
+
+// handleTasks lists every endpoint and query type with its cursor and last outcome
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := s.extractor.Tasks(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// handleLastRun reports the current or last extraction run
+func (s *Server) handleLastRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.extractor.LastRun()
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no extraction run yet"))
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// handleRuns lists the reports of recent runs, newest first. The limit
+// query parameter caps the number of reports (default 20).
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := s.extractor.Runs(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// handleRun returns the report of a run
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	run, err := s.extractor.Run(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, ports.ErrRunNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, run)
+	}
+}
+
+// handleExtract starts an on-demand extraction of an endpoint and query type
+func (s *Server) handleExtract(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.PathValue("endpoint")
+	queryType := r.PathValue("queryType")
+
+	// Only the leader extracts, or replicas would publish the same data
+	if !s.leading() {
+		writeError(w, http.StatusConflict, errors.New("this instance is a follower, send the request to the leader"))
+		return
+	}
+
+	err := s.extractor.StartPair(s.runCtx, endpoint, queryType)
+	switch {
+	case errors.Is(err, ports.ErrUnknownTask):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, ports.ErrTaskRunning), errors.Is(err, ports.ErrNotOwner):
+		writeError(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, ports.ErrShuttingDown):
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Info().
+		Str("endpoint", endpoint).
+		Str("queryType", queryType).
+		Str("remote", r.RemoteAddr).
+		Msg("Extraction requested through admin endpoint")
+
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status":    "started",
+		"endpoint":  endpoint,
+		"queryType": queryType,
+	})
+}
+
+// authorize requires the bearer token on a handler, and refuses every request
+// when no token is configured
+func (s *Server) authorize(handler http.HandlerFunc) http.Handler {
+	if s.token == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, errors.New("admin endpoints are disabled, set ADMIN_TOKEN to enable them"))
+		})
+	}
+
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Debug().Err(err).Msg("Failed to write admin response")
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
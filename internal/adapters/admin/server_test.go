@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// startedExtractor records the pairs started through the admin endpoints
+type startedExtractor struct {
+	Extractor
+	started []string
+}
+
+func (e *startedExtractor) StartPair(ctx context.Context, endpoint, queryType string) error {
+	e.started = append(e.started, endpoint+"/"+queryType)
+	return nil
+}
+
+// extract posts an on-demand extraction with an optional authorization header
+func extract(handler http.Handler, authorization string) int {
+	req := httptest.NewRequest(http.MethodPost, "/admin/tasks/deployment/swaps/extract", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAdminRoutesRefusedWithoutToken(t *testing.T) {
+	extractor := &startedExtractor{}
+	handler := NewServer(ServerConfig{Extractor: extractor}).Handler()
+
+	if code := extract(handler, ""); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without a configured token, got %d", code)
+	}
+	if code := extract(handler, "Bearer "); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an empty bearer token, got %d", code)
+	}
+	if len(extractor.started) != 0 {
+		t.Fatalf("expected no extraction, got %v", extractor.started)
+	}
+
+	// Health stays open
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected /healthz to answer 200, got %d", rec.Code)
+	}
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	extractor := &startedExtractor{}
+	handler := NewServer(ServerConfig{Extractor: extractor, Token: "secret"}).Handler()
+
+	if code := extract(handler, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a bearer token, got %d", code)
+	}
+	if code := extract(handler, "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong bearer token, got %d", code)
+	}
+	if code := extract(handler, "Bearer secret"); code != http.StatusAccepted {
+		t.Fatalf("expected 202 with the bearer token, got %d", code)
+	}
+	if len(extractor.started) != 1 || extractor.started[0] != "deployment/swaps" {
+		t.Fatalf("expected deployment/swaps to start, got %v", extractor.started)
+	}
+}
@@ -0,0 +1,162 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// Content modes of the CloudEvents Kafka protocol binding
+const (
+	// ModeStructured carries the whole event, attributes and data, as a JSON message value
+	ModeStructured = "structured"
+	// ModeBinary carries the attributes as ce_ headers and the data as the message value
+	ModeBinary = "binary"
+)
+
+const (
+	// SpecVersion is the CloudEvents version of the produced events
+	SpecVersion = "1.0"
+	// ContentType is the content type of structured mode events
+	ContentType = "application/cloudevents+json"
+	// HeaderPrefix prefixes attribute headers in binary mode
+	HeaderPrefix = "ce_"
+)
+
+// Extension attributes carried by every event
+const (
+	ExtensionBlockNumber = "blocknumber"
+	ExtensionRunID       = "runid"
+)
+
+// Envelope wraps encoded entities in CloudEvents 1.0 events
+type Envelope struct {
+	mode       string
+	source     string
+	typePrefix string
+}
+
+// Config holds the configuration for the CloudEvents envelope
+type Config struct {
+	// Mode is either "structured" or "binary" (default "structured")
+	Mode string
+
+	// Source prefixes the deployment in the source attribute (default "/thegraph")
+	Source string
+
+	// TypePrefix prefixes the query type in the type attribute (default "thegraph.")
+	TypePrefix string
+}
+
+// NewEnvelope creates a new CloudEvents envelope
+func NewEnvelope(config Config) (*Envelope, error) {
+	// Set defaults for configuration
+	if config.Mode == "" {
+		config.Mode = ModeStructured
+	}
+	if config.Source == "" {
+		config.Source = "/thegraph"
+	}
+	if config.TypePrefix == "" {
+		config.TypePrefix = "thegraph."
+	}
+
+	if config.Mode != ModeStructured && config.Mode != ModeBinary {
+		return nil, fmt.Errorf("unknown cloudevents mode %q", config.Mode)
+	}
+
+	return &Envelope{
+		mode:       config.Mode,
+		source:     strings.TrimSuffix(config.Source, "/"),
+		typePrefix: config.TypePrefix,
+	}, nil
+}
+
+// Mode returns the content mode of the envelope
+func (e *Envelope) Mode() string {
+	return e.mode
+}
+
+// Binary reports whether attributes are carried as headers
+func (e *Envelope) Binary() bool {
+	return e.mode == ModeBinary
+}
+
+// Attributes returns the context attributes, extensions included, of the event
+// carrying an entity. Every call produces a new event ID.
+func (e *Envelope) Attributes(ent *entity.Entity) map[string]string {
+	attributes := map[string]string{
+		"specversion": SpecVersion,
+		"id":          uuid.New().String(),
+		"source":      e.source + "/" + ent.Deployment,
+		"type":        e.typePrefix + ent.Type,
+		"subject":     ent.ID,
+	}
+	if !ent.Timestamp.IsZero() {
+		attributes["time"] = ent.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if block, ok := blockNumber(ent.MetaData[entity.MetaBlockNumber]); ok {
+		attributes[ExtensionBlockNumber] = strconv.FormatInt(block, 10)
+	}
+	if runID, ok := ent.MetaData[entity.MetaRunID].(string); ok && runID != "" {
+		attributes[ExtensionRunID] = runID
+	}
+	return attributes
+}
+
+// Structured wraps encoded entity data in a structured mode event. JSON data is
+// embedded as is, any other content type is carried base64 encoded.
+func (e *Envelope) Structured(ent *entity.Entity, data []byte, contentType string) ([]byte, error) {
+	event := make(map[string]interface{}, 11)
+	for name, value := range e.Attributes(ent) {
+		event[name] = value
+	}
+	// The block number extension keeps its integer type in JSON
+	if block, ok := blockNumber(ent.MetaData[entity.MetaBlockNumber]); ok {
+		event[ExtensionBlockNumber] = block
+	}
+	if contentType != "" {
+		event["datacontenttype"] = contentType
+	}
+
+	if isJSON(contentType) && json.Valid(data) {
+		event["data"] = json.RawMessage(data)
+	} else {
+		event["data_base64"] = data
+	}
+
+	wrapped, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling cloudevent: %w", err)
+	}
+	return wrapped, nil
+}
+
+// isJSON reports whether a content type denotes JSON data
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// blockNumber reads the block number metadata, which is a float64 once it went through JSON
+func blockNumber(v interface{}) (int64, bool) {
+	switch number := v.(type) {
+	case int64:
+		return number, number > 0
+	case int:
+		return int64(number), number > 0
+	case float64:
+		return int64(number), number > 0
+	case json.Number:
+		n, err := number.Int64()
+		return n, err == nil && n > 0
+	}
+	return 0, false
+}
@@ -0,0 +1,337 @@
+package concurrency
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Control algorithms
+const (
+	// AlgorithmNone leaves the rate limiter and the worker pool to adjust themselves
+	AlgorithmNone = "none"
+	// AlgorithmGradient scales the limit by the ratio of the baseline latency
+	// to the current latency, plus a small allowance for queuing
+	AlgorithmGradient = "gradient"
+	// AlgorithmAIMD adds one to the limit while latency is normal and cuts it
+	// multiplicatively when latency rises or requests fail
+	AlgorithmAIMD = "aimd"
+)
+
+// Limiter is the rate limiter pacing the requests
+type Limiter interface {
+	// Wait blocks until a request is allowed according to rate limits
+	Wait(ctx context.Context) error
+
+	// UpdateRateLimit updates the rate limit based on API response
+	UpdateRateLimit(rateLimit, remaining int, resetAt time.Time)
+
+	// SetRate sets the request rate and returns the rate applied within
+	// the bounds of the limiter
+	SetRate(rate float64) float64
+
+	// Record records the outcome of a request in the limiter's stats
+	// without adjusting its rate
+	Record(success bool, latency time.Duration)
+}
+
+// Pool is the worker pool running the requests
+type Pool interface {
+	// SetPoolSize dynamically adjusts the worker pool size
+	SetPoolSize(size int)
+}
+
+// Controller sets the request rate of a limiter and the number of workers
+// of a pool from the same latency and error signals, so that they do not
+// work against each other. The limit is the number of requests in flight;
+// the pool runs that many workers and the limiter paces them at the rate
+// they can sustain at the current latency.
+//
+// Controller implements ports.RateLimiter: the requests report to it
+// instead of to the limiter, which no longer adjusts its rate by itself.
+type Controller struct {
+	limiter Limiter
+	pool    Pool
+
+	algorithm    string
+	minLimit     float64
+	maxLimit     float64
+	window       time.Duration
+	minSamples   int
+	tolerance    float64
+	backoff      float64
+	maxErrorRate float64
+	now          func() time.Time
+
+	mu       sync.Mutex
+	limit    float64
+	size     int
+	rate     float64
+	inFlight int
+
+	// rtt is the average latency of the last window and baselineRTT the
+	// latency of the API when it is not loaded: the lowest seen, drifting
+	// up slowly while the limit is at its minimum so that a lasting
+	// slowdown of the API is learnt, but congestion we cause is not
+	rtt         time.Duration
+	baselineRTT time.Duration
+
+	// rateCap caps the rate after failures. Throttled requests fail
+	// without raising the latency, so the limit alone cannot slow them
+	// down; the cap is cut on failures and raised while requests succeed,
+	// and lifted once the limit no longer needs it. Zero means no cap.
+	rateCap float64
+
+	// Samples of the current window
+	windowStart time.Time
+	samples     int
+	failures    int
+	latencySum  time.Duration
+	peakFlight  int
+}
+
+// ControllerConfig holds the configuration for a concurrency controller
+type ControllerConfig struct {
+	// Algorithm is AlgorithmGradient (default) or AlgorithmAIMD
+	Algorithm string
+
+	// InitialLimit, MinLimit and MaxLimit bound the requests in flight
+	// (defaults 4, 1 and 20)
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// Window is how often the limit is updated, once at least MinSamples
+	// requests have completed (defaults 2s and 5)
+	Window     time.Duration
+	MinSamples int
+
+	// Tolerance is how much latency may grow over the baseline before the
+	// limit is lowered (default 1.5)
+	Tolerance float64
+
+	// Backoff multiplies the limit when requests fail (default 0.7)
+	Backoff float64
+
+	// MaxErrorRate is the share of failed requests in a window above which
+	// the limit backs off (default 0.05)
+	MaxErrorRate float64
+
+	// Now returns the current time that windows are measured with
+	// (default time.Now)
+	Now func() time.Time
+}
+
+// NewController creates a new concurrency controller
+func NewController(limiter Limiter, pool Pool, config ControllerConfig) *Controller {
+	// Set defaults for configuration
+	if config.Algorithm == "" {
+		config.Algorithm = AlgorithmGradient
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = 4
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 20
+	}
+	if config.Window <= 0 {
+		config.Window = 2 * time.Second
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = 5
+	}
+	if config.Tolerance < 1 {
+		config.Tolerance = 1.5
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = 0.7
+	}
+	if config.MaxErrorRate <= 0 {
+		config.MaxErrorRate = 0.05
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	// Ensure consistent configuration
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	limit := math.Min(math.Max(float64(config.InitialLimit), float64(config.MinLimit)), float64(config.MaxLimit))
+
+	return &Controller{
+		limiter:      limiter,
+		pool:         pool,
+		algorithm:    config.Algorithm,
+		minLimit:     float64(config.MinLimit),
+		maxLimit:     float64(config.MaxLimit),
+		window:       config.Window,
+		minSamples:   config.MinSamples,
+		tolerance:    config.Tolerance,
+		backoff:      config.Backoff,
+		maxErrorRate: config.MaxErrorRate,
+		now:          config.Now,
+		limit:        limit,
+		size:         int(math.Round(limit)),
+		windowStart:  config.Now(),
+	}
+}
+
+// Wait blocks until the limiter allows a request and counts it in flight
+func (c *Controller) Wait(ctx context.Context) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.inFlight++
+	c.peakFlight = max(c.peakFlight, c.inFlight)
+	c.mu.Unlock()
+	return nil
+}
+
+// Done records the outcome of a request and updates the limit once per window
+func (c *Controller) Done(success bool, latency time.Duration) {
+	// Keep the success rate and latency of the limiter current for its stats
+	c.limiter.Record(success, latency)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inFlight > 0 {
+		c.inFlight--
+	}
+	c.samples++
+	if success {
+		c.latencySum += latency
+	} else {
+		c.failures++
+	}
+
+	now := c.now()
+	if now.Sub(c.windowStart) < c.window || c.samples < c.minSamples {
+		return
+	}
+	c.update()
+	c.windowStart = now
+	c.samples, c.failures, c.latencySum = 0, 0, 0
+	c.peakFlight = c.inFlight
+}
+
+// UpdateRateLimit passes the rate limit reported by the API to the limiter
+func (c *Controller) UpdateRateLimit(rateLimit, remaining int, resetAt time.Time) {
+	c.limiter.UpdateRateLimit(rateLimit, remaining, resetAt)
+}
+
+// update computes the limit from the samples of the window and applies it.
+// It must be called with the lock held.
+func (c *Controller) update() {
+	errorRate := float64(c.failures) / float64(c.samples)
+	if successes := c.samples - c.failures; successes > 0 {
+		c.rtt = c.latencySum / time.Duration(successes)
+		if c.baselineRTT == 0 || c.rtt < c.baselineRTT {
+			c.baselineRTT = c.rtt
+		} else if c.limit <= c.minLimit {
+			c.baselineRTT += (c.rtt - c.baselineRTT) / 20
+		}
+	}
+
+	// Only grow the limit when the requests actually use it
+	saturated := float64(c.peakFlight) >= c.limit/2
+	congested := c.rtt > time.Duration(c.tolerance*float64(c.baselineRTT))
+
+	limit := c.limit
+	switch {
+	case errorRate > c.maxErrorRate:
+		limit *= c.backoff
+		if c.rate > 0 {
+			c.rateCap = c.rate * c.backoff
+		}
+
+	case c.algorithm == AlgorithmAIMD:
+		if congested {
+			limit *= c.backoff
+		} else if saturated {
+			limit++
+		}
+
+	default:
+		gradient := 1.0
+		if c.rtt > 0 {
+			gradient = math.Max(0.5, math.Min(1, c.tolerance*float64(c.baselineRTT)/float64(c.rtt)))
+		}
+		next := limit * gradient
+		if saturated {
+			next += math.Sqrt(limit)
+		}
+		// Smooth the change so that one noisy window does not swing the limit
+		limit = 0.8*limit + 0.2*next
+	}
+	c.limit = math.Max(c.minLimit, math.Min(c.maxLimit, limit))
+	if errorRate <= c.maxErrorRate && c.rateCap > 0 {
+		c.rateCap *= 1.1
+	}
+
+	log.Debug().
+		Str("algorithm", c.algorithm).
+		Float64("limit", c.limit).
+		Float64("errorRate", errorRate).
+		Dur("rtt", c.rtt).
+		Dur("baselineRtt", c.baselineRTT).
+		Int("peakInFlight", c.peakFlight).
+		Msg("Updated concurrency limit")
+
+	c.apply()
+}
+
+// apply sizes the pool to the limit and paces the limiter to the rate the
+// limit sustains at the current latency. It must be called with the lock held.
+func (c *Controller) apply() {
+	if size := int(math.Round(c.limit)); size != c.size {
+		c.size = size
+		c.pool.SetPoolSize(size)
+	}
+
+	if c.rtt > 0 {
+		// Leave some headroom so that the limiter smooths bursts rather
+		// than holding back the requests the limit allows
+		rate := 1.2 * c.limit / c.rtt.Seconds()
+		if c.rateCap > 0 && c.rateCap < rate {
+			rate = c.rateCap
+		} else {
+			c.rateCap = 0
+		}
+		c.rate = c.limiter.SetRate(rate)
+	}
+}
+
+// ControllerStats is a snapshot of the controller's state
+type ControllerStats struct {
+	Algorithm   string
+	Limit       float64
+	InFlight    int
+	Rate        float64
+	RTT         time.Duration
+	BaselineRTT time.Duration
+}
+
+// Stats returns a snapshot of the controller's state
+func (c *Controller) Stats() ControllerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ControllerStats{
+		Algorithm:   c.algorithm,
+		Limit:       c.limit,
+		InFlight:    c.inFlight,
+		Rate:        c.rate,
+		RTT:         c.rtt,
+		BaselineRTT: c.baselineRTT,
+	}
+}
@@ -0,0 +1,241 @@
+//go:build simulation
+
+// The simulations run the controller, an adaptive limiter and a dynamic pool
+// against a fake API over HTTP for several seconds each. They depend on the
+// machine's timing, so they only build with -tags simulation.
+
+package concurrency_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/concurrency"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/ratelimit"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/worker"
+)
+
+// fakeAPI serves requests at a base latency up to its capacity, slows down
+// in proportion to the requests in flight past it, and answers 429 once
+// requests come faster than its rate limit
+type fakeAPI struct {
+	capacity int
+	latency  time.Duration
+	limiter  *rate.Limiter
+	inFlight atomic.Int32
+}
+
+// newFakeAPI creates a fake API
+func newFakeAPI(capacity int, rateLimit float64, latency time.Duration) *fakeAPI {
+	return &fakeAPI{
+		capacity: max(capacity, 1),
+		latency:  latency,
+		limiter:  rate.NewLimiter(rate.Limit(rateLimit), max(int(rateLimit/10), 1)),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.limiter.Allow() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	inFlight := int(a.inFlight.Add(1))
+	defer a.inFlight.Add(-1)
+
+	latency := a.latency
+	if inFlight > a.capacity {
+		latency = latency * time.Duration(inFlight) / time.Duration(a.capacity)
+	}
+	select {
+	case <-time.After(latency):
+		w.Write([]byte(`{"data":{}}`))
+	case <-r.Context().Done():
+	}
+}
+
+// simulation is the outcome of the requests of the second half of a
+// simulation, once the controller had time to converge
+type simulation struct {
+	mu         sync.Mutex
+	measuring  bool
+	ok         int
+	throttled  int
+	latencySum time.Duration
+	limits     []float64
+}
+
+// record counts the outcome of a request
+func (s *simulation) record(success bool, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.measuring {
+		return
+	}
+	if success {
+		s.ok++
+		s.latencySum += latency
+	} else {
+		s.throttled++
+	}
+}
+
+// averageLatency returns the average latency of the successful requests
+func (s *simulation) averageLatency() time.Duration {
+	if s.ok == 0 {
+		return 0
+	}
+	return s.latencySum / time.Duration(s.ok)
+}
+
+// throttledShare returns the share of requests answered with 429
+func (s *simulation) throttledShare() float64 {
+	return float64(s.throttled) / float64(max(s.ok+s.throttled, 1))
+}
+
+// maxLimit returns the highest limit sampled
+func (s *simulation) maxLimit() float64 {
+	var limit float64
+	for _, l := range s.limits {
+		limit = max(limit, l)
+	}
+	return limit
+}
+
+// simulate runs the controller, an adaptive limiter and a dynamic pool
+// against an API, keeping the pool busy with requests like a backlog of
+// page queries
+func simulate(t *testing.T, algorithm string, api *fakeAPI, duration time.Duration) *simulation {
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	const maxWorkers = 32
+	limiter := ratelimit.NewAdaptiveLimiter(ratelimit.AdaptiveLimiterConfig{
+		InitialRate: 5,
+		MaxRate:     400,
+	})
+	pool := worker.NewDynamicPool(worker.PoolConfig{
+		InitialWorkers: 4,
+		MinWorkers:     2,
+		MaxWorkers:     maxWorkers,
+		QueueSize:      maxWorkers,
+		ManualSize:     true,
+	})
+	defer pool.Close()
+	controller := concurrency.NewController(limiter, pool, concurrency.ControllerConfig{
+		Algorithm:    algorithm,
+		InitialLimit: 4,
+		MinLimit:     2,
+		MaxLimit:     maxWorkers,
+		Window:       200 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	result := &simulation{}
+	client := &http.Client{Timeout: 10 * time.Second}
+	group := pool.Group(ctx, false)
+	go func() {
+		for ctx.Err() == nil {
+			group.Go(worker.DefaultClass, func(ctx context.Context) error {
+				if err := controller.Wait(ctx); err != nil {
+					return nil
+				}
+				start := time.Now()
+				request, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+				if err != nil {
+					return err
+				}
+				response, err := client.Do(request)
+				latency := time.Since(start)
+				success := err == nil && response.StatusCode == http.StatusOK
+				if err == nil {
+					response.Body.Close()
+				}
+				if ctx.Err() != nil {
+					return nil
+				}
+				controller.Done(success, latency)
+				result.record(success, latency)
+				return nil
+			})
+		}
+	}()
+
+	// Measure the second half, sampling the limit every window
+	time.Sleep(duration / 2)
+	result.mu.Lock()
+	result.measuring = true
+	result.mu.Unlock()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			group.Wait()
+			result.mu.Lock()
+			defer result.mu.Unlock()
+			t.Logf("%s: limit %.1f, %d served, %.1f%% throttled, average latency %s",
+				algorithm, controller.Stats().Limit, result.ok, 100*result.throttledShare(), result.averageLatency())
+			return result
+		case <-ticker.C:
+			result.mu.Lock()
+			result.limits = append(result.limits, controller.Stats().Limit)
+			result.mu.Unlock()
+		}
+	}
+}
+
+func TestSimulationConvergesUnderCapacity(t *testing.T) {
+	const capacity = 8
+	const latency = 20 * time.Millisecond
+	for _, algorithm := range []string{concurrency.AlgorithmGradient, concurrency.AlgorithmAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			// Past its capacity the API only gets slower, so the limit
+			// must settle near the capacity instead of the pool maximum
+			result := simulate(t, algorithm, newFakeAPI(capacity, 10000, latency), 8*time.Second)
+
+			if limit := result.maxLimit(); limit > 3*capacity {
+				t.Errorf("limit reached %.1f, want at most %d", limit, 3*capacity)
+			}
+			if avg := result.averageLatency(); avg > 3*latency {
+				t.Errorf("average latency %s, want at most %s", avg, 3*latency)
+			}
+			if result.ok == 0 {
+				t.Error("no request was served")
+			}
+		})
+	}
+}
+
+func TestSimulationBacksOffOnThrottling(t *testing.T) {
+	const rateLimit = 60
+	const duration = 8 * time.Second
+	for _, algorithm := range []string{concurrency.AlgorithmGradient, concurrency.AlgorithmAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			// The API never slows down but throttles past its rate limit,
+			// so only the 429s hold the limit back
+			result := simulate(t, algorithm, newFakeAPI(1000, rateLimit, 20*time.Millisecond), duration)
+
+			if share := result.throttledShare(); share > 0.15 {
+				t.Errorf("%.0f%% of the requests were throttled, want at most 15%%", 100*share)
+			}
+			if limit := result.maxLimit(); limit >= 32 {
+				t.Errorf("limit reached %.1f, want it held below the pool maximum", limit)
+			}
+			// Backing off must not leave the API's rate limit unused
+			if served := float64(result.ok) / (duration / 2).Seconds(); served < rateLimit/2 {
+				t.Errorf("served %.1f requests per second, want at least %d", served, rateLimit/2)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package concurrency_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/concurrency"
+)
+
+// fakeClock is a clock only moved by the test
+type fakeClock struct {
+	now time.Time
+}
+
+// Now returns the time of the clock
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeLimiter records the rate set by the controller and the outcomes
+// passed to it
+type fakeLimiter struct {
+	rate    float64
+	records int
+}
+
+func (l *fakeLimiter) Wait(ctx context.Context) error {
+	return nil
+}
+
+func (l *fakeLimiter) UpdateRateLimit(rateLimit, remaining int, resetAt time.Time) {}
+
+func (l *fakeLimiter) SetRate(rate float64) float64 {
+	l.rate = math.Max(1, math.Min(400, rate))
+	return l.rate
+}
+
+func (l *fakeLimiter) Record(success bool, latency time.Duration) {
+	l.records++
+}
+
+// fakePool records the size set by the controller
+type fakePool struct {
+	size int
+}
+
+func (p *fakePool) SetPoolSize(size int) {
+	p.size = size
+}
+
+// window is the outcome of the requests of one window of a model API
+type window struct {
+	requests int
+	failures int
+	latency  time.Duration
+}
+
+// model returns the requests a model API serves in a window given the
+// limit on requests in flight and the rate of the limiter
+type model func(limit int, rate float64) window
+
+const testWindow = 200 * time.Millisecond
+
+// newTestController creates a controller measuring its windows on clock
+func newTestController(algorithm string, clock *fakeClock) (*concurrency.Controller, *fakeLimiter, *fakePool) {
+	limiter := &fakeLimiter{rate: 5}
+	pool := &fakePool{size: 4}
+	controller := concurrency.NewController(limiter, pool, concurrency.ControllerConfig{
+		Algorithm:    algorithm,
+		InitialLimit: 4,
+		MinLimit:     2,
+		MaxLimit:     32,
+		Window:       testWindow,
+		Now:          clock.Now,
+	})
+	return controller, limiter, pool
+}
+
+// drive reports windows of synthetic requests to the controller, with as
+// many requests in flight at once as the limit allows, and returns the
+// windows of the second half, once the controller had time to converge
+func drive(t *testing.T, controller *concurrency.Controller, limiter *fakeLimiter, clock *fakeClock, api model, windows int) []window {
+	t.Helper()
+
+	var measured []window
+	for i := 0; i < windows; i++ {
+		limit := int(math.Round(controller.Stats().Limit))
+		w := api(limit, limiter.rate)
+
+		// The requests of the window overlap up to the limit
+		for sent := 0; sent < w.requests; sent += limit {
+			batch := min(limit, w.requests-sent)
+			for j := 0; j < batch; j++ {
+				if err := controller.Wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for j := 0; j < batch; j++ {
+				controller.Done(sent+j >= w.failures, w.latency)
+			}
+		}
+		clock.now = clock.now.Add(testWindow)
+
+		if i >= windows/2 {
+			measured = append(measured, w)
+		}
+	}
+	return measured
+}
+
+// capacityAPI serves requests at a base latency up to its capacity and
+// slows down in proportion to the requests in flight past it
+func capacityAPI(capacity int, latency time.Duration) model {
+	return func(limit int, rate float64) window {
+		rtt := latency
+		if limit > capacity {
+			rtt = latency * time.Duration(limit) / time.Duration(capacity)
+		}
+		served := min(rate, float64(limit)/rtt.Seconds())
+		return window{requests: max(int(served*testWindow.Seconds()), 5), latency: rtt}
+	}
+}
+
+// throttlingAPI never slows down but fails the requests sent faster than
+// its rate limit
+func throttlingAPI(rateLimit float64, latency time.Duration) model {
+	return func(limit int, rate float64) window {
+		offered := min(rate, float64(limit)/latency.Seconds())
+		requests := max(int(offered*testWindow.Seconds()), 5)
+		failures := 0
+		if offered > rateLimit {
+			failures = int(math.Round(float64(requests) * (1 - rateLimit/offered)))
+		}
+		return window{requests: requests, failures: failures, latency: latency}
+	}
+}
+
+func TestControllerConvergesUnderCapacity(t *testing.T) {
+	const capacity = 8
+	const latency = 20 * time.Millisecond
+	for _, algorithm := range []string{concurrency.AlgorithmGradient, concurrency.AlgorithmAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			controller, limiter, pool := newTestController(algorithm, clock)
+
+			// Past its capacity the API only gets slower, so the limit must
+			// settle near the capacity instead of the pool maximum
+			measured := drive(t, controller, limiter, clock, capacityAPI(capacity, latency), 200)
+
+			if limit := controller.Stats().Limit; limit < capacity/2 || limit > 3*capacity {
+				t.Errorf("limit settled at %.1f, want between %d and %d", limit, capacity/2, 3*capacity)
+			}
+			for _, w := range measured {
+				if w.latency > 3*latency {
+					t.Fatalf("latency reached %s, want at most %s", w.latency, 3*latency)
+				}
+			}
+			if want := int(math.Round(controller.Stats().Limit)); pool.size != want {
+				t.Errorf("pool size %d, want the limit %d", pool.size, want)
+			}
+		})
+	}
+}
+
+func TestControllerBacksOffOnThrottling(t *testing.T) {
+	const rateLimit = 60
+	for _, algorithm := range []string{concurrency.AlgorithmGradient, concurrency.AlgorithmAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			controller, limiter, _ := newTestController(algorithm, clock)
+
+			// The API never slows down, so only the failures hold the limit
+			// and the rate back
+			measured := drive(t, controller, limiter, clock, throttlingAPI(rateLimit, 20*time.Millisecond), 200)
+
+			var requests, failures int
+			for _, w := range measured {
+				requests += w.requests
+				failures += w.failures
+			}
+			if share := float64(failures) / float64(requests); share > 0.15 {
+				t.Errorf("%.0f%% of the requests failed, want at most 15%%", 100*share)
+			}
+			if limit := controller.Stats().Limit; limit >= 32 {
+				t.Errorf("limit settled at %.1f, want it held below the pool maximum", limit)
+			}
+
+			// Backing off must not leave the API's rate limit unused
+			duration := time.Duration(len(measured)) * testWindow
+			if served := float64(requests-failures) / duration.Seconds(); served < rateLimit/2 {
+				t.Errorf("served %.1f requests per second, want at least %d", served, rateLimit/2)
+			}
+		})
+	}
+}
+
+func TestControllerWaitsForAFullWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	controller, limiter, pool := newTestController(concurrency.AlgorithmAIMD, clock)
+
+	// Samples within the window leave the limit alone, even with every
+	// worker busy
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			controller.Wait(context.Background())
+		}
+		for j := 0; j < 4; j++ {
+			controller.Done(true, 20*time.Millisecond)
+		}
+	}
+	if limit := controller.Stats().Limit; limit != 4 {
+		t.Fatalf("limit %.1f before the window ended, want 4", limit)
+	}
+
+	// The next sample after the window updates it
+	clock.now = clock.now.Add(testWindow)
+	controller.Wait(context.Background())
+	controller.Done(true, 20*time.Millisecond)
+	if stats := controller.Stats(); stats.Limit != 5 || stats.RTT != 20*time.Millisecond {
+		t.Fatalf("limit %.1f and rtt %s after the window, want 5 and 20ms", stats.Limit, stats.RTT)
+	}
+	if pool.size != 5 {
+		t.Errorf("pool size %d, want 5", pool.size)
+	}
+
+	// Every outcome reaches the limiter's stats
+	if limiter.records != 13 {
+		t.Errorf("limiter recorded %d outcomes, want 13", limiter.records)
+	}
+}
@@ -0,0 +1,152 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// SpoolSink is an adapter that implements the ports.DeadLetterSink interface
+// by writing each dead letter to its own JSON file in a spool directory
+type SpoolSink struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// SpoolConfig holds the configuration for the spool sink
+type SpoolConfig struct {
+	Dir string
+}
+
+// NewSpoolSink creates a new spool directory sink
+func NewSpoolSink(config SpoolConfig) (*SpoolSink, error) {
+	// Set default directory if not provided
+	if config.Dir == "" {
+		config.Dir = filepath.Join("data", "deadletter")
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory %s: %w", config.Dir, err)
+	}
+
+	return &SpoolSink{dir: config.Dir}, nil
+}
+
+// Send stores a dead letter in the spool directory
+func (s *SpoolSink) Send(ctx context.Context, letter *entity.DeadLetter) error {
+	// Name files by failure time so replay preserves the original order
+	name := fmt.Sprintf("%020d-%s.json", letter.FailedAt.UnixNano(), uuid.New().String())
+	if err := s.write(filepath.Join(s.dir, name), letter); err != nil {
+		return err
+	}
+
+	log.Warn().
+		Str("topic", letter.Topic).
+		Str("key", letter.Key).
+		Int("attempts", letter.Attempts).
+		Str("file", name).
+		Msg("Spooled dead letter")
+
+	return nil
+}
+
+// write atomically writes a dead letter file
+func (s *SpoolSink) write(path string, letter *entity.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("error marshaling dead letter: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing dead letter: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error renaming dead letter: %w", err)
+	}
+	return nil
+}
+
+// Replay hands spooled dead letters to publish in failure order. Accepted
+// letters are deleted; rejected ones are kept with an incremented attempt count.
+func (s *SpoolSink) Replay(ctx context.Context, publish func(ctx context.Context, letter *entity.DeadLetter) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dead letter directory: %w", err)
+	}
+
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	replayed := 0
+	failed := 0
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		path := filepath.Join(s.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return replayed, fmt.Errorf("error reading dead letter %s: %w", name, err)
+		}
+
+		var letter entity.DeadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			log.Error().
+				Str("file", name).
+				Err(err).
+				Msg("Skipping unreadable dead letter")
+			continue
+		}
+
+		if err := publish(ctx, &letter); err != nil {
+			failed++
+			letter.Attempts++
+			letter.Error = err.Error()
+			if werr := s.write(path, &letter); werr != nil {
+				return replayed, werr
+			}
+			log.Warn().
+				Str("file", name).
+				Int("attempts", letter.Attempts).
+				Err(err).
+				Msg("Dead letter replay failed")
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return replayed, fmt.Errorf("error removing replayed dead letter %s: %w", name, err)
+		}
+		replayed++
+	}
+
+	if failed > 0 {
+		return replayed, fmt.Errorf("%d dead letters could not be replayed", failed)
+	}
+	return replayed, nil
+}
+
+// Close closes the sink
+func (s *SpoolSink) Close() error {
+	// Nothing to close for the spool sink
+	return nil
+}
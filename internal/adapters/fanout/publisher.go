@@ -0,0 +1,320 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// Sink is a publisher receiving every message of the fan-out publisher
+type Sink struct {
+	Name      string
+	Publisher ports.EventPublisher
+
+	// Required sinks fail the publish when they fail. Failures of best-effort
+	// sinks are logged and otherwise ignored.
+	Required bool
+}
+
+// topicEnsurer is implemented by sinks that provision their topics
+type topicEnsurer interface {
+	EnsureTopics(ctx context.Context, topics []string) error
+}
+
+// pinger is implemented by sinks that can check their connection
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Publisher is an adapter that implements the ports.EventPublisher interface by
+// publishing every message to several sinks concurrently
+type Publisher struct {
+	sinks []Sink
+}
+
+// NewPublisher creates a new fan-out publisher
+func NewPublisher(sinks ...Sink) (*Publisher, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no sinks configured")
+	}
+
+	required := 0
+	for i := range sinks {
+		if sinks[i].Name == "" {
+			sinks[i].Name = fmt.Sprintf("sink-%d", i)
+		}
+		if sinks[i].Required {
+			required++
+		}
+	}
+	if required == 0 {
+		log.Warn().Msg("No required sinks configured, publishes never fail")
+	}
+
+	return &Publisher{sinks: sinks}, nil
+}
+
+// EnsureTopics provisions the topics on every sink that supports it. Only
+// required sinks fail the call.
+func (p *Publisher) EnsureTopics(ctx context.Context, topics []string) error {
+	for _, sink := range p.sinks {
+		ensurer, ok := sink.Publisher.(topicEnsurer)
+		if !ok {
+			continue
+		}
+		if err := ensurer.EnsureTopics(ctx, topics); err != nil {
+			if sink.Required {
+				return fmt.Errorf("sink %s: %w", sink.Name, err)
+			}
+			log.Warn().
+				Str("sink", sink.Name).
+				Err(err).
+				Msg("Failed to prepare topics of best-effort sink")
+		}
+	}
+	return nil
+}
+
+// Ping checks every required sink that supports it
+func (p *Publisher) Ping(ctx context.Context) error {
+	for _, sink := range p.sinks {
+		pinger, ok := sink.Publisher.(pinger)
+		if !ok || !sink.Required {
+			continue
+		}
+		if err := pinger.Ping(ctx); err != nil {
+			return fmt.Errorf("sink %s: %w", sink.Name, err)
+		}
+	}
+	return nil
+}
+
+// PublishEntity publishes an entity to every sink
+func (p *Publisher) PublishEntity(ctx context.Context, e *entity.Entity, topic string) error {
+	errs := p.each(ctx, func(ctx context.Context, sink ports.EventPublisher) error {
+		return sink.PublishEntity(ctx, e, topic)
+	})
+	return p.required(topic, errs)
+}
+
+// PublishRaw publishes raw data to every sink
+func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	errs := p.each(ctx, func(ctx context.Context, sink ports.EventPublisher) error {
+		return sink.PublishRaw(ctx, key, data, topic)
+	})
+	return p.required(topic, errs)
+}
+
+// PublishBatch publishes a batch to every sink. An entity fails if any required
+// sink failed to publish it, reported as a *ports.BatchError.
+func (p *Publisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	all := make([]int, len(entities))
+	for i := range all {
+		all[i] = i
+	}
+	targets := make([][]int, len(p.sinks))
+	for i := range targets {
+		targets[i] = all
+	}
+	return p.publishTo(ctx, entities, topic, targets)
+}
+
+// RetryBatch republishes entities to the required sinks that failed them
+// according to causes, so that no sink receives an entity twice. Entities
+// whose cause does not name the sinks are retried on every required sink.
+func (p *Publisher) RetryBatch(ctx context.Context, entities []*entity.Entity, causes []error, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	targets := make([][]int, len(p.sinks))
+	for i := range entities {
+		var sinkErr *entityError
+		if len(causes) == len(entities) && errors.As(causes[i], &sinkErr) {
+			for _, sink := range sinkErr.sinks {
+				targets[sink] = append(targets[sink], i)
+			}
+			continue
+		}
+		for sink := range p.sinks {
+			if p.sinks[sink].Required {
+				targets[sink] = append(targets[sink], i)
+			}
+		}
+	}
+	return p.publishTo(ctx, entities, topic, targets)
+}
+
+// publishTo publishes entities to the sinks concurrently; targets[i] holds the
+// indexes of the entities sink i publishes. Entities that a required sink
+// failed to publish are reported in a *ports.BatchError by an *entityError
+// naming the sinks.
+func (p *Publisher) publishTo(ctx context.Context, entities []*entity.Entity, topic string, targets [][]int) error {
+	errs := make([]error, len(p.sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range p.sinks {
+		if len(targets[i]) == 0 {
+			continue
+		}
+
+		batch := entities
+		if len(targets[i]) != len(entities) {
+			batch = make([]*entity.Entity, len(targets[i]))
+			for j, index := range targets[i] {
+				batch[j] = entities[index]
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, sink Sink, batch []*entity.Entity) {
+			defer wg.Done()
+			errs[i] = sink.Publisher.PublishBatch(ctx, batch, topic)
+		}(i, sink, batch)
+	}
+	wg.Wait()
+
+	failures := make([]*entityError, len(entities))
+	failed := false
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		sink := p.sinks[i]
+		if !sink.Required {
+			logBestEffort(sink.Name, topic, err)
+			continue
+		}
+
+		var sinkErr *ports.BatchError
+		perEntity := errors.As(err, &sinkErr) && len(sinkErr.Errors) == len(targets[i])
+		for j, index := range targets[i] {
+			entityErr := err
+			if perEntity {
+				if entityErr = sinkErr.Errors[j]; entityErr == nil {
+					continue
+				}
+			}
+			if failures[index] == nil {
+				failures[index] = &entityError{}
+			}
+			failures[index].add(i, sink.Name, entityErr)
+			failed = true
+		}
+	}
+	if !failed {
+		return nil
+	}
+
+	batchErr := &ports.BatchError{Errors: make([]error, len(entities))}
+	for i, failure := range failures {
+		if failure != nil {
+			batchErr.Errors[i] = failure
+		}
+	}
+	return batchErr
+}
+
+// entityError is the error of an entity that required sinks failed to
+// publish. It records the sinks so that retries only go to them.
+type entityError struct {
+	sinks []int
+	names []string
+	errs  []error
+}
+
+// add records the failure of a sink
+func (e *entityError) add(sink int, name string, err error) {
+	e.sinks = append(e.sinks, sink)
+	e.names = append(e.names, name)
+	e.errs = append(e.errs, err)
+}
+
+// Error lists the failure of every sink
+func (e *entityError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = fmt.Sprintf("sink %s: %v", e.names[i], err)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap exposes the errors of the sinks to errors.Is and errors.As
+func (e *entityError) Unwrap() []error {
+	return e.errs
+}
+
+// each runs publish against every sink concurrently and returns the errors aligned with the sinks
+func (p *Publisher) each(ctx context.Context, publish func(ctx context.Context, sink ports.EventPublisher) error) []error {
+	errs := make([]error, len(p.sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range p.sinks {
+		wg.Add(1)
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			errs[i] = publish(ctx, sink.Publisher)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// required logs best-effort failures and returns the first required sink failure
+func (p *Publisher) required(topic string, errs []error) error {
+	var first error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		sink := p.sinks[i]
+		if !sink.Required {
+			logBestEffort(sink.Name, topic, err)
+			continue
+		}
+		if first == nil {
+			first = fmt.Errorf("sink %s: %w", sink.Name, err)
+		}
+	}
+	return first
+}
+
+// logBestEffort logs the failure of a best-effort sink
+func logBestEffort(name, topic string, err error) {
+	log.Warn().
+		Str("sink", name).
+		Str("topic", topic).
+		Err(err).
+		Msg("Best-effort sink failed to publish")
+}
+
+// Close closes every sink
+func (p *Publisher) Close() error {
+	var errors []error
+	for _, sink := range p.sinks {
+		if err := sink.Publisher.Close(); err != nil {
+			log.Error().
+				Str("sink", sink.Name).
+				Err(err).
+				Msg("Error closing sink")
+			errors = append(errors, fmt.Errorf("error closing sink %s: %w", sink.Name, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("failed to close %d sinks: %w", len(errors), errors[0])
+	}
+	return nil
+}
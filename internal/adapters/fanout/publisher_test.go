@@ -0,0 +1,215 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// recordingSink records the batches it receives and fails the entities
+// listed in failing, or whole batches with batchErr
+type recordingSink struct {
+	mu       sync.Mutex
+	batches  [][]string
+	failing  map[string]bool
+	batchErr error
+}
+
+func (s *recordingSink) PublishEntity(ctx context.Context, e *entity.Entity, topic string) error {
+	return nil
+}
+
+func (s *recordingSink) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	return nil
+}
+
+func (s *recordingSink) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, len(entities))
+	batchErr := &ports.BatchError{Errors: make([]error, len(entities))}
+	failed := false
+	for i, e := range entities {
+		ids[i] = e.ID
+		if s.failing[e.ID] {
+			batchErr.Errors[i] = errors.New("write failed")
+			failed = true
+		}
+	}
+	s.batches = append(s.batches, ids)
+
+	if s.batchErr != nil {
+		return s.batchErr
+	}
+	if failed {
+		return batchErr
+	}
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+// received returns the IDs of the entities of every batch received so far
+func (s *recordingSink) received() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...)
+}
+
+// fail sets the entities the sink fails from now on
+func (s *recordingSink) fail(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		s.failing[id] = true
+	}
+}
+
+func entities(ids ...string) []*entity.Entity {
+	result := make([]*entity.Entity, len(ids))
+	for i, id := range ids {
+		result[i] = &entity.Entity{ID: id}
+	}
+	return result
+}
+
+// failures returns the failed entities of a publish with their errors
+func failures(t *testing.T, batch []*entity.Entity, err error) ([]*entity.Entity, []error) {
+	t.Helper()
+
+	var batchErr *ports.BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != len(batch) {
+		t.Fatalf("expected a batch error aligned with %d entities, got %v", len(batch), err)
+	}
+	var failed []*entity.Entity
+	var causes []error
+	for _, i := range batchErr.Failed() {
+		failed = append(failed, batch[i])
+		causes = append(causes, batchErr.Errors[i])
+	}
+	return failed, causes
+}
+
+func TestPublishBatchIgnoresBestEffortFailures(t *testing.T) {
+	required := &recordingSink{}
+	bestEffort := &recordingSink{batchErr: errors.New("unreachable")}
+	publisher, err := NewPublisher(
+		Sink{Name: "kafka", Publisher: required, Required: true},
+		Sink{Name: "webhook", Publisher: bestEffort},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := publisher.PublishBatch(context.Background(), entities("a", "b"), "topic"); err != nil {
+		t.Fatalf("expected best-effort failures to be ignored, got %v", err)
+	}
+	if got := required.received(); !reflect.DeepEqual(got, [][]string{{"a", "b"}}) {
+		t.Fatalf("unexpected batches on the required sink: %v", got)
+	}
+}
+
+func TestPublishBatchReportsRequiredFailures(t *testing.T) {
+	partial := &recordingSink{failing: map[string]bool{"b": true}}
+	down := &recordingSink{batchErr: errors.New("unreachable")}
+	publisher, err := NewPublisher(
+		Sink{Name: "kafka", Publisher: partial, Required: true},
+		Sink{Name: "nats", Publisher: down, Required: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := entities("a", "b")
+	failed, causes := failures(t, batch, publisher.PublishBatch(context.Background(), batch, "topic"))
+	if len(failed) != 2 {
+		t.Fatalf("expected both entities to fail, got %d", len(failed))
+	}
+	if msg := causes[0].Error(); msg != "sink nats: unreachable" {
+		t.Fatalf("unexpected error of a: %s", msg)
+	}
+	if msg := causes[1].Error(); !strings.Contains(msg, "sink kafka: write failed") || !strings.Contains(msg, "sink nats: unreachable") {
+		t.Fatalf("expected the error of b to name both sinks, got %s", msg)
+	}
+}
+
+func TestRetryBatchOnlyRepublishesToFailedRequiredSinks(t *testing.T) {
+	kafka := &recordingSink{failing: map[string]bool{"a": true}}
+	nats := &recordingSink{failing: map[string]bool{"b": true}}
+	webhook := &recordingSink{failing: map[string]bool{"c": true}}
+	publisher, err := NewPublisher(
+		Sink{Name: "kafka", Publisher: kafka, Required: true},
+		Sink{Name: "nats", Publisher: nats, Required: true},
+		Sink{Name: "webhook", Publisher: webhook},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// a fails on kafka and b on nats; c only fails on the best-effort sink
+	batch := entities("a", "b", "c")
+	failed, causes := failures(t, batch, publisher.PublishBatch(ctx, batch, "topic"))
+	if len(failed) != 2 || failed[0].ID != "a" || failed[1].ID != "b" {
+		t.Fatalf("expected a and b to fail, got %v", failed)
+	}
+
+	// The retry succeeds on kafka and fails b on nats again
+	kafka.fail()
+	failed, causes = failures(t, failed, publisher.RetryBatch(ctx, failed, causes, "topic"))
+	if len(failed) != 1 || failed[0].ID != "b" {
+		t.Fatalf("expected b to fail again, got %v", failed)
+	}
+	if msg := causes[0].Error(); msg != "sink nats: write failed" {
+		t.Fatalf("unexpected error of b: %s", msg)
+	}
+
+	// The last retry succeeds
+	nats.fail()
+	if err := publisher.RetryBatch(ctx, failed, causes, "topic"); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+
+	if got := kafka.received(); !reflect.DeepEqual(got, [][]string{{"a", "b", "c"}, {"a"}}) {
+		t.Fatalf("unexpected batches on kafka: %v", got)
+	}
+	if got := nats.received(); !reflect.DeepEqual(got, [][]string{{"a", "b", "c"}, {"b"}, {"b"}}) {
+		t.Fatalf("unexpected batches on nats: %v", got)
+	}
+	if got := webhook.received(); !reflect.DeepEqual(got, [][]string{{"a", "b", "c"}}) {
+		t.Fatalf("expected the best-effort sink to receive no retry, got %v", got)
+	}
+}
+
+func TestRetryBatchWithoutSinkCausesUsesEveryRequiredSink(t *testing.T) {
+	kafka := &recordingSink{}
+	webhook := &recordingSink{}
+	publisher, err := NewPublisher(
+		Sink{Name: "kafka", Publisher: kafka, Required: true},
+		Sink{Name: "webhook", Publisher: webhook},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := entities("a")
+	if err := publisher.RetryBatch(context.Background(), batch, []error{errors.New("timeout")}, "topic"); err != nil {
+		t.Fatal(err)
+	}
+	if got := kafka.received(); !reflect.DeepEqual(got, [][]string{{"a"}}) {
+		t.Fatalf("unexpected batches on kafka: %v", got)
+	}
+	if got := webhook.received(); len(got) != 0 {
+		t.Fatalf("expected the best-effort sink to receive no retry, got %v", got)
+	}
+}
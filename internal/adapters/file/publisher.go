@@ -0,0 +1,183 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+)
+
+// line is a published message as stored in a topic file
+type line struct {
+	Key         string          `json:"key"`
+	PublishedAt time.Time       `json:"published_at"`
+	Value       json.RawMessage `json:"value"`
+}
+
+// Publisher is an adapter that implements the ports.EventPublisher interface by
+// appending messages as JSON lines to one file per topic
+type Publisher struct {
+	dir    string
+	router *routing.Router
+	files  map[string]*os.File
+	mu     sync.Mutex
+}
+
+// PublisherConfig holds the configuration for the file publisher
+type PublisherConfig struct {
+	Dir string
+
+	// Router supplies message keys. Entities are keyed by ID when nil.
+	Router *routing.Router
+}
+
+// NewPublisher creates a new file publisher
+func NewPublisher(config PublisherConfig) (*Publisher, error) {
+	// Set default directory if not provided
+	if config.Dir == "" {
+		config.Dir = filepath.Join("data", "events")
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create events directory %s: %w", config.Dir, err)
+	}
+
+	return &Publisher{
+		dir:    config.Dir,
+		router: config.Router,
+		files:  make(map[string]*os.File),
+	}, nil
+}
+
+// EnsureTopics has nothing to provision; topic files are created on first write
+func (p *Publisher) EnsureTopics(ctx context.Context, topics []string) error {
+	return nil
+}
+
+// Ping always succeeds; files are opened when first written
+func (p *Publisher) Ping(ctx context.Context) error {
+	return nil
+}
+
+// key returns the message key of an entity
+func (p *Publisher) key(e *entity.Entity) string {
+	if p.router == nil {
+		return e.ID
+	}
+	return p.router.Key(e)
+}
+
+// PublishEntity appends an entity to its topic file
+func (p *Publisher) PublishEntity(ctx context.Context, e *entity.Entity, topic string) error {
+	return p.PublishBatch(ctx, []*entity.Entity{e}, topic)
+}
+
+// PublishRaw appends raw data to its topic file
+func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	value := json.RawMessage(data)
+	if !json.Valid(data) {
+		// Store non-JSON payloads as a JSON string
+		quoted, err := json.Marshal(string(data))
+		if err != nil {
+			return fmt.Errorf("error marshaling raw payload: %w", err)
+		}
+		value = quoted
+	}
+
+	return p.append(topic, []line{{Key: key, PublishedAt: time.Now().UTC(), Value: value}})
+}
+
+// PublishBatch appends a batch of entities to their topic file with a single write
+func (p *Publisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	lines := make([]line, 0, len(entities))
+	for _, e := range entities {
+		data, err := e.MarshalForEvent()
+		if err != nil {
+			return fmt.Errorf("error marshaling entity %s: %w", e.ID, err)
+		}
+		lines = append(lines, line{Key: p.key(e), PublishedAt: now, Value: data})
+	}
+
+	return p.append(topic, lines)
+}
+
+// append writes lines to a topic file
+func (p *Publisher) append(topic string, lines []line) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, l := range lines {
+		if err := encoder.Encode(l); err != nil {
+			return fmt.Errorf("error marshaling line: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	file, err := p.getOrOpenFile(topic)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing to %s: %w", file.Name(), err)
+	}
+
+	log.Debug().
+		Str("topic", topic).
+		Int("messages", len(lines)).
+		Msg("Published to file")
+
+	return nil
+}
+
+// getOrOpenFile returns the open file of a topic. It must be called with the lock held.
+func (p *Publisher) getOrOpenFile(topic string) (*os.File, error) {
+	if file, ok := p.files[topic]; ok {
+		return file, nil
+	}
+
+	if topic == "" || filepath.Base(topic) != topic || topic == "." || topic == ".." {
+		return nil, fmt.Errorf("invalid topic %q for file publisher", topic)
+	}
+
+	path := filepath.Join(p.dir, topic+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	p.files[topic] = file
+	return file, nil
+}
+
+// Close closes all topic files
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errors []error
+	for topic, file := range p.files {
+		if err := file.Close(); err != nil {
+			errors = append(errors, fmt.Errorf("error closing file for %s: %w", topic, err))
+		}
+	}
+	p.files = make(map[string]*os.File)
+
+	if len(errors) > 0 {
+		return fmt.Errorf("failed to close %d files: %v", len(errors), errors[0])
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/machinebox/graphql"
@@ -12,11 +13,14 @@ import (
 
 // Client is an adapter for the GraphQL client that implements the ports.GraphQLClient interface
 type Client struct {
-	client    *graphql.Client
-	endpoint  string
 	authToken string
 	headers   map[string]string
 	httpClient *http.Client
+
+	// clients holds one GraphQL client per endpoint, so that tasks of
+	// different endpoints can query at the same time
+	mu      sync.Mutex
+	clients map[string]*graphql.Client
 }
 
 // ClientConfig holds the configuration for the GraphQL client
@@ -48,22 +52,30 @@ func NewClient(config ClientConfig) *Client {
 		authToken: config.AuthToken,
 		headers:   config.ExtraHeaders,
 		httpClient: httpClient,
+		clients:   make(map[string]*graphql.Client),
 	}
 }
 
-// SetEndpoint configures the client to use a specific endpoint
-func (c *Client) SetEndpoint(endpoint string) {
-	c.endpoint = endpoint
-	c.client = graphql.NewClient(
-		fmt.Sprintf("https://gateway.thegraph.com/api/subgraphs/id/%s", endpoint),
-		graphql.WithHTTPClient(c.httpClient),
-	)
+// endpointClient returns the GraphQL client of an endpoint, creating it on first use
+func (c *Client) endpointClient(endpoint string) *graphql.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client, ok := c.clients[endpoint]
+	if !ok {
+		client = graphql.NewClient(
+			fmt.Sprintf("https://gateway.thegraph.com/api/subgraphs/id/%s", endpoint),
+			graphql.WithHTTPClient(c.httpClient),
+		)
+		c.clients[endpoint] = client
+	}
+	return client
 }
 
-// Query executes a GraphQL query and returns the result
-func (c *Client) Query(ctx context.Context, query string, variables map[string]interface{}, response interface{}) error {
-	if c.client == nil {
-		return fmt.Errorf("client endpoint not set, call SetEndpoint first")
+// Query executes a GraphQL query against an endpoint and returns the result
+func (c *Client) Query(ctx context.Context, endpoint, query string, variables map[string]interface{}, response interface{}) error {
+	if endpoint == "" {
+		return fmt.Errorf("no endpoint given for GraphQL query")
 	}
 	
 	// Create GraphQL request
@@ -88,19 +100,19 @@ func (c *Client) Query(ctx context.Context, query string, variables map[string]i
 	
 	// Log the query (debug level)
 	log.Debug().
-		Str("endpoint", c.endpoint).
+		Str("endpoint", endpoint).
 		Str("query", query).
 		Interface("variables", variables).
 		Msg("Executing GraphQL query")
 	
 	// Execute the query
 	startTime := time.Now()
-	err := c.client.Run(ctx, request, response)
+	err := c.endpointClient(endpoint).Run(ctx, request, response)
 	duration := time.Since(startTime)
 	
 	if err != nil {
 		log.Error().
-			Str("endpoint", c.endpoint).
+			Str("endpoint", endpoint).
 			Str("query", query).
 			Err(err).
 			Dur("duration", duration).
@@ -109,7 +121,7 @@ func (c *Client) Query(ctx context.Context, query string, variables map[string]i
 	}
 	
 	log.Debug().
-		Str("endpoint", c.endpoint).
+		Str("endpoint", endpoint).
 		Dur("duration", duration).
 		Msg("GraphQL query completed successfully")
 	
@@ -190,12 +190,12 @@ func (g *QueryGenerator) LoadQueryVariants(queryVariants map[string]map[string]s
 	}
 }
 
-// AddMetaDeploymentToQueries modifies queries to include _meta { deployment } field
+// AddMetaDeploymentToQueries modifies queries to include the _meta { deployment block { number } } field
 func (g *QueryGenerator) AddMetaDeploymentToQueries() {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	
-	// Add _meta { deployment } to all query templates
+	// Add _meta { deployment block { number } } to all query templates
 	for queryType, templates := range g.queryTemplates {
 		for endpoint, query := range templates {
 			// Check if query already has _meta
@@ -203,9 +203,9 @@ func (g *QueryGenerator) AddMetaDeploymentToQueries() {
 				// Find the closing bracket of the query
 				lastBraceIndex := strings.LastIndex(query, "}")
 				if lastBraceIndex >= 0 {
-					// Insert _meta { deployment } before the last closing brace
+					// Insert _meta { deployment block { number } } before the last closing brace
 					modifiedQuery := query[:lastBraceIndex] + 
-						"\n  _meta {\n    deployment\n  }\n" + 
+						"\n  _meta {\n    deployment\n    block {\n      number\n    }\n  }\n" + 
 						query[lastBraceIndex:]
 					
 					g.queryTemplates[queryType][endpoint] = modifiedQuery
@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+// maxTopicNameLength is the longest topic name accepted by Kafka brokers
+const maxTopicNameLength = 249
+
+// legalTopicName matches the characters Kafka accepts in topic names
+var legalTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// TopicConfig holds the settings used when provisioning missing topics
+type TopicConfig struct {
+	// Create enables creation of missing topics; when false topics are only checked
+	Create            bool
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration
+	Timeout           time.Duration
+}
+
+// ValidateTopicName checks a topic name against the rules enforced by Kafka brokers
+func ValidateTopicName(topic string) error {
+	switch {
+	case topic == "":
+		return errors.New("topic name is empty")
+	case topic == "." || topic == "..":
+		return fmt.Errorf("topic name %q is reserved", topic)
+	case len(topic) > maxTopicNameLength:
+		return fmt.Errorf("topic name %q is %d characters long, the maximum is %d", topic, len(topic), maxTopicNameLength)
+	case !legalTopicName.MatchString(topic):
+		return fmt.Errorf("topic name %q contains characters other than ASCII alphanumerics, '.', '_' and '-'", topic)
+	}
+	return nil
+}
+
+// validateTopics validates every topic name and rejects names that collide once
+// '.' and '_' are treated as equal, as Kafka does for its metric names
+func validateTopics(topics []string) error {
+	seen := make(map[string]string, len(topics))
+	for _, topic := range topics {
+		if err := ValidateTopicName(topic); err != nil {
+			return err
+		}
+
+		normalized := strings.ReplaceAll(topic, ".", "_")
+		if other, ok := seen[normalized]; ok && other != topic {
+			return fmt.Errorf("topic names %q and %q collide because of '.' and '_'", other, topic)
+		}
+		seen[normalized] = topic
+	}
+	return nil
+}
+
+// Ping checks that at least one of the brokers accepts connections
+func Ping(ctx context.Context, brokers []string) error {
+	if len(brokers) == 0 {
+		return errors.New("no kafka brokers configured")
+	}
+
+	dialer := &kafka.Dialer{Timeout: 5 * time.Second}
+	var err error
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+	}
+	return fmt.Errorf("kafka brokers %s are unreachable: %w", strings.Join(brokers, ","), err)
+}
+
+// EnsureTopics verifies that the brokers are reachable and, when enabled,
+// creates the topics that do not exist yet through the admin API
+func EnsureTopics(ctx context.Context, brokers []string, topics []string, config TopicConfig) error {
+	if len(brokers) == 0 {
+		return errors.New("no kafka brokers configured")
+	}
+	if err := validateTopics(topics); err != nil {
+		return fmt.Errorf("invalid kafka topic: %w", err)
+	}
+
+	// Set defaults for configuration
+	if config.Partitions <= 0 {
+		config.Partitions = 1
+	}
+	if config.ReplicationFactor <= 0 {
+		config.ReplicationFactor = 1
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	client := &kafka.Client{
+		Addr:    kafka.TCP(brokers...),
+		Timeout: config.Timeout,
+	}
+
+	// Fetch metadata for all topics, which also proves the brokers are reachable
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return fmt.Errorf("kafka brokers %s are unreachable: %w", strings.Join(brokers, ","), err)
+	}
+
+	existing := make(map[string]bool, len(metadata.Topics))
+	for _, topic := range metadata.Topics {
+		if topic.Error == nil {
+			existing[topic.Name] = true
+		}
+	}
+
+	var missing []kafka.TopicConfig
+	for _, topic := range topics {
+		if existing[topic] {
+			continue
+		}
+
+		topicConfig := kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     config.Partitions,
+			ReplicationFactor: config.ReplicationFactor,
+		}
+		if config.Retention > 0 {
+			topicConfig.ConfigEntries = append(topicConfig.ConfigEntries, kafka.ConfigEntry{
+				ConfigName:  "retention.ms",
+				ConfigValue: strconv.FormatInt(config.Retention.Milliseconds(), 10),
+			})
+		}
+		missing = append(missing, topicConfig)
+	}
+
+	if len(missing) == 0 {
+		log.Debug().
+			Int("topics", len(topics)).
+			Msg("All Kafka topics already exist")
+		return nil
+	}
+
+	if !config.Create {
+		names := make([]string, len(missing))
+		for i, topic := range missing {
+			names[i] = topic.Topic
+		}
+		log.Warn().
+			Strs("topics", names).
+			Msg("Kafka topics do not exist and topic creation is disabled")
+		return nil
+	}
+
+	response, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: missing})
+	if err != nil {
+		return fmt.Errorf("failed to create kafka topics: %w", err)
+	}
+
+	var errs []error
+	for topic, err := range response.Errors {
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			errs = append(errs, fmt.Errorf("failed to create topic %s: %w", topic, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for _, topic := range missing {
+		log.Info().
+			Str("topic", topic.Topic).
+			Int("partitions", topic.NumPartitions).
+			Int("replicationFactor", topic.ReplicationFactor).
+			Dur("retention", config.Retention).
+			Msg("Created Kafka topic")
+	}
+
+	return nil
+}
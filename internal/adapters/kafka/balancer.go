@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"github.com/segmentio/kafka-go"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+)
+
+// Balancer assigns messages to partitions by key so that messages sharing a
+// key (for example a pool ID) stay ordered. Keys pinned by the routing
+// configuration go to their explicit partition.
+type Balancer struct {
+	router *routing.Router
+	hash   kafka.Murmur2Balancer
+}
+
+// NewBalancer creates a key based balancer; router may be nil
+func NewBalancer(router *routing.Router) *Balancer {
+	return &Balancer{router: router}
+}
+
+// Balance implements kafka.Balancer
+func (b *Balancer) Balance(msg kafka.Message, partitions ...int) int {
+	if b.router != nil {
+		if partition, ok := b.router.Partition(string(msg.Key)); ok {
+			for _, p := range partitions {
+				if p == partition {
+					return partition
+				}
+			}
+		}
+	}
+
+	// Murmur2 matches the default partitioner of the Java client
+	return b.hash.Balance(msg, partitions...)
+}
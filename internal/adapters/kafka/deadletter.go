@@ -0,0 +1,155 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// DeadLetterSink is an adapter that implements the ports.DeadLetterSink
+// interface using a Kafka dead letter topic
+type DeadLetterSink struct {
+	writer      *kafka.Writer
+	brokers     []string
+	topic       string
+	groupID     string
+	idleTimeout time.Duration
+}
+
+// DeadLetterConfig holds the configuration for the Kafka dead letter sink
+type DeadLetterConfig struct {
+	Brokers []string
+	Topic   string
+
+	// GroupID is the consumer group used to track replay progress
+	GroupID string
+
+	// IdleTimeout ends a replay once no message arrived for this long
+	IdleTimeout time.Duration
+}
+
+// NewDeadLetterSink creates a new Kafka dead letter sink
+func NewDeadLetterSink(config DeadLetterConfig) (*DeadLetterSink, error) {
+	if err := ValidateTopicName(config.Topic); err != nil {
+		return nil, fmt.Errorf("invalid dead letter topic: %w", err)
+	}
+
+	// Set defaults for configuration
+	if config.GroupID == "" {
+		config.GroupID = config.Topic + "-replay"
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 5 * time.Second
+	}
+
+	return &DeadLetterSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.Topic,
+			Balancer:     NewBalancer(nil),
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		brokers:     config.Brokers,
+		topic:       config.Topic,
+		groupID:     config.GroupID,
+		idleTimeout: config.IdleTimeout,
+	}, nil
+}
+
+// Topic returns the dead letter topic
+func (s *DeadLetterSink) Topic() string {
+	return s.topic
+}
+
+// Send writes a dead letter to the dead letter topic
+func (s *DeadLetterSink) Send(ctx context.Context, letter *entity.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("error marshaling dead letter: %w", err)
+	}
+
+	msg := kafka.Message{
+		Key:   []byte(letter.Key),
+		Value: data,
+		Time:  time.Now(),
+		Headers: []kafka.Header{
+			{Key: "dlq-original-topic", Value: []byte(letter.Topic)},
+			{Key: "dlq-error", Value: []byte(letter.Error)},
+			{Key: "dlq-attempts", Value: []byte(strconv.Itoa(letter.Attempts))},
+		},
+	}
+	for key, value := range letter.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	if err := s.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to write dead letter to %s: %w", s.topic, err)
+	}
+
+	log.Warn().
+		Str("topic", letter.Topic).
+		Str("dlqTopic", s.topic).
+		Str("key", letter.Key).
+		Int("attempts", letter.Attempts).
+		Msg("Sent dead letter")
+
+	return nil
+}
+
+// Replay consumes the dead letter topic until it is idle and hands every
+// letter to publish, committing progress after each accepted letter. Replay
+// stops at the first rejected letter so it is retried by the next replay.
+func (s *DeadLetterSink) Replay(ctx context.Context, publish func(ctx context.Context, letter *entity.DeadLetter) error) (int, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     s.brokers,
+		Topic:       s.topic,
+		GroupID:     s.groupID,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+
+	replayed := 0
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, s.idleTimeout)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				break // Caught up with the topic
+			}
+			return replayed, fmt.Errorf("error reading dead letter topic: %w", err)
+		}
+
+		var letter entity.DeadLetter
+		if err := json.Unmarshal(msg.Value, &letter); err != nil {
+			log.Error().
+				Int64("offset", msg.Offset).
+				Err(err).
+				Msg("Skipping unreadable dead letter")
+		} else if err := publish(ctx, &letter); err != nil {
+			return replayed, fmt.Errorf("replay stopped at offset %d of partition %d: %w", msg.Offset, msg.Partition, err)
+		} else {
+			replayed++
+		}
+
+		if err := reader.CommitMessages(ctx, msg); err != nil {
+			return replayed, fmt.Errorf("error committing dead letter offset: %w", err)
+		}
+	}
+
+	return replayed, nil
+}
+
+// Close closes the dead letter writer
+func (s *DeadLetterSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// IsUnavailable reports whether a publish error means the brokers could not
+// be reached or could not take the write right now, as opposed to the message
+// being rejected. Batch errors are unavailable only if every failure is.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !IsUnavailable(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		failed := 0
+		for _, e := range writeErrs {
+			if e == nil {
+				continue
+			}
+			if !IsUnavailable(e) {
+				return false
+			}
+			failed++
+		}
+		return failed > 0
+	}
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
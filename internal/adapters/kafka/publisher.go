@@ -2,115 +2,325 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
 
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/cloudevents"
 	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
 )
 
 // Publisher is an adapter for Kafka that implements the ports.EventPublisher interface
 type Publisher struct {
 	writers       map[string]*kafka.Writer
+	writersMu     sync.RWMutex
 	brokers       []string
-	topicPrefix   string
+	router        *routing.Router
 	producer      string
 	flushInterval time.Duration
 	batchSize     int
 	async         bool
+	requiredAcks  kafka.RequiredAcks
+	compression   kafka.Compression
+	topics        TopicConfig
+	encoder       ports.EventEncoder
+	cloudEvents   *cloudevents.Envelope
+
+	// transport carries the writers' requests; kafka-go's default when nil
+	transport kafka.RoundTripper
 }
 
 // PublisherConfig holds the configuration for the Kafka publisher
 type PublisherConfig struct {
-	Brokers       []string
-	TopicPrefix   string
-	Producer      string
+	Brokers  []string
+	Producer string
+
+	// Router supplies message keys and pinned partitions; topics are passed in already routed.
+	// Entities are keyed by ID when nil.
+	Router *routing.Router
+
+	// FlushInterval is the linger time a partial batch waits before being sent
 	FlushInterval time.Duration
 	BatchSize     int
-	Async         bool
+
+	// Async makes writes return before the brokers acknowledge them, so
+	// failures are only logged by kafka-go. PublishBatch reports failures per
+	// message and refuses to publish with an async writer.
+	Async bool
+
+	// RequiredAcks is one of "none", "one" or "all" (default "all")
+	RequiredAcks string
+
+	// Compression is one of "none", "gzip", "snappy", "lz4" or "zstd" (default "none")
+	Compression string
+
+	// Topics controls how missing topics are provisioned by EnsureTopics
+	Topics TopicConfig
+
+	// Encoder serializes entities; entities are published as JSON when nil
+	Encoder ports.EventEncoder
+
+	// CloudEvents wraps entities in CloudEvents when set; raw messages are never wrapped
+	CloudEvents *cloudevents.Envelope
 }
 
 // NewPublisher creates a new Kafka publisher
-func NewPublisher(config PublisherConfig) *Publisher {
+func NewPublisher(config PublisherConfig) (*Publisher, error) {
 	// Set default producer name if not provided
 	if config.Producer == "" {
 		config.Producer = "thegraph-extraction"
 	}
-	
+
 	// Set default batch size if not provided
 	if config.BatchSize <= 0 {
 		config.BatchSize = 100
 	}
-	
+
 	// Set default flush interval if not provided
 	if config.FlushInterval <= 0 {
 		config.FlushInterval = 1 * time.Second
 	}
-	
+
+	// Set default acknowledgement level if not provided
+	if config.RequiredAcks == "" {
+		config.RequiredAcks = "all"
+	}
+	var requiredAcks kafka.RequiredAcks
+	if err := requiredAcks.UnmarshalText([]byte(config.RequiredAcks)); err != nil {
+		return nil, fmt.Errorf("invalid kafka required acks: %w", err)
+	}
+
+	// Set default compression if not provided
+	if config.Compression == "" {
+		config.Compression = "none"
+	}
+	var compression kafka.Compression
+	if err := compression.UnmarshalText([]byte(config.Compression)); err != nil {
+		return nil, fmt.Errorf("invalid kafka compression: %w", err)
+	}
+
 	return &Publisher{
 		writers:       make(map[string]*kafka.Writer),
 		brokers:       config.Brokers,
-		topicPrefix:   config.TopicPrefix,
+		router:        config.Router,
 		producer:      config.Producer,
 		flushInterval: config.FlushInterval,
 		batchSize:     config.BatchSize,
 		async:         config.Async,
+		requiredAcks:  requiredAcks,
+		compression:   compression,
+		topics:        config.Topics,
+		encoder:       config.Encoder,
+		cloudEvents:   config.CloudEvents,
+	}, nil
+}
+
+// EnsureTopics checks that the brokers are reachable and provisions the given
+// topics according to the publisher's topic configuration
+func (p *Publisher) EnsureTopics(ctx context.Context, topics []string) error {
+	return EnsureTopics(ctx, p.brokers, topics, p.topics)
+}
+
+// Ping checks that the brokers are reachable
+func (p *Publisher) Ping(ctx context.Context) error {
+	return Ping(ctx, p.brokers)
+}
+
+// key returns the message key of an entity
+func (p *Publisher) key(e *entity.Entity) string {
+	if p.router == nil {
+		return e.ID
 	}
+	return p.router.Key(e)
 }
 
-// getOrCreateWriter gets an existing writer for a topic or creates a new one
-func (p *Publisher) getOrCreateWriter(topic string) *kafka.Writer {
+// getOrCreateWriter gets an existing writer for a topic or creates a new one.
+// It is safe for concurrent use by worker pool tasks.
+func (p *Publisher) getOrCreateWriter(topic string) (*kafka.Writer, error) {
 	// Check if we already have a writer for this topic
-	if writer, exists := p.writers[topic]; exists {
-		return writer
+	p.writersMu.RLock()
+	writer, exists := p.writers[topic]
+	p.writersMu.RUnlock()
+	if exists {
+		return writer, nil
 	}
-	
-	// Format the full topic name with prefix if needed
-	fullTopic := topic
-	if p.topicPrefix != "" {
-		fullTopic = fmt.Sprintf("%s.%s", p.topicPrefix, topic)
+
+	if err := ValidateTopicName(topic); err != nil {
+		return nil, fmt.Errorf("invalid kafka topic: %w", err)
 	}
-	
+
+	p.writersMu.Lock()
+	defer p.writersMu.Unlock()
+
+	// Another goroutine may have created the writer while we waited for the lock
+	if writer, exists := p.writers[topic]; exists {
+		return writer, nil
+	}
+
 	// Create a new writer
-	writer := &kafka.Writer{
+	writer = &kafka.Writer{
 		Addr:         kafka.TCP(p.brokers...),
-		Topic:        fullTopic,
-		Balancer:     &kafka.LeastBytes{},
+		Topic:        topic,
+		Balancer:     NewBalancer(p.router),
 		BatchSize:    p.batchSize,
 		BatchTimeout: p.flushInterval,
 		Async:        p.async,
+		RequiredAcks: p.requiredAcks,
+		Compression:  p.compression,
+		Transport:    p.transport,
 	}
-	
+
 	// Store the writer for reuse
 	p.writers[topic] = writer
-	
+
 	log.Info().
-		Str("topic", fullTopic).
+		Str("topic", topic).
 		Msg("Created new Kafka writer")
-	
-	return writer
+
+	return writer, nil
 }
 
 // PublishEntity publishes an entity to the message bus
 func (p *Publisher) PublishEntity(ctx context.Context, entity *entity.Entity, topic string) error {
-	// Marshal the entity to JSON
-	data, err := entity.MarshalForEvent()
+	// Serialize the entity
+	msg, err := p.entityMessage(ctx, entity, topic)
+	if err != nil {
+		return err
+	}
+
+	writer, err := p.getOrCreateWriter(topic)
 	if err != nil {
-		return fmt.Errorf("error marshaling entity: %w", err)
+		return err
 	}
-	
-	// Use the entity ID as the key
-	return p.PublishRaw(ctx, entity.ID, data, topic)
+
+	return p.write(ctx, writer, topic, string(msg.Key), msg)
 }
 
-// PublishRaw publishes raw data to the message bus
-func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
-	// Get or create a writer for this topic
-	writer := p.getOrCreateWriter(topic)
-	
-	// Create a Kafka message
+// entityMessage encodes an entity into a Kafka message, wrapped in a CloudEvent when enabled
+func (p *Publisher) entityMessage(ctx context.Context, e *entity.Entity, topic string) (kafka.Message, error) {
+	data, err := p.encode(ctx, e, topic)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("error marshaling entity: %w", err)
+	}
+
+	key := p.key(e)
+	if p.cloudEvents == nil {
+		return p.newMessage(ctx, key, data, p.contentType()), nil
+	}
+
+	if p.cloudEvents.Binary() {
+		msg := p.newMessage(ctx, key, data, p.contentType())
+		for name, value := range p.cloudEvents.Attributes(e) {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: cloudevents.HeaderPrefix + name, Value: []byte(value)})
+		}
+		return msg, nil
+	}
+
+	wrapped, err := p.cloudEvents.Structured(e, data, p.contentType())
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("error wrapping entity: %w", err)
+	}
+	return p.newMessage(ctx, key, wrapped, cloudevents.ContentType), nil
+}
+
+// encode serializes an entity with the configured encoder
+func (p *Publisher) encode(ctx context.Context, e *entity.Entity, topic string) ([]byte, error) {
+	if p.encoder == nil {
+		return e.MarshalForEvent()
+	}
+	return p.encoder.Encode(ctx, e, topic)
+}
+
+// contentType returns the content type of encoded entities
+func (p *Publisher) contentType() string {
+	if p.encoder == nil {
+		return "application/json"
+	}
+	return p.encoder.ContentType()
+}
+
+// ErrAsyncBatch is returned by PublishBatch when the publisher writes asynchronously
+var ErrAsyncBatch = errors.New("kafka batches need a synchronous writer to report failed messages, disable Async")
+
+// PublishBatch publishes a batch of entities with a single write
+func (p *Publisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	// An async writer returns before any message is written, so failed
+	// messages would never be retried, dead-lettered or spooled
+	if p.async {
+		return ErrAsyncBatch
+	}
+
+	// Build the messages, remembering which entities could not be marshaled
+	batchErr := &ports.BatchError{Errors: make([]error, len(entities))}
+	msgs := make([]kafka.Message, 0, len(entities))
+	indexes := make([]int, 0, len(entities))
+	for i, e := range entities {
+		msg, err := p.entityMessage(ctx, e, topic)
+		if err != nil {
+			batchErr.Errors[i] = err
+			continue
+		}
+		msgs = append(msgs, msg)
+		indexes = append(indexes, i)
+	}
+
+	if len(msgs) > 0 {
+		writer, err := p.getOrCreateWriter(topic)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+
+		err = writer.WriteMessages(ctx, msgs...)
+
+		var writeErrs kafka.WriteErrors
+		switch {
+		case err == nil:
+		case errors.As(err, &writeErrs):
+			// kafka-go reports one error per message, aligned with msgs
+			for j, werr := range writeErrs {
+				if werr != nil {
+					batchErr.Errors[indexes[j]] = fmt.Errorf("failed to write message to %s: %w", topic, werr)
+				}
+			}
+		default:
+			for _, i := range indexes {
+				batchErr.Errors[i] = fmt.Errorf("failed to write message to %s: %w", topic, err)
+			}
+		}
+
+		log.Debug().
+			Str("topic", topic).
+			Int("messages", len(msgs)).
+			Dur("duration", time.Since(start)).
+			Msg("Published batch to Kafka")
+	}
+
+	if failed := batchErr.Failed(); len(failed) > 0 {
+		log.Error().
+			Str("topic", topic).
+			Int("failed", len(failed)).
+			Int("total", len(entities)).
+			Err(batchErr.Errors[failed[0]]).
+			Msg("Failed to publish batch to Kafka")
+		return batchErr
+	}
+
+	return nil
+}
+
+// newMessage builds a Kafka message with the standard headers and the trace context of ctx
+func (p *Publisher) newMessage(ctx context.Context, key string, data []byte, contentType string) kafka.Message {
 	msg := kafka.Message{
 		Key:   []byte(key),
 		Value: data,
@@ -120,8 +330,26 @@ func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, top
 			{Key: "timestamp", Value: []byte(fmt.Sprintf("%d", time.Now().UnixMilli()))},
 		},
 	}
-	
-	// Write the message
+	if contentType != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: "content-type", Value: []byte(contentType)})
+	}
+	injectTraceContext(ctx, &msg)
+	return msg
+}
+
+// PublishRaw publishes raw data to the message bus
+func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	// Get or create a writer for this topic
+	writer, err := p.getOrCreateWriter(topic)
+	if err != nil {
+		return err
+	}
+
+	return p.write(ctx, writer, topic, key, p.newMessage(ctx, key, data, ""))
+}
+
+// write writes a single message and logs the outcome
+func (p *Publisher) write(ctx context.Context, writer *kafka.Writer, topic, key string, msg kafka.Message) error {
 	err := writer.WriteMessages(ctx, msg)
 	if err != nil {
 		log.Error().
@@ -131,20 +359,23 @@ func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, top
 			Msg("Failed to publish message to Kafka")
 		return fmt.Errorf("failed to write message to %s: %w", topic, err)
 	}
-	
+
 	log.Debug().
 		Str("topic", topic).
 		Str("key", key).
-		Int("dataSize", len(data)).
+		Int("dataSize", len(msg.Value)).
 		Msg("Published message to Kafka")
-	
+
 	return nil
 }
 
 // Close closes the publisher connection
 func (p *Publisher) Close() error {
+	p.writersMu.Lock()
+	defer p.writersMu.Unlock()
+
 	var errors []error
-	
+
 	// Close all writers
 	for topic, writer := range p.writers {
 		if err := writer.Close(); err != nil {
@@ -155,14 +386,14 @@ func (p *Publisher) Close() error {
 			errors = append(errors, fmt.Errorf("error closing writer for %s: %w", topic, err))
 		}
 	}
-	
+
 	// Clear the writers map
 	p.writers = make(map[string]*kafka.Writer)
-	
+
 	// Return an error if any writers failed to close
 	if len(errors) > 0 {
 		return fmt.Errorf("failed to close %d writers", len(errors))
 	}
-	
+
 	return nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,147 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// fakeBroker answers the metadata and produce requests of kafka-go writers
+// in memory, after a fixed round trip time
+type fakeBroker struct {
+	partitions int
+	rtt        time.Duration
+}
+
+// RoundTrip implements kafka.RoundTripper
+func (b *fakeBroker) RoundTrip(ctx context.Context, addr net.Addr, req protocol.Message) (protocol.Message, error) {
+	select {
+	case <-time.After(b.rtt):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	switch req := req.(type) {
+	case *metadata.Request:
+		res := &metadata.Response{
+			Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "localhost", Port: 9092}},
+		}
+		for _, name := range req.TopicNames {
+			topic := metadata.ResponseTopic{Name: name}
+			for i := 0; i < b.partitions; i++ {
+				topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{PartitionIndex: int32(i)})
+			}
+			res.Topics = append(res.Topics, topic)
+		}
+		return res, nil
+
+	case *produce.Request:
+		res := &produce.Response{}
+		for _, topic := range req.Topics {
+			resTopic := produce.ResponseTopic{Topic: topic.Topic}
+			for _, partition := range topic.Partitions {
+				resTopic.Partitions = append(resTopic.Partitions, produce.ResponsePartition{Partition: partition.Partition})
+			}
+			res.Topics = append(res.Topics, resTopic)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unexpected request %T", req)
+}
+
+// benchmarkPage returns a page of entities
+func benchmarkPage(size int) []*entity.Entity {
+	entities := make([]*entity.Entity, size)
+	for i := range entities {
+		entities[i] = &entity.Entity{
+			ID:         fmt.Sprintf("0x%040x", i),
+			Type:       "swaps",
+			Deployment: "deployment",
+			Timestamp:  time.Unix(1700000000, 0),
+			Data: map[string]interface{}{
+				"id":        fmt.Sprintf("0x%040x", i),
+				"amountUSD": "1234.5678",
+				"timestamp": "1700000000",
+			},
+		}
+	}
+	return entities
+}
+
+// newBenchmarkPublisher creates a publisher writing to a fake broker with
+// the default batch size and linger of the extractor
+func newBenchmarkPublisher(b *testing.B) *Publisher {
+	publisher, err := NewPublisher(PublisherConfig{
+		Brokers:       []string{"localhost:9092"},
+		BatchSize:     100,
+		FlushInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	publisher.transport = &fakeBroker{partitions: 3, rtt: 500 * time.Microsecond}
+	b.Cleanup(func() { publisher.Close() })
+	return publisher
+}
+
+// BenchmarkPublishEntity publishes a page of 100 entities one entity at a
+// time, the path used before pages were published as batches
+func BenchmarkPublishEntity(b *testing.B) {
+	publisher := newBenchmarkPublisher(b)
+	page := benchmarkPage(100)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range page {
+			if err := publisher.PublishEntity(ctx, e, "thegraph_swaps"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.N*len(page))/b.Elapsed().Seconds(), "entities/s")
+}
+
+// BenchmarkPublishBatch publishes a page of 100 entities in one write
+func BenchmarkPublishBatch(b *testing.B) {
+	publisher := newBenchmarkPublisher(b)
+	page := benchmarkPage(100)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := publisher.PublishBatch(ctx, page, "thegraph_swaps"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(page))/b.Elapsed().Seconds(), "entities/s")
+}
+
+func TestPublishBatchRejectsAsyncWriter(t *testing.T) {
+	publisher, err := NewPublisher(PublisherConfig{
+		Brokers: []string{"localhost:9092"},
+		Async:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher.transport = &fakeBroker{partitions: 1}
+	defer publisher.Close()
+
+	err = publisher.PublishBatch(context.Background(), benchmarkPage(2), "thegraph_swaps")
+	if !errors.Is(err, ErrAsyncBatch) {
+		t.Fatalf("expected ErrAsyncBatch, got %v", err)
+	}
+	if IsUnavailable(err) {
+		t.Fatal("expected the rejection not to be spooled as unavailability")
+	}
+}
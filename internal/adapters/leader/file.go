@@ -0,0 +1,157 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileLock is an elector electing the instance holding an exclusive lock on
+// a file. It only coordinates instances sharing the file system of a host;
+// network file systems do not reliably support the lock. The lock is
+// released by the operating system when the process dies, so a follower
+// takes over within RetryInterval.
+type FileLock struct {
+	path          string
+	identity      string
+	retryInterval time.Duration
+
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+// FileLockConfig holds the configuration for a file lock elector
+type FileLockConfig struct {
+	// Path is the lock file, created when missing
+	Path string
+
+	// Identity is written to the lock file by the leader (default Identity())
+	Identity string
+
+	// RetryInterval is how often a follower tries to take the lock (default 5s)
+	RetryInterval time.Duration
+}
+
+// NewFileLock creates a new file lock elector
+func NewFileLock(config FileLockConfig) (*FileLock, error) {
+	// Set defaults for configuration
+	if config.Identity == "" {
+		config.Identity = Identity()
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+
+	if config.Path == "" {
+		return nil, fmt.Errorf("leader lock file is not set")
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating leader lock directory: %w", err)
+	}
+
+	return &FileLock{
+		path:          config.Path,
+		identity:      config.Identity,
+		retryInterval: config.RetryInterval,
+	}, nil
+}
+
+// Campaign tries to take the lock every RetryInterval until it succeeds or
+// ctx is done. The lock is held until Resign, so the returned channel is
+// never closed.
+func (f *FileLock) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	logged := false
+	for {
+		locked, err := f.tryLock()
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			return nil, nil
+		}
+
+		if !logged {
+			log.Info().
+				Str("lockFile", f.path).
+				Msg("Another instance holds the leader lock, waiting as a follower")
+			logged = true
+		}
+		if !sleep(ctx, f.retryInterval) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryLock takes the lock if no other process holds it
+func (f *FileLock) tryLock() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false, errClosed
+	}
+	if f.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, fmt.Errorf("error opening leader lock file: %w", err)
+	}
+	locked, err := lockFile(file)
+	if err != nil || !locked {
+		file.Close()
+		if err != nil {
+			return false, fmt.Errorf("error locking %s: %w", f.path, err)
+		}
+		return false, nil
+	}
+
+	// Name the leader in the file for operators; the lock is what counts
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(f.identity+"\n"), 0)
+	}
+	f.file = file
+	return true, nil
+}
+
+// Resign releases the lock
+func (f *FileLock) Resign(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.unlock()
+}
+
+// Close releases the lock and stops further campaigns
+func (f *FileLock) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	return f.unlock()
+}
+
+// unlock releases the lock if held. It must be called with the lock held.
+func (f *FileLock) unlock() error {
+	if f.file == nil {
+		return nil
+	}
+	file := f.file
+	f.file = nil
+
+	// Closing the file releases the lock even when unlocking fails
+	err := unlockFile(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error releasing leader lock %s: %w", f.path, err)
+	}
+	return nil
+}
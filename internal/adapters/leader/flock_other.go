@@ -0,0 +1,21 @@
+//go:build !unix
+
+package leader
+
+import (
+	"errors"
+	"os"
+)
+
+// errFileLockUnsupported is returned where the file lock elector cannot lock files
+var errFileLockUnsupported = errors.New("file lock leader election is only supported on unix systems")
+
+// lockFile fails on this platform
+func lockFile(file *os.File) (bool, error) {
+	return false, errFileLockUnsupported
+}
+
+// unlockFile fails on this platform
+func unlockFile(file *os.File) error {
+	return errFileLockUnsupported
+}
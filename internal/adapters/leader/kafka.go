@@ -0,0 +1,297 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	kafkaadapter "github.com/panoramablock/thegraph-data-extraction/internal/adapters/kafka"
+)
+
+// leaderUserData marks the member currently leading in its join request
+var leaderUserData = []byte("leader")
+
+// KafkaGroup is an elector electing the member of a Kafka consumer group
+// assigned the single partition of a leader topic. Nothing is consumed from
+// the topic; the group coordinator only tracks the live members. A leader
+// that stops heartbeating is evicted after SessionTimeout and the partition
+// goes to a follower. The current leader keeps the partition when members
+// join or leave.
+type KafkaGroup struct {
+	brokers        []string
+	groupID        string
+	topic          string
+	identity       string
+	replication    int
+	sessionTimeout time.Duration
+
+	mu      sync.Mutex
+	group   *kafka.ConsumerGroup
+	leading bool
+	elected chan struct{}
+	lost    chan struct{}
+	closed  bool
+}
+
+// KafkaGroupConfig holds the configuration for a Kafka group elector
+type KafkaGroupConfig struct {
+	Brokers []string
+
+	// GroupID is the consumer group of the instances
+	GroupID string
+
+	// Topic is the single-partition leader topic, created when missing
+	Topic string
+
+	// ReplicationFactor of the leader topic when created (default 1)
+	ReplicationFactor int
+
+	// Identity is the client ID of this member (default Identity())
+	Identity string
+
+	// SessionTimeout is how long the coordinator waits for the heartbeats
+	// of a member before evicting it (default 10s)
+	SessionTimeout time.Duration
+}
+
+// NewKafkaGroup creates a new Kafka group elector
+func NewKafkaGroup(config KafkaGroupConfig) (*KafkaGroup, error) {
+	// Set defaults for configuration
+	if config.ReplicationFactor <= 0 {
+		config.ReplicationFactor = 1
+	}
+	if config.Identity == "" {
+		config.Identity = Identity()
+	}
+	if config.SessionTimeout <= 0 {
+		config.SessionTimeout = 10 * time.Second
+	}
+
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("no kafka brokers configured for leader election")
+	}
+	if config.GroupID == "" || config.Topic == "" {
+		return nil, errors.New("leader group and topic must be set")
+	}
+
+	return &KafkaGroup{
+		brokers:        config.Brokers,
+		groupID:        config.GroupID,
+		topic:          config.Topic,
+		identity:       config.Identity,
+		replication:    config.ReplicationFactor,
+		sessionTimeout: config.SessionTimeout,
+		elected:        make(chan struct{}),
+		lost:           make(chan struct{}),
+	}, nil
+}
+
+// Campaign joins the group and blocks until this member is assigned the
+// leader partition. The returned channel is closed when a rebalance assigns
+// the partition to another member or this member drops out of the group.
+func (k *KafkaGroup) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	if err := k.join(ctx); err != nil {
+		return nil, err
+	}
+
+	logged := false
+	for {
+		k.mu.Lock()
+		if k.leading {
+			lost := k.lost
+			k.mu.Unlock()
+			return lost, nil
+		}
+		elected := k.elected
+		k.mu.Unlock()
+
+		if !logged {
+			log.Info().
+				Str("group", k.groupID).
+				Msg("Another member leads the group, waiting as a follower")
+			logged = true
+		}
+		select {
+		case <-elected:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// join creates the leader topic and joins the group unless already a member
+func (k *KafkaGroup) join(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return errClosed
+	}
+	if k.group != nil {
+		return nil
+	}
+
+	err := kafkaadapter.EnsureTopics(ctx, k.brokers, []string{k.topic}, kafkaadapter.TopicConfig{
+		Create:            true,
+		Partitions:        1,
+		ReplicationFactor: k.replication,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating leader topic: %w", err)
+	}
+
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:             k.groupID,
+		Brokers:        k.brokers,
+		Dialer:         &kafka.Dialer{ClientID: k.identity, Timeout: 10 * time.Second},
+		Topics:         []string{k.topic},
+		GroupBalancers: []kafka.GroupBalancer{stickyLeaderBalancer{elector: k}},
+		SessionTimeout: k.sessionTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("error joining leader group %s: %w", k.groupID, err)
+	}
+	k.group = group
+
+	go k.watch(group)
+	return nil
+}
+
+// watch follows the generations of the group until it is closed
+func (k *KafkaGroup) watch(group *kafka.ConsumerGroup) {
+	for {
+		generation, err := group.Next(context.Background())
+		if errors.Is(err, kafka.ErrGroupClosed) {
+			k.setLeading(false)
+			return
+		}
+		if err != nil {
+			// The group rejoins by itself; lead again once it has
+			log.Warn().Err(err).Str("group", k.groupID).Msg("Leader group membership failed")
+			k.setLeading(false)
+			continue
+		}
+
+		leading := false
+		for _, assignment := range generation.Assignments[k.topic] {
+			if assignment.ID == 0 {
+				leading = true
+			}
+		}
+		k.setLeading(leading)
+	}
+}
+
+// setLeading records whether this member holds the leader partition
+func (k *KafkaGroup) setLeading(leading bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if leading == k.leading {
+		return
+	}
+	k.leading = leading
+	if leading {
+		close(k.elected)
+		k.lost = make(chan struct{})
+	} else {
+		close(k.lost)
+		k.elected = make(chan struct{})
+	}
+}
+
+// isLeading reports whether this member holds the leader partition
+func (k *KafkaGroup) isLeading() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.leading
+}
+
+// Resign leaves the group, handing the leader partition to a follower
+func (k *KafkaGroup) Resign(ctx context.Context) error {
+	k.mu.Lock()
+	group := k.group
+	k.group = nil
+	k.mu.Unlock()
+
+	return k.leave(group)
+}
+
+// Close leaves the group and stops further campaigns
+func (k *KafkaGroup) Close() error {
+	k.mu.Lock()
+	k.closed = true
+	group := k.group
+	k.group = nil
+	k.mu.Unlock()
+
+	return k.leave(group)
+}
+
+// leave closes a group membership. It must be called without the lock
+// held, since the group asks the balancer for the role of this member
+// until it has stopped.
+func (k *KafkaGroup) leave(group *kafka.ConsumerGroup) error {
+	if group == nil {
+		return nil
+	}
+
+	// The watcher sees the group closing and records the lost leadership
+	if err := group.Close(); err != nil {
+		return fmt.Errorf("error leaving leader group %s: %w", k.groupID, err)
+	}
+	return nil
+}
+
+// stickyLeaderBalancer assigns every partition of the leader topic to the
+// current leader, or to the first member by ID when there is none, so that
+// the leadership only moves when the leader leaves the group
+type stickyLeaderBalancer struct {
+	elector *KafkaGroup
+}
+
+// ProtocolName names the balancer to the group coordinator
+func (b stickyLeaderBalancer) ProtocolName() string {
+	return "sticky-leader"
+}
+
+// UserData tells the member elected to balance the group which member leads
+func (b stickyLeaderBalancer) UserData() ([]byte, error) {
+	if b.elector.isLeading() {
+		return leaderUserData, nil
+	}
+	return nil, nil
+}
+
+// AssignGroups assigns the partitions to the leader
+func (b stickyLeaderBalancer) AssignGroups(members []kafka.GroupMember, partitions []kafka.Partition) kafka.GroupMemberAssignments {
+	assignments := make(kafka.GroupMemberAssignments, len(members))
+	if len(members) == 0 {
+		return assignments
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	leader := members[0].ID
+	for _, member := range members {
+		if string(member.UserData) == string(leaderUserData) {
+			leader = member.ID
+			break
+		}
+	}
+
+	for _, member := range members {
+		assignments[member.ID] = map[string][]int{}
+	}
+	for _, partition := range partitions {
+		assignments[leader][partition.Topic] = append(assignments[leader][partition.Topic], partition.ID)
+	}
+	return assignments
+}
@@ -0,0 +1,177 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Election methods
+const (
+	// MethodNone makes every instance the leader, for single-instance deployments
+	MethodNone = "none"
+	// MethodFile elects the instance holding a lock on a file shared by the instances of a host
+	MethodFile = "file"
+	// MethodKafka elects the member of a Kafka consumer group assigned the partition of a leader topic
+	MethodKafka = "kafka"
+	// MethodPostgres elects the instance holding a Postgres advisory lock
+	MethodPostgres = "postgres"
+)
+
+// Elector campaigns for the leadership of a group of instances
+type Elector interface {
+	// Campaign blocks until this instance is the leader or ctx is done.
+	// The returned channel is closed when the leadership is lost.
+	Campaign(ctx context.Context) (<-chan struct{}, error)
+
+	// Resign gives the leadership up so that another instance can take over
+	Resign(ctx context.Context) error
+
+	// Close releases the resources of the elector, resigning if needed
+	Close() error
+}
+
+// Identity names this instance in locks, group members and logs
+func Identity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Standalone is an elector that is always the leader
+type Standalone struct{}
+
+// Campaign returns right away with a channel that is never closed
+func (Standalone) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
+
+// Resign does nothing
+func (Standalone) Resign(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing
+func (Standalone) Close() error {
+	return nil
+}
+
+// Leadership runs a function for as long as this instance is the leader
+type Leadership struct {
+	elector       Elector
+	retryInterval time.Duration
+	resignTimeout time.Duration
+
+	leading atomic.Bool
+	closing atomic.Bool
+}
+
+// LeadershipConfig holds the configuration for a leadership
+type LeadershipConfig struct {
+	// RetryInterval is the wait after a failed campaign (default 5s)
+	RetryInterval time.Duration
+
+	// ResignTimeout bounds the resignation once the leader is done (default 10s)
+	ResignTimeout time.Duration
+}
+
+// NewLeadership creates a new leadership campaigning with elector
+func NewLeadership(elector Elector, config LeadershipConfig) *Leadership {
+	// Set defaults for configuration
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	if config.ResignTimeout <= 0 {
+		config.ResignTimeout = 10 * time.Second
+	}
+
+	return &Leadership{
+		elector:       elector,
+		retryInterval: config.RetryInterval,
+		resignTimeout: config.ResignTimeout,
+	}
+}
+
+// Leading reports whether this instance is the leader
+func (l *Leadership) Leading() bool {
+	return l.leading.Load()
+}
+
+// Run campaigns until ctx is done, calling lead every time this instance
+// becomes the leader. The context passed to lead is cancelled when the
+// leadership is lost or ctx is done; the leadership is held until lead
+// returns, then resigned so that a follower can take over.
+func (l *Leadership) Run(ctx context.Context, lead func(ctx context.Context)) {
+	for {
+		lost, err := l.elector.Campaign(ctx)
+		if err != nil {
+			if ctx.Err() != nil || l.closing.Load() {
+				return
+			}
+			log.Warn().Err(err).Dur("retryIn", l.retryInterval).Msg("Leader election failed")
+			if !sleep(ctx, l.retryInterval) {
+				return
+			}
+			continue
+		}
+
+		l.leading.Store(true)
+		log.Info().Msg("Elected leader")
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-lost:
+				if !l.closing.Load() {
+					log.Warn().Msg("Leadership lost, stopping")
+				}
+				cancel()
+			case <-leaderCtx.Done():
+			}
+		}()
+		lead(leaderCtx)
+		cancel()
+		l.leading.Store(false)
+
+		resignCtx, cancelResign := context.WithTimeout(context.WithoutCancel(ctx), l.resignTimeout)
+		if err := l.elector.Resign(resignCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to resign leadership")
+		}
+		cancelResign()
+		log.Info().Msg("Stepped down as leader")
+
+		if !sleep(ctx, l.retryInterval) {
+			return
+		}
+	}
+}
+
+// Close gives the leadership up for good and releases the elector. A
+// running leader sees its context cancelled.
+func (l *Leadership) Close() error {
+	l.closing.Store(true)
+	return l.elector.Close()
+}
+
+// sleep waits for d and reports false when ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// errClosed is returned when campaigning with a closed elector
+var errClosed = errors.New("leader elector is closed")
@@ -0,0 +1,205 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// PostgresLock is an elector electing the instance holding a session-level
+// Postgres advisory lock. The lock lives on one dedicated connection that is
+// checked every CheckInterval; the leadership is lost as soon as a check
+// fails, since Postgres releases the lock when the session ends.
+type PostgresLock struct {
+	db            *sql.DB
+	key           int64
+	retryInterval time.Duration
+	checkInterval time.Duration
+
+	mu      sync.Mutex
+	conn    *sql.Conn
+	stopped chan struct{}
+	checks  sync.WaitGroup
+}
+
+// PostgresLockConfig holds the configuration for a Postgres lock elector
+type PostgresLockConfig struct {
+	// DSN is the connection string of the database
+	DSN string
+
+	// Name identifies the lock when Key is zero; its hash is used as the key
+	Name string
+
+	// Key is the advisory lock key
+	Key int64
+
+	// RetryInterval is how often a follower tries to take the lock (default 5s)
+	RetryInterval time.Duration
+
+	// CheckInterval is how often the leader checks its connection (default 5s)
+	CheckInterval time.Duration
+}
+
+// NewPostgresLock creates a new Postgres lock elector
+func NewPostgresLock(config PostgresLockConfig) (*PostgresLock, error) {
+	// Set defaults for configuration
+	if config.Key == 0 {
+		config.Key = lockKey(config.Name)
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = 5 * time.Second
+	}
+
+	if config.DSN == "" {
+		return nil, errors.New("no postgres DSN configured for leader election")
+	}
+	db, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("error opening leader election database: %w", err)
+	}
+
+	// Connections go back to the pool after failed attempts; closing them
+	// makes sure no session outlives its attempt
+	db.SetMaxIdleConns(0)
+
+	return &PostgresLock{
+		db:            db,
+		key:           config.Key,
+		retryInterval: config.RetryInterval,
+		checkInterval: config.CheckInterval,
+	}, nil
+}
+
+// lockKey hashes a lock name into an advisory lock key
+func lockKey(name string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return int64(hash.Sum64())
+}
+
+// Campaign tries to take the lock every RetryInterval until it succeeds or
+// ctx is done. The returned channel is closed when the connection holding
+// the lock fails.
+func (p *PostgresLock) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	logged := false
+	for {
+		conn, err := p.tryLock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if conn != nil {
+			return p.hold(conn), nil
+		}
+
+		if !logged {
+			log.Info().
+				Int64("lockKey", p.key).
+				Msg("Another instance holds the leader lock, waiting as a follower")
+			logged = true
+		}
+		if !sleep(ctx, p.retryInterval) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryLock takes the lock on a new connection. It returns no connection when
+// another session holds the lock.
+func (p *PostgresLock) tryLock(ctx context.Context) (*sql.Conn, error) {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to leader election database: %w", err)
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", p.key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error taking leader lock: %w", err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, nil
+	}
+	return conn, nil
+}
+
+// hold keeps the connection holding the lock and checks it until Resign.
+// The returned channel is closed when a check fails.
+func (p *PostgresLock) hold(conn *sql.Conn) <-chan struct{} {
+	lost := make(chan struct{})
+	stopped := make(chan struct{})
+
+	p.mu.Lock()
+	p.conn = conn
+	p.stopped = stopped
+	p.mu.Unlock()
+
+	p.checks.Add(1)
+	go func() {
+		defer p.checks.Done()
+
+		ticker := time.NewTicker(p.checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), p.checkInterval)
+			err := conn.PingContext(ctx)
+			cancel()
+			if err != nil {
+				log.Error().Err(err).Int64("lockKey", p.key).Msg("Lost the connection holding the leader lock")
+				close(lost)
+				return
+			}
+		}
+	}()
+
+	return lost
+}
+
+// Resign releases the lock and closes its connection
+func (p *PostgresLock) Resign(ctx context.Context) error {
+	p.mu.Lock()
+	conn, stopped := p.conn, p.stopped
+	p.conn, p.stopped = nil, nil
+	p.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	close(stopped)
+	p.checks.Wait()
+
+	// Ending the session releases the lock even when unlocking fails
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", p.key)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error releasing leader lock: %w", err)
+	}
+	return nil
+}
+
+// Close releases the lock and closes the database
+func (p *PostgresLock) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return errors.Join(p.Resign(ctx), p.db.Close())
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// GraphQL error classes
+const (
+	ClassTimeout     = "timeout"
+	ClassCanceled    = "canceled"
+	ClassNetwork     = "network"
+	ClassRateLimited = "rate_limited"
+	ClassGraphQL     = "graphql"
+	ClassDecode      = "decode"
+	ClassOther       = "other"
+)
+
+// ErrorClass classifies a GraphQL query error for the query errors counter
+func ErrorClass(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ClassTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return ClassCanceled
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ClassTimeout
+		}
+		return ClassNetwork
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests") || strings.Contains(msg, "status code: 429"):
+		return ClassRateLimited
+	case strings.Contains(msg, "decoding response") || strings.Contains(msg, "reading body"):
+		// The gateway answered with something other than a GraphQL response
+		return ClassDecode
+	case strings.HasPrefix(msg, "graphql: "):
+		return ClassGraphQL
+	}
+	return ClassOther
+}
@@ -0,0 +1,395 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/concurrency"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/leader"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/ratelimit"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/spool"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/worker"
+)
+
+// Entity outcomes recorded by the entities counter
+const (
+	OutcomePublished    = "published"
+	OutcomeDeadLettered = "dead_lettered"
+	OutcomeLost         = "lost"
+)
+
+// Registry is an adapter that implements the ports.Metrics interface with
+// Prometheus collectors
+type Registry struct {
+	registry  *prometheus.Registry
+	namespace string
+
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+	queryRetries    *prometheus.CounterVec
+	extracted       *prometheus.CounterVec
+	entities        *prometheus.CounterVec
+	runDuration     prometheus.Histogram
+	runs            *prometheus.CounterVec
+	lastSuccess     prometheus.Gauge
+	lastRun         prometheus.Gauge
+	skippedTicks    *prometheus.CounterVec
+	checkpoints     map[pair]time.Time
+	checkpointsMu   sync.Mutex
+	cursorLagDesc   *prometheus.Desc
+	checkpointsDesc *prometheus.Desc
+}
+
+// pair identifies an endpoint and query type
+type pair struct {
+	endpoint  string
+	queryType string
+}
+
+// Config holds the configuration for the metrics registry
+type Config struct {
+	// Namespace prefixes every metric name (default "thegraph")
+	Namespace string
+
+	// QueryBuckets are the histogram buckets of query latencies in seconds
+	QueryBuckets []float64
+}
+
+// NewRegistry creates a new metrics registry with the Go runtime and process collectors
+func NewRegistry(config Config) *Registry {
+	// Set defaults for configuration
+	if config.Namespace == "" {
+		config.Namespace = "thegraph"
+	}
+	if len(config.QueryBuckets) == 0 {
+		config.QueryBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	}
+
+	r := &Registry{
+		registry:  prometheus.NewRegistry(),
+		namespace: config.Namespace,
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: config.Namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Latency of GraphQL query attempts.",
+			Buckets:   config.QueryBuckets,
+		}, []string{"endpoint", "query_type"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "query_errors_total",
+			Help:      "Failed GraphQL query attempts by error class.",
+		}, []string{"endpoint", "query_type", "class"}),
+		queryRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "query_retries_total",
+			Help:      "Retried GraphQL queries.",
+		}, []string{"endpoint", "query_type"}),
+		extracted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "entities_extracted_total",
+			Help:      "Entities extracted from GraphQL responses.",
+		}, []string{"endpoint", "query_type"}),
+		entities: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "entities_total",
+			Help:      "Extracted entities by publish outcome: published, dead_lettered or lost.",
+		}, []string{"endpoint", "query_type", "outcome"}),
+		runDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: config.Namespace,
+			Name:      "run_duration_seconds",
+			Help:      "Duration of extraction runs.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "runs_total",
+			Help:      "Extraction runs by status.",
+		}, []string{"status"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: config.Namespace,
+			Name:      "last_successful_run_timestamp_seconds",
+			Help:      "Unix time the last successful extraction run completed.",
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: config.Namespace,
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Unix time the last extraction run completed.",
+		}),
+		skippedTicks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "scheduler_skipped_ticks_total",
+			Help:      "Scheduled runs dropped because of the overlap policy, by reason.",
+		}, []string{"job", "reason"}),
+		checkpoints: make(map[pair]time.Time),
+		cursorLagDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, "", "cursor_lag_seconds"),
+			"Time since the cursor of an endpoint and query type was last advanced.",
+			[]string{"endpoint", "query_type"}, nil,
+		),
+		checkpointsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, "", "cursor_checkpoint_timestamp_seconds"),
+			"Unix time the cursor of an endpoint and query type was last saved.",
+			[]string{"endpoint", "query_type"}, nil,
+		),
+	}
+
+	r.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		r.queryDuration,
+		r.queryErrors,
+		r.queryRetries,
+		r.extracted,
+		r.entities,
+		r.runDuration,
+		r.runs,
+		r.lastSuccess,
+		r.lastRun,
+		r.skippedTicks,
+		(*cursorCollector)(r),
+	)
+
+	return r
+}
+
+// Prometheus returns the underlying Prometheus registry
+func (r *Registry) Prometheus() *prometheus.Registry {
+	return r.registry
+}
+
+// Handler returns the HTTP handler exposing the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{Registry: r.registry})
+}
+
+// ObserveQuery records a GraphQL query attempt and its outcome
+func (r *Registry) ObserveQuery(endpoint, queryType string, duration time.Duration, err error) {
+	r.queryDuration.WithLabelValues(endpoint, queryType).Observe(duration.Seconds())
+	if err != nil {
+		r.queryErrors.WithLabelValues(endpoint, queryType, ErrorClass(err)).Inc()
+	}
+}
+
+// RecordRetry records a retried GraphQL query
+func (r *Registry) RecordRetry(endpoint, queryType string) {
+	r.queryRetries.WithLabelValues(endpoint, queryType).Inc()
+}
+
+// RecordEntities records entities extracted from a page and their publish outcome
+func (r *Registry) RecordEntities(endpoint, queryType string, extracted, published, deadLettered, lost int) {
+	r.extracted.WithLabelValues(endpoint, queryType).Add(float64(extracted))
+	r.entities.WithLabelValues(endpoint, queryType, OutcomePublished).Add(float64(published))
+	r.entities.WithLabelValues(endpoint, queryType, OutcomeDeadLettered).Add(float64(deadLettered))
+	r.entities.WithLabelValues(endpoint, queryType, OutcomeLost).Add(float64(lost))
+}
+
+// RecordCheckpoint records that the cursor of an endpoint and query type was saved
+func (r *Registry) RecordCheckpoint(endpoint, queryType string) {
+	r.checkpointsMu.Lock()
+	r.checkpoints[pair{endpoint: endpoint, queryType: queryType}] = time.Now()
+	r.checkpointsMu.Unlock()
+}
+
+// RecordRun records the outcome of an extraction run
+func (r *Registry) RecordRun(duration time.Duration, err error) {
+	now := float64(time.Now().Unix())
+	r.runDuration.Observe(duration.Seconds())
+	r.lastRun.Set(now)
+	if err != nil {
+		r.runs.WithLabelValues("failed").Inc()
+		return
+	}
+	r.runs.WithLabelValues("succeeded").Inc()
+	r.lastSuccess.Set(now)
+}
+
+// RecordSkippedTick records a scheduled run dropped because of the overlap policy
+func (r *Registry) RecordSkippedTick(job, reason string) {
+	r.skippedTicks.WithLabelValues(job, reason).Inc()
+}
+
+// ObserveLimiter exports the state of a rate limiter, read at scrape time
+func (r *Registry) ObserveLimiter(limiter *ratelimit.AdaptiveLimiter) {
+	r.gaugeFunc("limiter_rate", "Current request rate allowed by the adaptive limiter, per second.", func() float64 {
+		return limiter.Stats().Rate
+	})
+	r.gaugeFunc("limiter_max_rate", "Maximum request rate of the adaptive limiter, per second.", func() float64 {
+		return limiter.Stats().MaxRate
+	})
+	r.gaugeFunc("limiter_success_rate", "Moving average of successful requests seen by the adaptive limiter.", func() float64 {
+		return limiter.Stats().SuccessRate
+	})
+	r.gaugeFunc("limiter_latency_seconds", "Average latency of recent requests seen by the adaptive limiter.", func() float64 {
+		return limiter.Stats().AverageLatency.Seconds()
+	})
+}
+
+// ObserveController exports the state of a concurrency controller, read at scrape time
+func (r *Registry) ObserveController(controller *concurrency.Controller) {
+	r.gaugeFunc("concurrency_limit", "Requests allowed in flight by the concurrency controller.", func() float64 {
+		return controller.Stats().Limit
+	})
+	r.gaugeFunc("concurrency_in_flight", "Requests in flight seen by the concurrency controller.", func() float64 {
+		return float64(controller.Stats().InFlight)
+	})
+	r.gaugeFunc("concurrency_rtt_seconds", "Average request latency of the last concurrency control window.", func() float64 {
+		return controller.Stats().RTT.Seconds()
+	})
+	r.gaugeFunc("concurrency_baseline_rtt_seconds", "Request latency of the unloaded API estimated by the concurrency controller.", func() float64 {
+		return controller.Stats().BaselineRTT.Seconds()
+	})
+}
+
+// ObservePool exports the state of a worker pool, read at scrape time
+func (r *Registry) ObservePool(pool *worker.DynamicPool) {
+	r.gaugeFunc("pool_workers", "Workers in the pool.", func() float64 {
+		return float64(pool.Stats().Workers)
+	})
+	r.gaugeFunc("pool_busy_workers", "Workers executing a task.", func() float64 {
+		return float64(pool.Stats().BusyWorkers)
+	})
+	r.gaugeFunc("pool_stopping_workers", "Workers removed from the pool while busy that stop after their current task.", func() float64 {
+		return float64(pool.Stats().StoppingWorkers)
+	})
+	r.gaugeFunc("pool_queue_depth", "Tasks waiting in the pool queue.", func() float64 {
+		return float64(pool.Stats().QueueDepth)
+	})
+	r.gaugeFunc("pool_queue_capacity", "Capacity of the pool queue.", func() float64 {
+		return float64(pool.Stats().QueueCapacity)
+	})
+	r.gaugeFunc("pool_error_rate", "Share of pool tasks that returned an error.", func() float64 {
+		return pool.Stats().ErrorRate
+	})
+	r.gaugeFunc("pool_task_latency_seconds", "Average latency of recent pool tasks.", func() float64 {
+		return pool.Stats().AverageLatency.Seconds()
+	})
+	r.gaugeFunc("pool_queue_depth_average", "Smoothed depth of the pool queue the pool scales on.", func() float64 {
+		return pool.Stats().QueueDepthAverage
+	})
+	r.gaugeFunc("pool_blocked_submitters", "Submitters waiting for room in the pool queue.", func() float64 {
+		return float64(pool.Stats().BlockedSubmitters)
+	})
+	r.counterFunc("pool_rejected_tasks_total", "Tasks refused because the pool queue was full.", func() float64 {
+		return float64(pool.Stats().RejectedTasks)
+	})
+	r.counterFunc("pool_caller_run_tasks_total", "Tasks run by their submitter because the pool queue was full.", func() float64 {
+		return float64(pool.Stats().CallerRunTasks)
+	})
+	r.counterFunc("pool_cancelled_submits_total", "Submissions cancelled while waiting for room in the pool queue.", func() float64 {
+		return float64(pool.Stats().CancelledSubmits)
+	})
+	r.counterFunc("pool_panicked_tasks_total", "Pool tasks that panicked, recovered into task errors.", func() float64 {
+		return float64(pool.Stats().PanickedTasks)
+	})
+	r.counterFunc("pool_timed_out_tasks_total", "Pool tasks that failed after reaching the task timeout.", func() float64 {
+		return float64(pool.Stats().TimedOutTasks)
+	})
+	r.registry.MustRegister(&poolClassCollector{
+		pool: pool,
+		queuedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(r.namespace, "", "pool_class_queue_depth"),
+			"Tasks of a class waiting in the pool queue.",
+			[]string{"class"}, nil,
+		),
+		inFlightDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(r.namespace, "", "pool_class_in_flight"),
+			"Tasks of a class running in the pool.",
+			[]string{"class"}, nil,
+		),
+	})
+}
+
+// ObserveSpool exports the state of a spooling publisher, read at scrape time
+func (r *Registry) ObserveSpool(publisher *spool.Publisher) {
+	r.gaugeFunc("spool_bytes", "Bytes of messages waiting in the spool.", func() float64 {
+		return float64(publisher.Used())
+	})
+	r.gaugeFunc("spool_active", "Whether messages are being spooled because the broker is unavailable.", func() float64 {
+		if publisher.Spooling() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// ObserveLeadership exports whether this instance is the leader, read at scrape time
+func (r *Registry) ObserveLeadership(leadership *leader.Leadership) {
+	r.gaugeFunc("leader", "Whether this instance is the leader scheduling extraction runs.", func() float64 {
+		if leadership.Leading() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// ObserveOwnership exports the number of tasks this instance owns, read at scrape time
+func (r *Registry) ObserveOwnership(owned func() int) {
+	r.gaugeFunc("shard_owned_tasks", "Endpoint and query type pairs this instance extracts.", func() float64 {
+		return float64(owned())
+	})
+}
+
+// gaugeFunc registers a gauge whose value is read at scrape time
+func (r *Registry) gaugeFunc(name, help string, value func() float64) {
+	r.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: r.namespace,
+		Name:      name,
+		Help:      help,
+	}, value))
+}
+
+// counterFunc registers a counter whose value is read at scrape time
+func (r *Registry) counterFunc(name, help string, value func() float64) {
+	r.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: r.namespace,
+		Name:      name,
+		Help:      help,
+	}, value))
+}
+
+// cursorCollector exports the cursor lag of every endpoint and query type
+type cursorCollector Registry
+
+// Describe implements prometheus.Collector
+func (c *cursorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cursorLagDesc
+	ch <- c.checkpointsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *cursorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.checkpointsMu.Lock()
+	defer c.checkpointsMu.Unlock()
+
+	now := time.Now()
+	for p, at := range c.checkpoints {
+		ch <- prometheus.MustNewConstMetric(c.cursorLagDesc, prometheus.GaugeValue, now.Sub(at).Seconds(), p.endpoint, p.queryType)
+		ch <- prometheus.MustNewConstMetric(c.checkpointsDesc, prometheus.GaugeValue, float64(at.Unix()), p.endpoint, p.queryType)
+	}
+}
+
+// poolClassCollector exports the queued and running tasks of every pool class
+type poolClassCollector struct {
+	pool         *worker.DynamicPool
+	queuedDesc   *prometheus.Desc
+	inFlightDesc *prometheus.Desc
+}
+
+// Describe implements prometheus.Collector
+func (c *poolClassCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queuedDesc
+	ch <- c.inFlightDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *poolClassCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, class := range c.pool.Stats().Classes {
+		ch <- prometheus.MustNewConstMetric(c.queuedDesc, prometheus.GaugeValue, float64(class.Queued), class.Name)
+		ch <- prometheus.MustNewConstMetric(c.inFlightDesc, prometheus.GaugeValue, float64(class.InFlight), class.Name)
+	}
+}
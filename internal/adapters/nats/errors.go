@@ -0,0 +1,54 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ValidateSubject checks that a routed topic is a valid subject to publish to
+func ValidateSubject(subject string) error {
+	switch {
+	case subject == "":
+		return errors.New("subject name is empty")
+	case strings.ContainsAny(subject, " \t\r\n*>"):
+		return fmt.Errorf("subject %q contains whitespace or wildcards", subject)
+	case strings.HasPrefix(subject, ".") || strings.HasSuffix(subject, ".") || strings.Contains(subject, ".."):
+		return fmt.Errorf("subject %q contains an empty token", subject)
+	}
+	return nil
+}
+
+// IsUnavailable reports whether a publish error means NATS or the stream could
+// not take the message right now, as opposed to the message being rejected.
+// Batch errors are unavailable only if every failure is.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !IsUnavailable(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	return errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, nats.ErrConnectionDraining) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrNoServers) ||
+		errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, jetstream.ErrNoStreamResponse) ||
+		errors.Is(err, jetstream.ErrTooManyStalledMsgs) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled)
+}
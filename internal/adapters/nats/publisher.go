@@ -0,0 +1,342 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+)
+
+// Publisher is an adapter for NATS JetStream that implements the ports.EventPublisher interface.
+// Routed topics are used as subjects, and every publish waits for the stream's acknowledgement.
+type Publisher struct {
+	conn       *nats.Conn
+	js         jetstream.JetStream
+	router     *routing.Router
+	producer   string
+	encoder    ports.EventEncoder
+	stream     string
+	create     bool
+	replicas   int
+	maxAge     time.Duration
+	ackTimeout time.Duration
+}
+
+// PublisherConfig holds the configuration for the NATS JetStream publisher
+type PublisherConfig struct {
+	URL      string
+	Producer string
+
+	// Router supplies message keys; subjects are passed in already routed.
+	// Entities are keyed by ID when nil.
+	Router *routing.Router
+
+	// Encoder serializes entities; entities are published as JSON when nil
+	Encoder ports.EventEncoder
+
+	// Stream is the JetStream stream capturing the published subjects
+	Stream string
+
+	// CreateStream creates the stream, or adds missing subjects to it, in EnsureTopics
+	CreateStream bool
+	Replicas     int
+	MaxAge       time.Duration
+
+	// AckTimeout bounds how long a publish waits for the stream's acknowledgement
+	AckTimeout time.Duration
+}
+
+// NewPublisher connects to NATS and creates a new JetStream publisher
+func NewPublisher(config PublisherConfig) (*Publisher, error) {
+	// Set defaults for configuration
+	if config.URL == "" {
+		config.URL = nats.DefaultURL
+	}
+	if config.Producer == "" {
+		config.Producer = "thegraph-extraction"
+	}
+	if config.Stream == "" {
+		config.Stream = "THEGRAPH"
+	}
+	if config.Replicas <= 0 {
+		config.Replicas = 1
+	}
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = 5 * time.Second
+	}
+
+	conn, err := nats.Connect(config.URL,
+		nats.Name(config.Producer),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warn().Err(err).Msg("Disconnected from NATS")
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Info().Str("url", conn.ConnectedUrl()).Msg("Reconnected to NATS")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats at %s: %w", config.URL, err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+
+	return &Publisher{
+		conn:       conn,
+		js:         js,
+		router:     config.Router,
+		producer:   config.Producer,
+		encoder:    config.Encoder,
+		stream:     config.Stream,
+		create:     config.CreateStream,
+		replicas:   config.Replicas,
+		maxAge:     config.MaxAge,
+		ackTimeout: config.AckTimeout,
+	}, nil
+}
+
+// Ping checks that the server answers a round trip
+func (p *Publisher) Ping(ctx context.Context) error {
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("nats is unreachable (%s): %w", p.conn.Status(), err)
+	}
+	return nil
+}
+
+// EnsureTopics makes sure the stream captures every subject. Missing subjects
+// are added to the stream when stream creation is enabled.
+func (p *Publisher) EnsureTopics(ctx context.Context, topics []string) error {
+	for _, topic := range topics {
+		if err := ValidateSubject(topic); err != nil {
+			return err
+		}
+	}
+
+	var existing []string
+	stream, err := p.js.Stream(ctx, p.stream)
+	switch {
+	case err == nil:
+		existing = stream.CachedInfo().Config.Subjects
+	case errors.Is(err, jetstream.ErrStreamNotFound):
+	default:
+		return fmt.Errorf("error looking up stream %s: %w", p.stream, err)
+	}
+
+	subjects := make(map[string]bool, len(existing)+len(topics))
+	for _, subject := range existing {
+		subjects[subject] = true
+	}
+	var missing []string
+	for _, topic := range topics {
+		if !subjects[topic] {
+			subjects[topic] = true
+			missing = append(missing, topic)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if !p.create {
+		log.Warn().
+			Str("stream", p.stream).
+			Strs("subjects", missing).
+			Msg("Subjects are not captured by the stream and stream creation is disabled")
+		return nil
+	}
+
+	all := make([]string, 0, len(subjects))
+	for subject := range subjects {
+		all = append(all, subject)
+	}
+	sort.Strings(all)
+
+	_, err = p.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      p.stream,
+		Subjects:  all,
+		Storage:   jetstream.FileStorage,
+		Replicas:  p.replicas,
+		MaxAge:    p.maxAge,
+		Retention: jetstream.LimitsPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating stream %s: %w", p.stream, err)
+	}
+
+	log.Info().
+		Str("stream", p.stream).
+		Strs("subjects", missing).
+		Msg("Added subjects to stream")
+
+	return nil
+}
+
+// key returns the message key of an entity
+func (p *Publisher) key(e *entity.Entity) string {
+	if p.router == nil {
+		return e.ID
+	}
+	return p.router.Key(e)
+}
+
+// encode serializes an entity with the configured encoder
+func (p *Publisher) encode(ctx context.Context, e *entity.Entity, topic string) ([]byte, error) {
+	if p.encoder == nil {
+		return e.MarshalForEvent()
+	}
+	return p.encoder.Encode(ctx, e, topic)
+}
+
+// contentType returns the content type of encoded entities
+func (p *Publisher) contentType() string {
+	if p.encoder == nil {
+		return "application/json"
+	}
+	return p.encoder.ContentType()
+}
+
+// newMessage builds a NATS message with the standard headers
+func (p *Publisher) newMessage(subject, key string, data []byte, contentType string) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set("key", key)
+	msg.Header.Set("producer", p.producer)
+	msg.Header.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if contentType != "" {
+		msg.Header.Set("content-type", contentType)
+	}
+	return msg
+}
+
+// PublishEntity publishes an entity to the message bus
+func (p *Publisher) PublishEntity(ctx context.Context, entity *entity.Entity, topic string) error {
+	data, err := p.encode(ctx, entity, topic)
+	if err != nil {
+		return fmt.Errorf("error marshaling entity: %w", err)
+	}
+
+	return p.publish(ctx, p.newMessage(topic, p.key(entity), data, p.contentType()))
+}
+
+// PublishRaw publishes raw data to the message bus
+func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	return p.publish(ctx, p.newMessage(topic, key, data, ""))
+}
+
+// publish publishes a single message and waits for the stream's acknowledgement
+func (p *Publisher) publish(ctx context.Context, msg *nats.Msg) error {
+	if err := ValidateSubject(msg.Subject); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.ackTimeout)
+	defer cancel()
+
+	ack, err := p.js.PublishMsg(ctx, msg)
+	if err != nil {
+		log.Error().
+			Str("subject", msg.Subject).
+			Str("key", msg.Header.Get("key")).
+			Err(err).
+			Msg("Failed to publish message to NATS")
+		return fmt.Errorf("failed to publish message to %s: %w", msg.Subject, err)
+	}
+
+	log.Debug().
+		Str("subject", msg.Subject).
+		Str("stream", ack.Stream).
+		Uint64("sequence", ack.Sequence).
+		Int("dataSize", len(msg.Data)).
+		Msg("Published message to NATS")
+
+	return nil
+}
+
+// PublishBatch publishes a batch of entities asynchronously and waits for every acknowledgement
+func (p *Publisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	if err := ValidateSubject(topic); err != nil {
+		return err
+	}
+
+	batchErr := &ports.BatchError{Errors: make([]error, len(entities))}
+	futures := make([]jetstream.PubAckFuture, len(entities))
+	start := time.Now()
+
+	for i, e := range entities {
+		data, err := p.encode(ctx, e, topic)
+		if err != nil {
+			batchErr.Errors[i] = fmt.Errorf("error marshaling entity: %w", err)
+			continue
+		}
+		future, err := p.js.PublishMsgAsync(p.newMessage(topic, p.key(e), data, p.contentType()))
+		if err != nil {
+			batchErr.Errors[i] = fmt.Errorf("failed to publish message to %s: %w", topic, err)
+			continue
+		}
+		futures[i] = future
+	}
+
+	timeout := time.NewTimer(p.ackTimeout)
+	defer timeout.Stop()
+
+	for i, future := range futures {
+		if future == nil {
+			continue
+		}
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			batchErr.Errors[i] = fmt.Errorf("failed to publish message to %s: %w", topic, err)
+		case <-timeout.C:
+			batchErr.Errors[i] = fmt.Errorf("failed to publish message to %s: %w", topic, context.DeadlineExceeded)
+			// Every remaining acknowledgement is overdue as well
+			timeout.Reset(0)
+		case <-ctx.Done():
+			batchErr.Errors[i] = fmt.Errorf("failed to publish message to %s: %w", topic, ctx.Err())
+		}
+	}
+
+	log.Debug().
+		Str("subject", topic).
+		Int("messages", len(entities)).
+		Dur("duration", time.Since(start)).
+		Msg("Published batch to NATS")
+
+	if failed := batchErr.Failed(); len(failed) > 0 {
+		log.Error().
+			Str("subject", topic).
+			Int("failed", len(failed)).
+			Int("total", len(entities)).
+			Err(batchErr.Errors[failed[0]]).
+			Msg("Failed to publish batch to NATS")
+		return batchErr
+	}
+
+	return nil
+}
+
+// Close drains pending publishes and closes the NATS connection
+func (p *Publisher) Close() error {
+	if err := p.conn.Drain(); err != nil {
+		p.conn.Close()
+		return fmt.Errorf("error draining nats connection: %w", err)
+	}
+	return nil
+}
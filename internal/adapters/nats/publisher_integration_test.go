@@ -0,0 +1,166 @@
+//go:build integration
+
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+)
+
+// These tests need a JetStream enabled server at NATS_URL (default
+// nats://127.0.0.1:4222), e.g. docker run -p 4222:4222 nats -js, and run with
+// go test -tags integration ./internal/adapters/nats
+
+const testEndpoint = "https://gateway.thegraph.com/api/subgraphs/id/QmTestDeployment"
+
+// newTestPublisher creates a publisher on a stream of its own, routing
+// entities to "<prefix>.<chain>.<query type>" subjects keyed by pool
+func newTestPublisher(t *testing.T) (*Publisher, *routing.Router) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	router, err := routing.NewRouter(routing.Config{
+		Prefix:        fmt.Sprintf("it%d", time.Now().UnixNano()),
+		TopicTemplate: "{{.Prefix}}.{{.Chain}}.{{.QueryType}}",
+		KeyStrategy:   routing.KeyPoolID,
+		Deployments: map[string]routing.Deployment{
+			testEndpoint: {Alias: "uniswap", Chain: "avax"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := fmt.Sprintf("IT_%d", time.Now().UnixNano())
+	publisher, err := NewPublisher(PublisherConfig{
+		URL:          url,
+		Router:       router,
+		Stream:       stream,
+		CreateStream: true,
+		AckTimeout:   2 * time.Second,
+	})
+	if err != nil {
+		t.Skipf("nats is not available: %v", err)
+	}
+	t.Cleanup(func() {
+		publisher.js.DeleteStream(context.Background(), stream)
+		publisher.Close()
+	})
+	return publisher, router
+}
+
+// testSwaps returns swaps of two pools
+func testSwaps() []*entity.Entity {
+	swaps := make([]*entity.Entity, 4)
+	for i := range swaps {
+		swaps[i] = &entity.Entity{
+			ID:         fmt.Sprintf("0x%02d", i),
+			Type:       "swaps",
+			Deployment: testEndpoint,
+			Timestamp:  time.Now(),
+			Data: map[string]interface{}{
+				"id":   fmt.Sprintf("0x%02d", i),
+				"pool": map[string]interface{}{"id": fmt.Sprintf("0xpool%d", i%2)},
+			},
+		}
+	}
+	return swaps
+}
+
+func TestPublishBatchToRoutedSubject(t *testing.T) {
+	publisher, router := newTestPublisher(t)
+	ctx := context.Background()
+
+	subject, err := router.Topic(testEndpoint, "swaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.EnsureTopics(ctx, []string{subject}); err != nil {
+		t.Fatal(err)
+	}
+
+	swaps := testSwaps()
+	if err := publisher.PublishBatch(ctx, swaps, subject); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every message was acknowledged, so the stream holds all of them
+	stream, err := publisher.js.Stream(ctx, publisher.stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != uint64(len(swaps)) {
+		t.Fatalf("stream holds %d messages, want %d", info.State.Msgs, len(swaps))
+	}
+
+	for i, swap := range swaps {
+		msg, err := stream.GetMsg(ctx, uint64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Subject != subject {
+			t.Errorf("message %d subject = %q, want %q", i, msg.Subject, subject)
+		}
+		if key, want := msg.Header.Get("key"), router.Key(swap); key != want {
+			t.Errorf("message %d key = %q, want %q", i, key, want)
+		}
+		if contentType := msg.Header.Get("content-type"); contentType != "application/json" {
+			t.Errorf("message %d content type = %q", i, contentType)
+		}
+	}
+}
+
+func TestPublishToUncapturedSubject(t *testing.T) {
+	publisher, router := newTestPublisher(t)
+	ctx := context.Background()
+
+	subject, err := router.Topic(testEndpoint, "swaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.EnsureTopics(ctx, []string{subject}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No stream captures the subject, so the publish is never acknowledged
+	other, err := router.Topic(testEndpoint, "mints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swaps := testSwaps()
+	err = publisher.PublishEntity(ctx, swaps[0], other)
+	if !errors.Is(err, jetstream.ErrNoStreamResponse) && !errors.Is(err, nats.ErrNoResponders) {
+		t.Fatalf("PublishEntity error = %v, want no stream response", err)
+	}
+
+	err = publisher.PublishBatch(ctx, swaps, other)
+	var batchErr *ports.BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Failed()) != len(swaps) {
+		t.Fatalf("PublishBatch error = %v, want every message to fail", err)
+	}
+}
+
+func TestPublishRejectsInvalidSubject(t *testing.T) {
+	publisher, _ := newTestPublisher(t)
+
+	if err := publisher.PublishRaw(context.Background(), "key", []byte("{}"), "thegraph.*.swaps"); err == nil {
+		t.Fatal("PublishRaw accepted a wildcard subject")
+	}
+}
@@ -0,0 +1,60 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNacked is returned when the broker negatively acknowledges a message,
+// which happens when it cannot take the write, e.g. under a resource alarm
+var ErrNacked = errors.New("message was nacked by the broker")
+
+// ValidateRoutingKey checks that a routed topic is a valid routing key
+func ValidateRoutingKey(key string) error {
+	switch {
+	case key == "":
+		return errors.New("routing key is empty")
+	case len(key) > 255:
+		return fmt.Errorf("routing key %q is longer than 255 bytes", key)
+	}
+	return nil
+}
+
+// IsUnavailable reports whether a publish error means RabbitMQ could not take
+// the message right now, as opposed to the message being rejected.
+// Batch errors are unavailable only if every failure is.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !IsUnavailable(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Recover || amqpErr.Code == amqp.ConnectionForced || amqpErr.Code == amqp.ChannelError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, ErrNacked) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
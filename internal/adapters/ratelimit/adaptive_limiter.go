@@ -2,6 +2,7 @@ package ratelimit
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
 
@@ -93,17 +94,24 @@ func (l *AdaptiveLimiter) Wait(ctx context.Context) error {
 
 // Done signals that a request has completed
 func (l *AdaptiveLimiter) Done(success bool, latency time.Duration) {
+	l.Record(success, latency)
+	
+	// Adjust rate based on success and latency
+	l.adjustRate(success, latency)
+}
+
+// Record records the outcome of a request in the success rate and latency
+// reported by Stats without adjusting the rate, for a controller that sets
+// the rate from outside
+func (l *AdaptiveLimiter) Record(success bool, latency time.Duration) {
 	// Record latency
 	l.recordLatency(latency)
-	
+
 	// Update success rate
 	l.mu.Lock()
 	// Use exponential moving average for success rate
 	l.successRate = 0.9*l.successRate + 0.1*boolToFloat(success)
 	l.mu.Unlock()
-	
-	// Adjust rate based on success and latency
-	l.adjustRate(success, latency)
 }
 
 // recordLatency records the latency of a request
@@ -269,4 +277,48 @@ func boolToFloat(b bool) float64 {
 		return 1.0
 	}
 	return 0.0
-} 
\ No newline at end of file
+}
+
+// LimiterStats is a snapshot of the limiter's state and performance metrics
+type LimiterStats struct {
+	Rate           float64
+	MinRate        float64
+	MaxRate        float64
+	SuccessRate    float64
+	AverageLatency time.Duration
+	Remaining      int
+	ResetAt        time.Time
+}
+
+// Stats returns a snapshot of the limiter's state and performance metrics
+func (l *AdaptiveLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	stats := LimiterStats{
+		Rate:        l.currentRate,
+		MinRate:     l.minRate,
+		MaxRate:     l.maxRate,
+		SuccessRate: l.successRate,
+		Remaining:   l.remaining,
+		ResetAt:     l.resetAt,
+	}
+	l.mu.Unlock()
+
+	stats.AverageLatency = l.getAverageLatency()
+	return stats
+}
+
+// SetRate sets the request rate, as a controller steering the limiter from
+// outside does, and returns the rate applied. The rate stays within the
+// minimum and maximum rates, and at the minimum while the API reports its
+// limit as almost reached.
+func (l *AdaptiveLimiter) SetRate(requestRate float64) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.remaining < 5 && !l.resetAt.IsZero() && time.Until(l.resetAt) > 0 {
+		requestRate = l.minRate
+	}
+	l.currentRate = math.Max(l.minRate, math.Min(l.maxRate, requestRate))
+	l.limiter.SetLimit(rate.Limit(l.currentRate))
+	return l.currentRate
+}
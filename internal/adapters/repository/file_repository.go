@@ -10,7 +10,7 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
-	
+
 	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
 )
 
@@ -23,8 +23,14 @@ type FileRepository struct {
 	cursorMu     sync.RWMutex
 	flushTimeout time.Duration
 	encoder      *json.Encoder
+
+	// pending tracks cursor files still being written in the background
+	pending sync.WaitGroup
 }
 
+// interruptedFile holds the tasks interrupted by the last shutdown
+const interruptedFile = "interrupted.json"
+
 // FileRepositoryConfig holds the configuration for the file repository
 type FileRepositoryConfig struct {
 	BaseDir      string
@@ -37,22 +43,22 @@ func NewFileRepository(config FileRepositoryConfig) (*FileRepository, error) {
 	if config.BaseDir == "" {
 		config.BaseDir = "data"
 	}
-	
+
 	// Set default flush timeout if not provided
 	if config.FlushTimeout <= 0 {
 		config.FlushTimeout = 5 * time.Second
 	}
-	
+
 	// Create directories
 	metadataDir := filepath.Join(config.BaseDir, "metadata")
 	entityDir := filepath.Join(config.BaseDir, "entities")
-	
+
 	for _, dir := range []string{metadataDir, entityDir} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
-	
+
 	repo := &FileRepository{
 		baseDir:      config.BaseDir,
 		metadataDir:  metadataDir,
@@ -60,12 +66,12 @@ func NewFileRepository(config FileRepositoryConfig) (*FileRepository, error) {
 		cursorCache:  make(map[string]string),
 		flushTimeout: config.FlushTimeout,
 	}
-	
+
 	// Load existing cursors into cache
 	if err := repo.loadCursors(); err != nil {
 		log.Warn().Err(err).Msg("Failed to load cursors from disk")
 	}
-	
+
 	return repo, nil
 }
 
@@ -75,12 +81,12 @@ func (r *FileRepository) loadCursors() error {
 	if err != nil {
 		return fmt.Errorf("failed to read metadata directory: %w", err)
 	}
-	
+
 	for _, file := range files {
 		if file.IsDir() || filepath.Ext(file.Name()) != ".cursor" {
 			continue
 		}
-		
+
 		path := filepath.Join(r.metadataDir, file.Name())
 		data, err := os.ReadFile(path)
 		if err != nil {
@@ -90,18 +96,18 @@ func (r *FileRepository) loadCursors() error {
 				Msg("Failed to read cursor file")
 			continue
 		}
-		
+
 		key := file.Name()[:len(file.Name())-7] // Remove .cursor extension
 		r.cursorMu.Lock()
 		r.cursorCache[key] = string(data)
 		r.cursorMu.Unlock()
-		
+
 		log.Debug().
 			Str("key", key).
 			Str("cursor", string(data)).
 			Msg("Loaded cursor from file")
 	}
-	
+
 	return nil
 }
 
@@ -110,31 +116,33 @@ func (r *FileRepository) SaveEntity(ctx context.Context, e *entity.Entity) error
 	if e == nil {
 		return fmt.Errorf("cannot save nil entity")
 	}
-	
+
 	// Generate a filename based on entity type, deployment, and ID
 	key := fmt.Sprintf("%s_%s", e.Type, e.Deployment)
 	filename := fmt.Sprintf("%s_%s_%d.json", e.Type, e.ID, e.Timestamp.UnixNano())
 	path := filepath.Join(r.entityDir, filename)
-	
+
 	// Marshal the entity to JSON
 	data, err := json.MarshalIndent(e, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error marshaling entity: %w", err)
 	}
-	
+
 	// Write the entity to a file
 	if err := os.WriteFile(path, data, 0644); err != nil {
 		return fmt.Errorf("error writing entity file: %w", err)
 	}
-	
+
 	// Update cursor cache if this entity has an ID
 	if e.ID != "" {
 		r.cursorMu.Lock()
 		r.cursorCache[key] = e.ID
 		r.cursorMu.Unlock()
-		
+
 		// Write cursor to a file asynchronously
+		r.pending.Add(1)
 		go func() {
+			defer r.pending.Done()
 			cursorPath := filepath.Join(r.metadataDir, key+".cursor")
 			if err := os.WriteFile(cursorPath, []byte(e.ID), 0644); err != nil {
 				log.Error().
@@ -145,7 +153,7 @@ func (r *FileRepository) SaveEntity(ctx context.Context, e *entity.Entity) error
 			}
 		}()
 	}
-	
+
 	return nil
 }
 
@@ -154,40 +162,42 @@ func (r *FileRepository) SaveEntityStream(ctx context.Context, entityType, deplo
 	if len(entities) == 0 {
 		return nil
 	}
-	
+
 	// Generate a filename based on entity type, deployment, and timestamp
 	timestamp := time.Now().UTC().Format("20060102_150405")
 	filename := fmt.Sprintf("%s_%s_%s.jsonl", entityType, deployment, timestamp)
 	path := filepath.Join(r.entityDir, filename)
-	
+
 	// Create or truncate the file
 	file, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("error creating entity file: %w", err)
 	}
 	defer file.Close()
-	
+
 	// Create a JSON encoder that writes to the file
 	encoder := json.NewEncoder(file)
-	
+
 	// Write each entity as a separate JSON line
 	for _, e := range entities {
 		if err := encoder.Encode(e); err != nil {
 			return fmt.Errorf("error encoding entity: %w", err)
 		}
 	}
-	
+
 	// Update cursor cache if there are entities with IDs
 	if len(entities) > 0 && entities[len(entities)-1].ID != "" {
 		key := fmt.Sprintf("%s_%s", entityType, deployment)
 		lastID := entities[len(entities)-1].ID
-		
+
 		r.cursorMu.Lock()
 		r.cursorCache[key] = lastID
 		r.cursorMu.Unlock()
-		
+
 		// Write cursor to a file asynchronously
+		r.pending.Add(1)
 		go func() {
+			defer r.pending.Done()
 			cursorPath := filepath.Join(r.metadataDir, key+".cursor")
 			if err := os.WriteFile(cursorPath, []byte(lastID), 0644); err != nil {
 				log.Error().
@@ -198,23 +208,23 @@ func (r *FileRepository) SaveEntityStream(ctx context.Context, entityType, deplo
 			}
 		}()
 	}
-	
+
 	return nil
 }
 
 // GetLatestCursor gets the latest cursor for a given entity type and deployment
 func (r *FileRepository) GetLatestCursor(ctx context.Context, entityType, deployment string) (string, error) {
 	key := fmt.Sprintf("%s_%s", entityType, deployment)
-	
+
 	// Try to get cursor from cache
 	r.cursorMu.RLock()
 	cursor, exists := r.cursorCache[key]
 	r.cursorMu.RUnlock()
-	
+
 	if exists {
 		return cursor, nil
 	}
-	
+
 	// If not in cache, try to read from file
 	cursorPath := filepath.Join(r.metadataDir, key+".cursor")
 	data, err := os.ReadFile(cursorPath)
@@ -225,18 +235,144 @@ func (r *FileRepository) GetLatestCursor(ctx context.Context, entityType, deploy
 		}
 		return "", fmt.Errorf("error reading cursor file: %w", err)
 	}
-	
+
 	// Update cache
 	cursor = string(data)
 	r.cursorMu.Lock()
 	r.cursorCache[key] = cursor
 	r.cursorMu.Unlock()
-	
+
 	return cursor, nil
 }
 
-// Close flushes any pending data and closes the repository
-func (r *FileRepository) Close() error {
-	// Nothing to close for file repository
+// SaveCursor durably stores the cursor for a given entity type and deployment
+func (r *FileRepository) SaveCursor(ctx context.Context, entityType, deployment, cursor string) error {
+	if cursor == "" {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s_%s", entityType, deployment)
+	cursorPath := filepath.Join(r.metadataDir, key+".cursor")
+
+	// Write to a temporary file and rename it so a crash never leaves a torn cursor
+	tmpPath := cursorPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(cursor), 0644); err != nil {
+		return fmt.Errorf("error writing cursor file: %w", err)
+	}
+	if err := os.Rename(tmpPath, cursorPath); err != nil {
+		return fmt.Errorf("error replacing cursor file: %w", err)
+	}
+
+	r.cursorMu.Lock()
+	r.cursorCache[key] = cursor
+	r.cursorMu.Unlock()
+
 	return nil
-} 
\ No newline at end of file
+}
+
+// Ping checks that cursors can be written by writing and removing a probe file
+func (r *FileRepository) Ping(ctx context.Context) error {
+	probe, err := os.CreateTemp(r.metadataDir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("checkpoint store is not writable: %w", err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("error removing checkpoint store probe: %w", err)
+	}
+	return nil
+}
+
+// SaveInterrupted records the tasks interrupted by a shutdown, replacing the
+// previous record. An empty list removes it.
+func (r *FileRepository) SaveInterrupted(ctx context.Context, tasks []entity.InterruptedTask) error {
+	path := filepath.Join(r.metadataDir, interruptedFile)
+	if len(tasks) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing interrupted tasks: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling interrupted tasks: %w", err)
+	}
+
+	// Write to a temporary file and rename it so a crash never leaves a torn record
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing interrupted tasks: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing interrupted tasks: %w", err)
+	}
+	return nil
+}
+
+// GetInterrupted returns the tasks recorded by the last SaveInterrupted
+func (r *FileRepository) GetInterrupted(ctx context.Context) ([]entity.InterruptedTask, error) {
+	data, err := os.ReadFile(filepath.Join(r.metadataDir, interruptedFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading interrupted tasks: %w", err)
+	}
+
+	var tasks []entity.InterruptedTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("error decoding interrupted tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+// Flush waits for cursor files written in the background and syncs the
+// cursor files and the metadata directory to disk
+func (r *FileRepository) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for cursor writes: %w", ctx.Err())
+	}
+
+	files, err := os.ReadDir(r.metadataDir)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata directory: %w", err)
+	}
+	for _, file := range files {
+		if file.IsDir() || (filepath.Ext(file.Name()) != ".cursor" && file.Name() != interruptedFile) {
+			continue
+		}
+		if err := syncFile(filepath.Join(r.metadataDir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return syncFile(r.metadataDir)
+}
+
+// syncFile commits a file or directory to disk
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Close flushes pending cursor writes, waiting at most the flush timeout
+func (r *FileRepository) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.flushTimeout)
+	defer cancel()
+	return r.Flush(ctx)
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// runFileTimeFormat names report files so they sort by start time
+const runFileTimeFormat = "20060102T150405.000Z"
+
+// FileRunStore is an adapter that implements the ports.RunStore interface by
+// writing each run report to its own JSON file
+type FileRunStore struct {
+	dir     string
+	maxRuns int
+	mu      sync.Mutex
+}
+
+// FileRunStoreConfig holds the configuration for the file run store
+type FileRunStoreConfig struct {
+	Dir string
+
+	// MaxRuns is how many reports are kept; older ones are removed (default 500)
+	MaxRuns int
+}
+
+// NewFileRunStore creates a new file run store
+func NewFileRunStore(config FileRunStoreConfig) (*FileRunStore, error) {
+	// Set defaults for configuration
+	if config.Dir == "" {
+		config.Dir = filepath.Join("data", "runs")
+	}
+	if config.MaxRuns <= 0 {
+		config.MaxRuns = 500
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create run report directory: %w", err)
+	}
+
+	return &FileRunStore{
+		dir:     config.Dir,
+		maxRuns: config.MaxRuns,
+	}, nil
+}
+
+// SaveRun writes the report of a run and removes the oldest reports beyond MaxRuns
+func (s *FileRunStore) SaveRun(ctx context.Context, report *entity.RunReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling run report: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := report.StartedAt.UTC().Format(runFileTimeFormat) + "_" + report.ID + ".json"
+	path := filepath.Join(s.dir, name)
+
+	// Write to a temporary file and rename it so readers never see a torn report
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing run report: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing run report: %w", err)
+	}
+
+	s.prune()
+	return nil
+}
+
+// ListRuns returns the most recent reports, newest first
+func (s *FileRunStore) ListRuns(ctx context.Context, limit int) ([]*entity.RunReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(names) > limit {
+		names = names[len(names)-limit:]
+	}
+
+	reports := make([]*entity.RunReport, 0, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		report, err := s.read(names[i])
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// GetRun returns the report of a run
+func (s *FileRunStore) GetRun(ctx context.Context, runID string) (*entity.RunReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if strings.TrimSuffix(name[strings.Index(name, "_")+1:], ".json") == runID {
+			return s.read(name)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ports.ErrRunNotFound, runID)
+}
+
+// names returns the report file names, oldest first. It must be called with the lock held.
+func (s *FileRunStore) names() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run report directory: %w", err)
+	}
+
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || !strings.Contains(file.Name(), "_") {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// read decodes a report file
+func (s *FileRunStore) read(name string) (*entity.RunReport, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading run report: %w", err)
+	}
+
+	var report entity.RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("error decoding run report %s: %w", name, err)
+	}
+	return &report, nil
+}
+
+// prune removes the oldest reports beyond MaxRuns. It must be called with the lock held.
+func (s *FileRunStore) prune() {
+	names, err := s.names()
+	if err != nil || len(names) <= s.maxRuns {
+		return
+	}
+
+	for _, name := range names[:len(names)-s.maxRuns] {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			log.Warn().
+				Str("file", name).
+				Err(err).
+				Msg("Failed to remove old run report")
+		}
+	}
+}
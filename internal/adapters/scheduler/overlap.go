@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Overlap policies applied when a job is triggered while its previous run is still going
+const (
+	// OverlapSkip drops the new run
+	OverlapSkip = "skip"
+	// OverlapQueue runs the new run once the previous one finishes, keeping at most one queued
+	OverlapQueue = "queue"
+	// OverlapCancel cancels the previous run and starts the new one once it has stopped
+	OverlapCancel = "cancel"
+)
+
+// Reasons reported for skipped triggers
+const (
+	// SkipRunning is reported by the skip policy while a run is in progress
+	SkipRunning = "running"
+	// SkipQueueFull is reported by the queue policy when a run is already queued
+	SkipQueueFull = "queue_full"
+)
+
+// Job is a cron job running a function under a context while applying an
+// overlap policy. It implements cron.Job.
+type Job struct {
+	name   string
+	run    func(ctx context.Context)
+	policy string
+	ctx    context.Context
+	onSkip func(reason string)
+
+	mu        sync.Mutex
+	running   bool
+	startedAt time.Time
+	queued    bool
+	runCtx    context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// JobConfig holds the configuration for a job
+type JobConfig struct {
+	// Name identifies the job in logs and metrics (default "extraction")
+	Name string
+
+	// Policy is the overlap policy: "skip" (default), "queue" or "cancel"
+	Policy string
+
+	// Context is the parent of the context of every run (default context.Background())
+	Context context.Context
+
+	// OnSkip is called with the reason of every skipped trigger
+	OnSkip func(reason string)
+}
+
+// NewJob creates a new job running run on every trigger
+func NewJob(run func(ctx context.Context), config JobConfig) (*Job, error) {
+	// Set defaults for configuration
+	if config.Name == "" {
+		config.Name = "extraction"
+	}
+	if config.Policy == "" {
+		config.Policy = OverlapSkip
+	}
+	if config.Context == nil {
+		config.Context = context.Background()
+	}
+	if config.OnSkip == nil {
+		config.OnSkip = func(string) {}
+	}
+
+	switch config.Policy {
+	case OverlapSkip, OverlapQueue, OverlapCancel:
+	default:
+		return nil, fmt.Errorf("unknown overlap policy %q", config.Policy)
+	}
+
+	return &Job{
+		name:   config.Name,
+		run:    run,
+		policy: config.Policy,
+		ctx:    config.Context,
+		onSkip: config.OnSkip,
+	}, nil
+}
+
+// Run triggers the job and returns once the run it started, if any, has finished
+func (j *Job) Run() {
+	j.mu.Lock()
+	if !j.running {
+		j.start()
+		j.mu.Unlock()
+		j.execute()
+		return
+	}
+
+	elapsed := time.Since(j.startedAt).Round(time.Second)
+	switch j.policy {
+	case OverlapQueue:
+		if j.queued {
+			j.mu.Unlock()
+			j.skip(SkipQueueFull, elapsed, "another run is already queued")
+			return
+		}
+		j.queued = true
+		j.mu.Unlock()
+
+		log.Info().
+			Str("job", j.name).
+			Dur("runningFor", elapsed).
+			Msg("Previous run still in progress, queueing this one")
+
+	case OverlapCancel:
+		cancel, done := j.cancel, j.done
+		j.mu.Unlock()
+
+		log.Warn().
+			Str("job", j.name).
+			Dur("runningFor", elapsed).
+			Msg("Previous run still in progress, cancelling it")
+		cancel()
+		<-done
+
+		j.mu.Lock()
+		if j.running {
+			// Another trigger took over the slot while the previous run was stopping
+			j.mu.Unlock()
+			j.skip(SkipRunning, 0, "a newer run started first")
+			return
+		}
+		j.start()
+		j.mu.Unlock()
+		j.execute()
+
+	default:
+		j.mu.Unlock()
+		j.skip(SkipRunning, elapsed, "the previous run is still in progress")
+	}
+}
+
+// Running reports whether a run is in progress
+func (j *Job) Running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.running
+}
+
+// start marks a run as started. It must be called with the lock held.
+func (j *Job) start() {
+	j.runCtx, j.cancel = context.WithCancel(j.ctx)
+	j.running = true
+	j.startedAt = time.Now()
+	j.done = make(chan struct{})
+}
+
+// execute runs the job, then the queued run if one was queued meanwhile
+func (j *Job) execute() {
+	for {
+		j.mu.Lock()
+		ctx, cancel, done := j.runCtx, j.cancel, j.done
+		j.mu.Unlock()
+
+		j.run(ctx)
+		cancel()
+
+		j.mu.Lock()
+		close(done)
+		if !j.queued || j.ctx.Err() != nil {
+			j.queued = false
+			j.running = false
+			j.mu.Unlock()
+			return
+		}
+		j.queued = false
+		j.start()
+		j.mu.Unlock()
+
+		log.Info().
+			Str("job", j.name).
+			Msg("Starting queued run")
+	}
+}
+
+// skip reports a dropped trigger
+func (j *Job) skip(reason string, runningFor time.Duration, why string) {
+	log.Warn().
+		Str("job", j.name).
+		Str("policy", j.policy).
+		Str("reason", reason).
+		Dur("runningFor", runningFor).
+		Msgf("Skipping scheduled run: %s", why)
+	j.onSkip(reason)
+}
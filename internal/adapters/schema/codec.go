@@ -0,0 +1,106 @@
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// fieldValue renders a data value as the string carried in Avro and Protobuf events
+func fieldValue(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case bool:
+		return strconv.FormatBool(value), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case json.Number:
+		return value.String(), true
+	default:
+		// Nested selections are carried as JSON
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
+
+// encodeAvro writes an entity using the Avro binary encoding of the schema built by AvroSchema
+func encodeAvro(buf []byte, e *entity.Entity, fields []Field) []byte {
+	buf = appendAvroString(buf, e.ID)
+	buf = appendAvroString(buf, e.Type)
+	buf = appendAvroString(buf, e.Deployment)
+	buf = binary.AppendVarint(buf, e.Timestamp.UnixMilli())
+
+	// Optional values are unions of null (branch 0) and string (branch 1)
+	if e.Cursor == "" {
+		buf = binary.AppendVarint(buf, 0)
+	} else {
+		buf = binary.AppendVarint(buf, 1)
+		buf = appendAvroString(buf, e.Cursor)
+	}
+
+	for _, field := range fields {
+		value, ok := fieldValue(e.Data[field.Name])
+		if !ok {
+			buf = binary.AppendVarint(buf, 0)
+			continue
+		}
+		buf = binary.AppendVarint(buf, 1)
+		buf = appendAvroString(buf, value)
+	}
+
+	return buf
+}
+
+// appendAvroString appends a zig-zag length prefixed string
+func appendAvroString(buf []byte, s string) []byte {
+	buf = binary.AppendVarint(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+// Protobuf wire types used by the generated schemas
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// encodeProtobuf writes an entity using the Protobuf wire encoding of the schema built by ProtobufSchema
+func encodeProtobuf(buf []byte, e *entity.Entity, fields []Field) []byte {
+	buf = appendProtoString(buf, 1, e.ID)
+	buf = appendProtoString(buf, 2, e.Type)
+	buf = appendProtoString(buf, 3, e.Deployment)
+	buf = appendProtoTag(buf, 4, wireVarint)
+	buf = binary.AppendUvarint(buf, uint64(e.Timestamp.UnixMilli()))
+	if e.Cursor != "" {
+		buf = appendProtoString(buf, 5, e.Cursor)
+	}
+
+	var data []byte
+	for i, field := range fields {
+		if value, ok := fieldValue(e.Data[field.Name]); ok {
+			data = appendProtoString(data, i+1, value)
+		}
+	}
+	buf = appendProtoTag(buf, 6, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// appendProtoTag appends a field key
+func appendProtoTag(buf []byte, number, wireType int) []byte {
+	return binary.AppendUvarint(buf, uint64(number)<<3|uint64(wireType))
+}
+
+// appendProtoString appends a length delimited string field
+func appendProtoString(buf []byte, number int, s string) []byte {
+	buf = appendProtoTag(buf, number, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
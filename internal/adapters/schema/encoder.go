@@ -0,0 +1,151 @@
+package schema
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// Supported event encodings
+const (
+	FormatJSON     = "json"
+	FormatAvro     = "avro"
+	FormatProtobuf = "protobuf"
+)
+
+// magicByte prefixes every payload in the Confluent wire format
+const magicByte = 0
+
+// Encoder is an adapter that implements the ports.EventEncoder interface.
+// Avro and Protobuf payloads use the Confluent wire format: a magic byte and
+// the registry schema ID precede the encoded entity.
+type Encoder struct {
+	format    string
+	generator *Generator
+	registry  *RegistryClient
+	schemaIDs map[string]int
+	mu        sync.Mutex
+}
+
+// EncoderConfig holds the configuration for the event encoder
+type EncoderConfig struct {
+	// Format is one of "json", "avro" or "protobuf" (default "json")
+	Format    string
+	Generator *Generator
+	Registry  *RegistryClient
+}
+
+// NewEncoder creates a new event encoder
+func NewEncoder(config EncoderConfig) (*Encoder, error) {
+	// Set default format if not provided
+	if config.Format == "" {
+		config.Format = FormatJSON
+	}
+
+	switch config.Format {
+	case FormatJSON:
+	case FormatAvro, FormatProtobuf:
+		if config.Generator == nil {
+			return nil, errors.New("schema generator is required for " + config.Format + " encoding")
+		}
+		if config.Registry == nil {
+			return nil, errors.New("schema registry is required for " + config.Format + " encoding")
+		}
+	default:
+		return nil, fmt.Errorf("unknown event format %q", config.Format)
+	}
+
+	return &Encoder{
+		format:    config.Format,
+		generator: config.Generator,
+		registry:  config.Registry,
+		schemaIDs: make(map[string]int),
+	}, nil
+}
+
+// ContentType returns the MIME type of encoded payloads
+func (enc *Encoder) ContentType() string {
+	switch enc.format {
+	case FormatAvro:
+		return "application/vnd.confluent.avro"
+	case FormatProtobuf:
+		return "application/vnd.confluent.protobuf"
+	default:
+		return "application/json"
+	}
+}
+
+// Encode serializes an entity for publishing to topic
+func (enc *Encoder) Encode(ctx context.Context, e *entity.Entity, topic string) ([]byte, error) {
+	if enc.format == FormatJSON {
+		return e.MarshalForEvent()
+	}
+
+	fields, err := enc.generator.Fields(e.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaID, err := enc.schemaID(ctx, topic, e.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 5, 256)
+	buf[0] = magicByte
+	binary.BigEndian.PutUint32(buf[1:5], uint32(schemaID))
+
+	if enc.format == FormatAvro {
+		return encodeAvro(buf, e, fields), nil
+	}
+
+	// Protobuf payloads list the message indexes; a single 0 selects the first message
+	buf = append(buf, 0)
+	return encodeProtobuf(buf, e, fields), nil
+}
+
+// schemaID returns the registry ID of the schema for a topic, registering it on first use
+func (enc *Encoder) schemaID(ctx context.Context, topic, queryType string) (int, error) {
+	// Subjects follow the registry's default topic name strategy
+	subject := topic + "-value"
+
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	if id, ok := enc.schemaIDs[subject]; ok {
+		return id, nil
+	}
+
+	var schema, schemaType string
+	var err error
+	if enc.format == FormatAvro {
+		schema, err = enc.generator.AvroSchema(queryType)
+		schemaType = "AVRO"
+	} else {
+		schema, err = enc.generator.ProtobufSchema(queryType)
+		schemaType = "PROTOBUF"
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := enc.registry.Register(ctx, subject, schema, schemaType)
+	if err != nil {
+		return 0, err
+	}
+	enc.schemaIDs[subject] = id
+
+	log.Info().
+		Str("subject", subject).
+		Str("format", enc.format).
+		Int("schemaId", id).
+		Msg("Registered event schema")
+
+	return id, nil
+}
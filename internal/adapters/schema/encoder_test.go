@@ -0,0 +1,227 @@
+package schema
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// fakeRegistry is a schema registry that assigns one ID per subject and
+// counts the registrations it receives
+type fakeRegistry struct {
+	mu            sync.Mutex
+	ids           map[string]int
+	registrations map[string]int
+	schemaTypes   map[string]string
+}
+
+// newFakeRegistry starts a fake registry server
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	registry := &fakeRegistry{
+		ids:           make(map[string]int),
+		registrations: make(map[string]int),
+		schemaTypes:   make(map[string]string),
+	}
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+	return registry, server
+}
+
+// ServeHTTP implements http.Handler
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	subject, ok := strings.CutPrefix(req.URL.Path, "/subjects/")
+	subject, versions := strings.CutSuffix(subject, "/versions")
+	if req.Method != http.MethodPost || !ok || !versions {
+		http.NotFound(w, req)
+		return
+	}
+
+	var body registerRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Schema == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(registryError{ErrorCode: 42201, Message: "Invalid schema"})
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registrations[subject]++
+	r.schemaTypes[subject] = body.SchemaType
+	id, ok := r.ids[subject]
+	if !ok {
+		id = 100 + len(r.ids)
+		r.ids[subject] = id
+	}
+	json.NewEncoder(w).Encode(registerResponse{ID: id})
+}
+
+// registered returns how many times a subject was registered and its schema ID
+func (r *fakeRegistry) registered(subject string) (count, id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registrations[subject], r.ids[subject]
+}
+
+// newTestEncoder creates an encoder for swaps backed by a fake registry
+func newTestEncoder(t *testing.T, format string) (*Encoder, *fakeRegistry) {
+	registry, server := newFakeRegistry(t)
+	generator := NewGenerator(map[string]map[string]string{
+		"swaps": {
+			"default": `query { swaps(first: 100) { id amountUSD pool { id } } }`,
+		},
+	})
+	encoder, err := NewEncoder(EncoderConfig{
+		Format:    format,
+		Generator: generator,
+		Registry:  NewRegistryClient(RegistryConfig{URL: server.URL}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoder, registry
+}
+
+// testEntity returns a swap entity
+func testEntity() *entity.Entity {
+	return &entity.Entity{
+		ID:         "0x01",
+		Type:       "swaps",
+		Deployment: "deployment",
+		Timestamp:  time.UnixMilli(1700000000000),
+		Data: map[string]interface{}{
+			"id":        "0x01",
+			"amountUSD": "12.5",
+			"pool":      map[string]interface{}{"id": "0x02"},
+		},
+	}
+}
+
+func TestEncoderRegistersOncePerSubject(t *testing.T) {
+	encoder, registry := newTestEncoder(t, FormatAvro)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := encoder.Encode(ctx, testEntity(), "thegraph_swaps"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := encoder.Encode(ctx, testEntity(), "thegraph_swaps_eu"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, subject := range []string{"thegraph_swaps-value", "thegraph_swaps_eu-value"} {
+		if count, _ := registry.registered(subject); count != 1 {
+			t.Errorf("subject %s registered %d times, want 1", subject, count)
+		}
+	}
+}
+
+func TestEncoderWireFormat(t *testing.T) {
+	tests := []struct {
+		format     string
+		schemaType string
+		// indexes are the message-index bytes expected after the schema ID
+		indexes []byte
+	}{
+		{format: FormatAvro, schemaType: "", indexes: nil},
+		{format: FormatProtobuf, schemaType: "PROTOBUF", indexes: []byte{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			encoder, registry := newTestEncoder(t, tt.format)
+			e := testEntity()
+
+			payload, err := encoder.Encode(context.Background(), e, "thegraph_swaps")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, id := registry.registered("thegraph_swaps-value")
+			if registry.schemaTypes["thegraph_swaps-value"] != tt.schemaType {
+				t.Errorf("schema type = %q, want %q", registry.schemaTypes["thegraph_swaps-value"], tt.schemaType)
+			}
+			if len(payload) < 5+len(tt.indexes) {
+				t.Fatalf("payload of %d bytes is too short", len(payload))
+			}
+			if payload[0] != magicByte {
+				t.Errorf("magic byte = %d, want %d", payload[0], magicByte)
+			}
+			if got := binary.BigEndian.Uint32(payload[1:5]); got != uint32(id) {
+				t.Errorf("schema ID = %d, want %d", got, id)
+			}
+			if got := payload[5 : 5+len(tt.indexes)]; string(got) != string(tt.indexes) {
+				t.Errorf("message indexes = %v, want %v", got, tt.indexes)
+			}
+
+			fields, err := encoder.generator.Fields("swaps")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body []byte
+			if tt.format == FormatAvro {
+				body = encodeAvro(nil, e, fields)
+			} else {
+				body = encodeProtobuf(nil, e, fields)
+			}
+			if got := payload[5+len(tt.indexes):]; string(got) != string(body) {
+				t.Errorf("encoded entity = %x, want %x", got, body)
+			}
+		})
+	}
+}
+
+func TestEncodeProtobufFields(t *testing.T) {
+	e := testEntity()
+	fields := []Field{{Name: "id"}, {Name: "amountUSD"}, {Name: "missing"}}
+
+	got := encodeProtobuf(nil, e, fields)
+
+	var want []byte
+	want = append(want, 0x0a, 4)
+	want = append(want, "0x01"...)
+	want = append(want, 0x12, 5)
+	want = append(want, "swaps"...)
+	want = append(want, 0x1a, 10)
+	want = append(want, "deployment"...)
+	want = append(want, 0x20)
+	want = binary.AppendUvarint(want, 1700000000000)
+	// The data message holds fields 1 and 2; the missing field 3 is omitted
+	data := []byte{0x0a, 4}
+	data = append(data, "0x01"...)
+	data = append(data, 0x12, 4)
+	data = append(data, "12.5"...)
+	want = append(want, 0x32, byte(len(data)))
+	want = append(want, data...)
+
+	if string(got) != string(want) {
+		t.Errorf("encodeProtobuf = %x, want %x", got, want)
+	}
+}
+
+func TestRegistryRejection(t *testing.T) {
+	_, server := newFakeRegistry(t)
+	client := NewRegistryClient(RegistryConfig{URL: server.URL + "/"})
+
+	_, err := client.Register(context.Background(), "thegraph_swaps-value", "", "AVRO")
+	if err == nil || !strings.Contains(err.Error(), "Invalid schema (code 42201)") {
+		t.Errorf("Register error = %v, want the registry's rejection", err)
+	}
+}
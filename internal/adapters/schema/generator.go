@@ -0,0 +1,250 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Field describes a top-level field selected by a query
+type Field struct {
+	Name string
+	// Nested is true for fields with a selection set; their values are carried as JSON strings
+	Nested bool
+}
+
+// Generator derives per query type schemas from the GraphQL query templates
+type Generator struct {
+	fields map[string][]Field
+	mu     sync.RWMutex
+}
+
+// NewGenerator creates a schema generator from query variants keyed by query type and endpoint
+func NewGenerator(queryVariants map[string]map[string]string) *Generator {
+	g := &Generator{
+		fields: make(map[string][]Field),
+	}
+
+	for queryType, variants := range queryVariants {
+		// Visit the default variant first and the others in a stable order so
+		// field positions do not change between runs
+		endpoints := make([]string, 0, len(variants))
+		for endpoint := range variants {
+			if endpoint != "default" {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		sort.Strings(endpoints)
+		if _, ok := variants["default"]; ok {
+			endpoints = append([]string{"default"}, endpoints...)
+		}
+
+		seen := make(map[string]bool)
+		for _, endpoint := range endpoints {
+			for _, field := range selectionFields(variants[endpoint], queryType) {
+				if !seen[field.Name] {
+					seen[field.Name] = true
+					g.fields[queryType] = append(g.fields[queryType], field)
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+// Fields returns the union of fields selected for a query type across all endpoint variants
+func (g *Generator) Fields(queryType string) ([]Field, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	fields, ok := g.fields[queryType]
+	if !ok || len(fields) == 0 {
+		return nil, fmt.Errorf("no query fields known for %s", queryType)
+	}
+	return fields, nil
+}
+
+// AvroSchema returns the Avro schema of entity events for a query type
+func (g *Generator) AvroSchema(queryType string) (string, error) {
+	fields, err := g.Fields(queryType)
+	if err != nil {
+		return "", err
+	}
+
+	dataFields := make([]map[string]interface{}, 0, len(fields))
+	for _, field := range fields {
+		dataFields = append(dataFields, map[string]interface{}{
+			"name":    field.Name,
+			"type":    []string{"null", "string"},
+			"default": nil,
+		})
+	}
+
+	schema := map[string]interface{}{
+		"type":      "record",
+		"name":      recordName(queryType) + "Event",
+		"namespace": "thegraph",
+		"fields": []map[string]interface{}{
+			{"name": "id", "type": "string"},
+			{"name": "type", "type": "string"},
+			{"name": "deployment", "type": "string"},
+			{"name": "timestamp", "type": map[string]string{"type": "long", "logicalType": "timestamp-millis"}},
+			{"name": "cursor", "type": []string{"null", "string"}, "default": nil},
+			{"name": "data", "type": map[string]interface{}{
+				"type":   "record",
+				"name":   recordName(queryType) + "Data",
+				"fields": dataFields,
+			}},
+		},
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling avro schema: %w", err)
+	}
+	return string(data), nil
+}
+
+// ProtobufSchema returns the proto3 schema of entity events for a query type
+func (g *Generator) ProtobufSchema(queryType string) (string, error) {
+	fields, err := g.Fields(queryType)
+	if err != nil {
+		return "", err
+	}
+
+	name := recordName(queryType)
+	var b strings.Builder
+	b.WriteString("syntax = \"proto3\";\n\n")
+	b.WriteString("package thegraph;\n\n")
+	fmt.Fprintf(&b, "message %sEvent {\n", name)
+	b.WriteString("  string id = 1;\n")
+	b.WriteString("  string type = 2;\n")
+	b.WriteString("  string deployment = 3;\n")
+	b.WriteString("  int64 timestamp = 4;\n")
+	b.WriteString("  optional string cursor = 5;\n")
+	fmt.Fprintf(&b, "  %sData data = 6;\n", name)
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "message %sData {\n", name)
+	for i, field := range fields {
+		fmt.Fprintf(&b, "  optional string %s = %d;\n", field.Name, i+1)
+	}
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+// recordName turns a query type such as "skimFees" into a type name such as "SkimFees"
+func recordName(queryType string) string {
+	name := strings.TrimLeft(queryType, "_")
+	if name == "" {
+		return "Entity"
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// selectionFields returns the fields selected directly under the queryType collection of a query
+func selectionFields(query, queryType string) []Field {
+	// Locate the collection, e.g. "tokens(first: 1000) {" or "skimFees {"
+	start := -1
+	for offset := 0; offset < len(query); {
+		i := strings.Index(query[offset:], queryType)
+		if i < 0 {
+			break
+		}
+		i += offset
+		end := i + len(queryType)
+		if (i == 0 || !isNameRune(rune(query[i-1]))) && (end == len(query) || !isNameRune(rune(query[end]))) {
+			start = end
+			break
+		}
+		offset = end
+	}
+	if start < 0 {
+		return nil
+	}
+
+	// Skip arguments and move past the opening brace of the selection set
+	pos := skipSpace(query, start)
+	if pos < len(query) && query[pos] == '(' {
+		pos = skipParens(query, pos)
+	}
+	pos = skipSpace(query, pos)
+	if pos >= len(query) || query[pos] != '{' {
+		return nil
+	}
+	pos++
+
+	var fields []Field
+	for depth := 1; pos < len(query) && depth > 0; {
+		c := rune(query[pos])
+		switch {
+		case c == '{':
+			depth++
+			pos++
+		case c == '}':
+			depth--
+			pos++
+		case c == '(':
+			pos = skipParens(query, pos)
+		case isNameRune(c):
+			end := pos
+			for end < len(query) && isNameRune(rune(query[end])) {
+				end++
+			}
+			name := query[pos:end]
+			pos = end
+
+			if depth == 1 {
+				// Look ahead for arguments or a selection set
+				next := skipSpace(query, pos)
+				if next < len(query) && query[next] == '(' {
+					next = skipSpace(query, skipParens(query, next))
+				}
+				fields = append(fields, Field{
+					Name:   name,
+					Nested: next < len(query) && query[next] == '{',
+				})
+			}
+		default:
+			pos++
+		}
+	}
+
+	return fields
+}
+
+// skipSpace returns the position of the next non-whitespace character
+func skipSpace(s string, pos int) int {
+	for pos < len(s) && unicode.IsSpace(rune(s[pos])) {
+		pos++
+	}
+	return pos
+}
+
+// skipParens returns the position just after the parenthesis opened at pos
+func skipParens(s string, pos int) int {
+	depth := 0
+	for ; pos < len(s); pos++ {
+		switch s[pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pos + 1
+			}
+		}
+	}
+	return pos
+}
+
+// isNameRune reports whether c may appear in a GraphQL name
+func isNameRune(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
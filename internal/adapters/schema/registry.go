@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RegistryClient talks to a Confluent-compatible schema registry
+type RegistryClient struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// RegistryConfig holds the configuration for the schema registry client
+type RegistryConfig struct {
+	URL      string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// NewRegistryClient creates a new schema registry client
+func NewRegistryClient(config RegistryConfig) *RegistryClient {
+	// Set default timeout if not provided
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &RegistryClient{
+		baseURL:    strings.TrimRight(config.URL, "/"),
+		username:   config.Username,
+		password:   config.Password,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// registerRequest is the body of a schema registration
+type registerRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+// registerResponse is the body returned by a successful registration
+type registerResponse struct {
+	ID int `json:"id"`
+}
+
+// registryError is the error body returned by the registry
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// Register registers a schema under a subject and returns its global ID.
+// Registering an identical schema again returns the existing ID.
+func (c *RegistryClient) Register(ctx context.Context, subject, schema, schemaType string) (int, error) {
+	// The registry treats an empty schema type as AVRO
+	if schemaType == "AVRO" {
+		schemaType = ""
+	}
+
+	body, err := json.Marshal(registerRequest{Schema: schema, SchemaType: schemaType})
+	if err != nil {
+		return 0, fmt.Errorf("error marshaling schema: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions", c.baseURL, url.PathEscape(subject))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("error creating registry request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("schema registry request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading registry response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var regErr registryError
+		if json.Unmarshal(data, &regErr) == nil && regErr.Message != "" {
+			return 0, fmt.Errorf("schema registry rejected subject %s: %s (code %d)", subject, regErr.Message, regErr.ErrorCode)
+		}
+		return 0, fmt.Errorf("schema registry returned status %d for subject %s", resp.StatusCode, subject)
+	}
+
+	var registered registerResponse
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return 0, fmt.Errorf("error decoding registry response: %w", err)
+	}
+
+	return registered.ID, nil
+}
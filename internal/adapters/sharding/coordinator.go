@@ -0,0 +1,130 @@
+package sharding
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/shard"
+)
+
+// Sharding modes
+const (
+	// ModeNone makes every instance own every task
+	ModeNone = "none"
+	// ModeStatic spreads tasks over a fixed list of members by consistent hashing
+	ModeStatic = "static"
+	// ModeKafka spreads tasks over the live members of a Kafka consumer group
+	ModeKafka = "kafka"
+)
+
+// Assignee is told which tasks this instance owns
+type Assignee interface {
+	// Reassign sets the tasks this instance owns and returns once the tasks
+	// it no longer owns have stopped and their checkpoints are stored
+	Reassign(ctx context.Context, assignment shard.Assignment) error
+}
+
+// Coordinator assigns the tasks of a group of instances
+type Coordinator interface {
+	// Run assigns tasks to assignee until ctx is done
+	Run(ctx context.Context, assignee Assignee)
+
+	// Assigned is closed once the first assignment has been made
+	Assigned() <-chan struct{}
+
+	// Close leaves the group, handing the tasks of this instance over
+	Close() error
+}
+
+// assignedSignal closes a channel on the first assignment.
+// Use newAssignedSignal to create one.
+type assignedSignal struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+// newAssignedSignal creates an open assigned signal
+func newAssignedSignal() assignedSignal {
+	return assignedSignal{ch: make(chan struct{})}
+}
+
+// set records the first assignment
+func (a *assignedSignal) set() {
+	a.once.Do(func() { close(a.ch) })
+}
+
+// Standalone is a coordinator leaving every task to this instance
+type Standalone struct {
+	assigned assignedSignal
+}
+
+// NewStandalone creates a new standalone coordinator
+func NewStandalone() *Standalone {
+	return &Standalone{assigned: newAssignedSignal()}
+}
+
+// Run leaves the assignment alone: the assignee owns every task by default
+func (s *Standalone) Run(ctx context.Context, assignee Assignee) {
+	s.assigned.set()
+	<-ctx.Done()
+}
+
+// Assigned is closed once Run has started
+func (s *Standalone) Assigned() <-chan struct{} {
+	return s.assigned.ch
+}
+
+// Close does nothing
+func (s *Standalone) Close() error {
+	return nil
+}
+
+// Static is a coordinator spreading tasks over a fixed list of members by
+// consistent hashing. Every member must be configured with the same list;
+// changing the list moves only the tasks of the members added or removed.
+type Static struct {
+	member   shard.Member
+	assigned assignedSignal
+}
+
+// StaticConfig holds the configuration for a static coordinator
+type StaticConfig struct {
+	// Members names every instance of the group
+	Members []string
+
+	// Name is the name of this instance among Members
+	Name string
+}
+
+// NewStatic creates a new static coordinator
+func NewStatic(config StaticConfig) (*Static, error) {
+	member, err := shard.NewMember(config.Members, config.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &Static{member: member, assigned: newAssignedSignal()}, nil
+}
+
+// Run assigns the tasks of this member once
+func (s *Static) Run(ctx context.Context, assignee Assignee) {
+	if err := assignee.Reassign(ctx, s.member); err != nil {
+		log.Error().Err(err).Msg("Failed to assign tasks")
+	}
+	log.Info().
+		Str("member", s.member.Name).
+		Msg("Extracting the tasks of this shard member")
+	s.assigned.set()
+	<-ctx.Done()
+}
+
+// Assigned is closed once the tasks have been assigned
+func (s *Static) Assigned() <-chan struct{} {
+	return s.assigned.ch
+}
+
+// Close does nothing
+func (s *Static) Close() error {
+	return nil
+}
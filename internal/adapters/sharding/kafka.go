@@ -0,0 +1,318 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	kafkaadapter "github.com/panoramablock/thegraph-data-extraction/internal/adapters/kafka"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/shard"
+)
+
+// KafkaGroup is a coordinator spreading tasks over the live members of a
+// Kafka consumer group. Tasks hash to slots, the partitions of a slots
+// topic, and the group assigns slots to members by consistent hashing of
+// their names. Nothing is consumed from the topic.
+//
+// When a member joins or leaves, the group ends the current generation:
+// every member stops the tasks of its slots after their current page and
+// flushes their checkpoints before rejoining, so no slot is extracted by
+// two members at once and the new owner resumes from the last checkpoint.
+type KafkaGroup struct {
+	brokers        []string
+	groupID        string
+	topic          string
+	name           string
+	slots          int
+	replication    int
+	sessionTimeout time.Duration
+	handoffTimeout time.Duration
+	retryInterval  time.Duration
+	assigned       assignedSignal
+
+	mu     sync.Mutex
+	group  *kafka.ConsumerGroup
+	closed bool
+}
+
+// KafkaGroupConfig holds the configuration for a Kafka group coordinator
+type KafkaGroupConfig struct {
+	Brokers []string
+
+	// GroupID is the consumer group of the instances
+	GroupID string
+
+	// Topic is the slots topic, created with Slots partitions when missing.
+	// The partitions of an existing topic are the slots.
+	Topic string
+
+	// Name identifies this instance on the ring; instances keep their slots
+	// across restarts when their names are stable
+	Name string
+
+	// Slots is the number of slots of a created topic (default 32)
+	Slots int
+
+	// ReplicationFactor of the slots topic when created (default 1)
+	ReplicationFactor int
+
+	// SessionTimeout is how long the coordinator waits for the heartbeats
+	// of a member before evicting it (default 10s)
+	SessionTimeout time.Duration
+
+	// HandoffTimeout bounds how long a member takes to stop the tasks of
+	// its slots when the group rebalances (default 30s)
+	HandoffTimeout time.Duration
+
+	// RetryInterval is the wait before joining again after a failure (default 5s)
+	RetryInterval time.Duration
+}
+
+// NewKafkaGroup creates a new Kafka group coordinator
+func NewKafkaGroup(config KafkaGroupConfig) (*KafkaGroup, error) {
+	// Set defaults for configuration
+	if config.Slots <= 0 {
+		config.Slots = 32
+	}
+	if config.ReplicationFactor <= 0 {
+		config.ReplicationFactor = 1
+	}
+	if config.SessionTimeout <= 0 {
+		config.SessionTimeout = 10 * time.Second
+	}
+	if config.HandoffTimeout <= 0 {
+		config.HandoffTimeout = 30 * time.Second
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("no kafka brokers configured for sharding")
+	}
+	if config.GroupID == "" || config.Topic == "" || config.Name == "" {
+		return nil, errors.New("shard group, topic and member name must be set")
+	}
+
+	return &KafkaGroup{
+		brokers:        config.Brokers,
+		groupID:        config.GroupID,
+		topic:          config.Topic,
+		name:           config.Name,
+		slots:          config.Slots,
+		replication:    config.ReplicationFactor,
+		sessionTimeout: config.SessionTimeout,
+		handoffTimeout: config.HandoffTimeout,
+		retryInterval:  config.RetryInterval,
+		assigned:       newAssignedSignal(),
+	}, nil
+}
+
+// Run joins the group and assigns the tasks of the slots of every generation
+// until ctx is done or the coordinator is closed. The assignee owns no task
+// until the first generation.
+func (k *KafkaGroup) Run(ctx context.Context, assignee Assignee) {
+	if err := assignee.Reassign(ctx, shard.None{}); err != nil {
+		log.Error().Err(err).Msg("Failed to clear task assignment")
+	}
+
+	var group *kafka.ConsumerGroup
+	for {
+		var err error
+		group, err = k.join(ctx)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errClosed) || ctx.Err() != nil {
+			return
+		}
+		log.Warn().Err(err).Dur("retryIn", k.retryInterval).Msg("Failed to join shard group")
+		select {
+		case <-time.After(k.retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for {
+		generation, err := group.Next(ctx)
+		if errors.Is(err, kafka.ErrGroupClosed) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// The group rejoins by itself
+			log.Warn().Err(err).Str("group", k.groupID).Msg("Shard group membership failed")
+			continue
+		}
+
+		owned := make(map[int]bool)
+		for _, assignment := range generation.Assignments[k.topic] {
+			owned[assignment.ID] = true
+		}
+		if err := assignee.Reassign(ctx, shard.Slots{Count: k.slots, Owned: owned}); err != nil {
+			log.Error().Err(err).Msg("Failed to assign tasks")
+		}
+		k.assigned.set()
+
+		log.Info().
+			Str("group", k.groupID).
+			Int32("generation", generation.ID).
+			Int("slots", len(owned)).
+			Int("totalSlots", k.slots).
+			Msg("Assigned shard slots")
+
+		// Hand the slots over before the group moves to the next generation
+		generation.Start(func(genCtx context.Context) {
+			<-genCtx.Done()
+
+			handoffCtx, cancel := context.WithTimeout(context.Background(), k.handoffTimeout)
+			defer cancel()
+			if err := assignee.Reassign(handoffCtx, shard.None{}); err != nil {
+				log.Error().Err(err).Str("group", k.groupID).Msg("Shard handover did not complete cleanly")
+			}
+		})
+	}
+}
+
+// join creates the slots topic and joins the group
+func (k *KafkaGroup) join(ctx context.Context) (*kafka.ConsumerGroup, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return nil, errClosed
+	}
+
+	err := kafkaadapter.EnsureTopics(ctx, k.brokers, []string{k.topic}, kafkaadapter.TopicConfig{
+		Create:            true,
+		Partitions:        k.slots,
+		ReplicationFactor: k.replication,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating shard topic: %w", err)
+	}
+
+	// An existing topic decides the number of slots
+	slots, err := k.partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if slots != k.slots {
+		log.Warn().
+			Str("topic", k.topic).
+			Int("configuredSlots", k.slots).
+			Int("slots", slots).
+			Msg("Shard topic has a different number of partitions, using them as slots")
+		k.slots = slots
+	}
+
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:             k.groupID,
+		Brokers:        k.brokers,
+		Topics:         []string{k.topic},
+		GroupBalancers: []kafka.GroupBalancer{ringBalancer{name: k.name}},
+		SessionTimeout: k.sessionTimeout,
+		// Leave members the time to hand their slots over
+		RebalanceTimeout: k.handoffTimeout + k.sessionTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error joining shard group %s: %w", k.groupID, err)
+	}
+	k.group = group
+	return group, nil
+}
+
+// partitions returns the number of partitions of the slots topic
+func (k *KafkaGroup) partitions(ctx context.Context) (int, error) {
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second}
+	var err error
+	for _, broker := range k.brokers {
+		var conn *kafka.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		var partitions []kafka.Partition
+		partitions, err = conn.ReadPartitions(k.topic)
+		conn.Close()
+		if err == nil {
+			return len(partitions), nil
+		}
+	}
+	return 0, fmt.Errorf("error reading partitions of shard topic %s: %w", k.topic, err)
+}
+
+// Assigned is closed once the first generation has assigned slots
+func (k *KafkaGroup) Assigned() <-chan struct{} {
+	return k.assigned.ch
+}
+
+// Close leaves the group, handing the slots of this member over
+func (k *KafkaGroup) Close() error {
+	k.mu.Lock()
+	k.closed = true
+	group := k.group
+	k.group = nil
+	k.mu.Unlock()
+
+	if group == nil {
+		return nil
+	}
+	if err := group.Close(); err != nil {
+		return fmt.Errorf("error leaving shard group %s: %w", k.groupID, err)
+	}
+	return nil
+}
+
+// errClosed is returned when joining with a closed coordinator
+var errClosed = errors.New("shard coordinator is closed")
+
+// ringBalancer assigns the slots to members by consistent hashing of their
+// names, so that a member joining or leaving only moves its own slots
+type ringBalancer struct {
+	name string
+}
+
+// ProtocolName names the balancer to the group coordinator
+func (b ringBalancer) ProtocolName() string {
+	return "shard-ring"
+}
+
+// UserData tells the member elected to balance the group the name of this member
+func (b ringBalancer) UserData() ([]byte, error) {
+	return []byte(b.name), nil
+}
+
+// AssignGroups assigns every slot to the member owning it on the ring.
+// Members without a name, or sharing one, are placed by member ID.
+func (b ringBalancer) AssignGroups(members []kafka.GroupMember, partitions []kafka.Partition) kafka.GroupMemberAssignments {
+	assignments := make(kafka.GroupMemberAssignments, len(members))
+
+	names := make([]string, 0, len(members))
+	byName := make(map[string]string, len(members))
+	for _, member := range members {
+		name := string(member.UserData)
+		if _, taken := byName[name]; name == "" || taken {
+			name = member.ID
+		}
+		names = append(names, name)
+		byName[name] = member.ID
+		assignments[member.ID] = map[string][]int{}
+	}
+
+	ring := shard.NewRing(names, 0)
+	for _, partition := range partitions {
+		memberID := byName[ring.Owner(partition.Topic+"/"+strconv.Itoa(partition.ID))]
+		if memberID == "" {
+			continue
+		}
+		assignments[memberID][partition.Topic] = append(assignments[memberID][partition.Topic], partition.ID)
+	}
+	return assignments
+}
@@ -0,0 +1,519 @@
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// Record kinds stored in the spool
+const (
+	kindEntity = "entity"
+	kindRaw    = "raw"
+)
+
+// errCorrupt marks spool records that can never be published
+var errCorrupt = errors.New("corrupt spool record")
+
+// record is a spooled message
+type record struct {
+	Kind      string    `json:"kind"`
+	Topic     string    `json:"topic"`
+	Key       string    `json:"key,omitempty"`
+	Data      []byte    `json:"data"`
+	SpooledAt time.Time `json:"spooled_at"`
+}
+
+// Publisher wraps a ports.EventPublisher with a durable on-disk spool. Messages
+// that cannot be published because the broker is unavailable are appended to
+// a segmented log and drained in order once the broker is reachable again.
+// While the spool holds messages, new messages are appended behind them.
+type Publisher struct {
+	inner       ports.EventPublisher
+	log         *segmentLog
+	unavailable func(error) bool
+	deadLetters ports.DeadLetterSink
+	mu          sync.Mutex
+	spooling    bool
+
+	maxBytes   int64
+	alertRatio float64
+	alerted    bool
+	onAlert    func(used, max int64)
+
+	drainInterval time.Duration
+	drainBatch    int
+	cancel        context.CancelFunc
+	done          chan struct{}
+}
+
+// PublisherConfig holds the configuration for the spool publisher
+type PublisherConfig struct {
+	Dir string
+
+	// SegmentBytes is the size at which a new segment file is started
+	SegmentBytes int64
+
+	// MaxBytes caps the disk space used by the spool; publishes fail once it is reached
+	MaxBytes int64
+
+	// AlertRatio is the fraction of MaxBytes at which an alert is raised
+	AlertRatio float64
+
+	// OnAlert is called when disk usage crosses AlertRatio, in addition to logging
+	OnAlert func(used, max int64)
+
+	// DrainInterval is how often the spool tries to drain while it holds messages
+	DrainInterval time.Duration
+	DrainBatch    int
+
+	// Unavailable reports whether a publish error means the broker is
+	// unavailable. Every error is spooled when nil.
+	Unavailable func(error) bool
+
+	// DeadLetters receives spooled messages the broker rejects while draining.
+	// They are dropped when nil.
+	DeadLetters ports.DeadLetterSink
+}
+
+// NewPublisher creates a spool publisher around inner and starts draining
+// any messages left over from a previous run
+func NewPublisher(inner ports.EventPublisher, config PublisherConfig) (*Publisher, error) {
+	// Set defaults for configuration
+	if config.Dir == "" {
+		config.Dir = filepath.Join("data", "spool")
+	}
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = 64 << 20 // 64 MiB
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 1 << 30 // 1 GiB
+	}
+	if config.AlertRatio <= 0 || config.AlertRatio > 1 {
+		config.AlertRatio = 0.9
+	}
+	if config.DrainInterval <= 0 {
+		config.DrainInterval = 5 * time.Second
+	}
+	if config.DrainBatch <= 0 {
+		config.DrainBatch = 100
+	}
+	if config.Unavailable == nil {
+		config.Unavailable = func(error) bool { return true }
+	}
+
+	segments, err := openSegmentLog(config.Dir, config.SegmentBytes, config.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Publisher{
+		inner:         inner,
+		log:           segments,
+		unavailable:   config.Unavailable,
+		deadLetters:   config.DeadLetters,
+		spooling:      segments.Used() > 0,
+		maxBytes:      config.MaxBytes,
+		alertRatio:    config.AlertRatio,
+		onAlert:       config.OnAlert,
+		drainInterval: config.DrainInterval,
+		drainBatch:    config.DrainBatch,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+
+	if p.spooling {
+		log.Warn().
+			Str("dir", config.Dir).
+			Int64("bytes", segments.Used()).
+			Msg("Spool holds messages from a previous run")
+	}
+
+	// Start the drain goroutine
+	go p.drainLoop(ctx)
+
+	return p, nil
+}
+
+// Spooling reports whether messages are currently being held in the spool
+func (p *Publisher) Spooling() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.spooling
+}
+
+// Used returns the disk space used by the spool in bytes
+func (p *Publisher) Used() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.log.Used()
+}
+
+// EnsureTopics provisions the topics through the wrapped publisher when it supports it
+func (p *Publisher) EnsureTopics(ctx context.Context, topics []string) error {
+	if ensurer, ok := p.inner.(interface {
+		EnsureTopics(ctx context.Context, topics []string) error
+	}); ok {
+		return ensurer.EnsureTopics(ctx, topics)
+	}
+	return nil
+}
+
+// Ping checks the wrapped publisher when it supports it
+func (p *Publisher) Ping(ctx context.Context) error {
+	if pinger, ok := p.inner.(interface {
+		Ping(ctx context.Context) error
+	}); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// PublishEntity publishes an entity, spooling it when the broker is unavailable
+func (p *Publisher) PublishEntity(ctx context.Context, e *entity.Entity, topic string) error {
+	if !p.Spooling() {
+		err := p.inner.PublishEntity(ctx, e, topic)
+		if err == nil || !p.unavailable(err) {
+			return err
+		}
+		log.Warn().
+			Str("topic", topic).
+			Err(err).
+			Msg("Broker unavailable, spooling messages")
+	}
+
+	rec, err := entityRecord(e, topic)
+	if err != nil {
+		return err
+	}
+	return p.spool(rec)
+}
+
+// PublishRaw publishes raw data, spooling it when the broker is unavailable
+func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	if !p.Spooling() {
+		err := p.inner.PublishRaw(ctx, key, data, topic)
+		if err == nil || !p.unavailable(err) {
+			return err
+		}
+		log.Warn().
+			Str("topic", topic).
+			Err(err).
+			Msg("Broker unavailable, spooling messages")
+	}
+
+	return p.spool(record{Kind: kindRaw, Topic: topic, Key: key, Data: data, SpooledAt: time.Now().UTC()})
+}
+
+// PublishBatch publishes a batch of entities. Entities that failed because the
+// broker is unavailable are spooled; other failures are reported as a *ports.BatchError.
+func (p *Publisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	batchErr := &ports.BatchError{Errors: make([]error, len(entities))}
+	spool := make([]int, 0, len(entities))
+
+	if p.Spooling() {
+		for i := range entities {
+			spool = append(spool, i)
+		}
+	} else {
+		err := p.inner.PublishBatch(ctx, entities, topic)
+		if err == nil {
+			return nil
+		}
+
+		var innerErr *ports.BatchError
+		if errors.As(err, &innerErr) && len(innerErr.Errors) == len(entities) {
+			for i, entityErr := range innerErr.Errors {
+				switch {
+				case entityErr == nil:
+				case p.unavailable(entityErr):
+					spool = append(spool, i)
+				default:
+					batchErr.Errors[i] = entityErr
+				}
+			}
+		} else if p.unavailable(err) {
+			for i := range entities {
+				spool = append(spool, i)
+			}
+		} else {
+			return err
+		}
+
+		if len(spool) > 0 {
+			log.Warn().
+				Str("topic", topic).
+				Int("messages", len(spool)).
+				Err(err).
+				Msg("Broker unavailable, spooling messages")
+		}
+	}
+
+	if len(spool) > 0 {
+		records := make([]record, 0, len(spool))
+		for _, i := range spool {
+			rec, err := entityRecord(entities[i], topic)
+			if err != nil {
+				batchErr.Errors[i] = err
+				continue
+			}
+			records = append(records, rec)
+		}
+		if err := p.spool(records...); err != nil {
+			for _, i := range spool {
+				if batchErr.Errors[i] == nil {
+					batchErr.Errors[i] = err
+				}
+			}
+		}
+	}
+
+	if len(batchErr.Failed()) > 0 {
+		return batchErr
+	}
+	return nil
+}
+
+// entityRecord builds the spool record of an entity
+func entityRecord(e *entity.Entity, topic string) (record, error) {
+	data, err := e.MarshalForEvent()
+	if err != nil {
+		return record{}, fmt.Errorf("error marshaling entity: %w", err)
+	}
+	return record{Kind: kindEntity, Topic: topic, Key: e.ID, Data: data, SpooledAt: time.Now().UTC()}, nil
+}
+
+// spool appends records to the spool and switches to spooling mode
+func (p *Publisher) spool(records ...record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	encoded := make([][]byte, 0, len(records))
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("error marshaling spool record: %w", err)
+		}
+		encoded = append(encoded, data)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.log.Append(encoded...); err != nil {
+		if errors.Is(err, ErrFull) {
+			log.Error().
+				Int64("used", p.log.Used()).
+				Int64("max", p.maxBytes).
+				Msg("Spool is full, dropping messages")
+		}
+		return fmt.Errorf("failed to spool %d messages: %w", len(records), err)
+	}
+	p.spooling = true
+	p.checkUsage()
+
+	return nil
+}
+
+// checkUsage raises an alert when disk usage crosses the alert threshold. It must
+// be called with the lock held.
+func (p *Publisher) checkUsage() {
+	used := p.log.Used()
+	near := float64(used) >= p.alertRatio*float64(p.maxBytes)
+
+	switch {
+	case near && !p.alerted:
+		p.alerted = true
+		log.Error().
+			Int64("used", used).
+			Int64("max", p.maxBytes).
+			Float64("ratio", float64(used)/float64(p.maxBytes)).
+			Msg("Spool disk usage is near its limit")
+		if p.onAlert != nil {
+			p.onAlert(used, p.maxBytes)
+		}
+	case !near && p.alerted:
+		p.alerted = false
+		log.Info().
+			Int64("used", used).
+			Int64("max", p.maxBytes).
+			Msg("Spool disk usage back below alert threshold")
+	}
+}
+
+// drainLoop periodically drains the spool while it holds messages
+func (p *Publisher) drainLoop(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.drainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if p.Spooling() {
+				p.drain(ctx)
+			}
+		}
+	}
+}
+
+// drain publishes spooled messages in order until the spool is empty or the
+// broker is unavailable again. Messages the broker rejects are sent to the
+// dead letter sink; the drain stops at one the sink cannot take.
+func (p *Publisher) drain(ctx context.Context) {
+	drained := 0
+	for {
+		p.mu.Lock()
+		records, positions, err := p.log.Read(p.drainBatch)
+		if err == nil && len(records) == 0 {
+			// Caught up; new messages go straight to the broker again
+			err = p.log.Reset()
+			p.spooling = false
+			p.checkUsage()
+			p.mu.Unlock()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to reset drained spool")
+			}
+			log.Info().
+				Int("messages", drained).
+				Msg("Spool drained")
+			return
+		}
+		p.mu.Unlock()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read spool")
+			return
+		}
+
+		committed := -1
+		for i, data := range records {
+			if err := p.publish(ctx, data); err != nil {
+				if !errors.Is(err, errCorrupt) && p.unavailable(err) {
+					log.Debug().Err(err).Msg("Broker still unavailable, keeping spooled messages")
+					break
+				}
+				if !p.reject(ctx, data, err) {
+					break
+				}
+			}
+			committed = i
+			drained++
+		}
+
+		if committed >= 0 {
+			p.mu.Lock()
+			err := p.log.Commit(positions[committed])
+			p.checkUsage()
+			p.mu.Unlock()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to commit spool position")
+				return
+			}
+		}
+		if committed < len(records)-1 {
+			if drained > 0 {
+				log.Info().
+					Int("messages", drained).
+					Msg("Partially drained spool")
+			}
+			return
+		}
+	}
+}
+
+// reject sends a spooled message the broker rejected to the dead letter sink,
+// or drops it when there is none. It returns false when the message must stay
+// in the spool because the sink failed.
+func (p *Publisher) reject(ctx context.Context, data []byte, cause error) bool {
+	if p.deadLetters == nil {
+		log.Error().
+			Err(cause).
+			Msg("Dropping spooled message rejected by the broker")
+		return true
+	}
+
+	// Corrupt records are kept as they were read from the spool
+	letter := &entity.DeadLetter{
+		Payload:  data,
+		Headers:  map[string]string{"source": "spool"},
+		Error:    cause.Error(),
+		Attempts: 1,
+		FailedAt: time.Now().UTC(),
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err == nil && !errors.Is(cause, errCorrupt) {
+		letter.Topic = rec.Topic
+		letter.Key = rec.Key
+		letter.Payload = rec.Data
+		letter.Headers["kind"] = rec.Kind
+		letter.Headers["spooled_at"] = rec.SpooledAt.Format(time.RFC3339Nano)
+	}
+
+	if err := p.deadLetters.Send(ctx, letter); err != nil {
+		log.Error().
+			Err(err).
+			AnErr("cause", cause).
+			Msg("Failed to dead-letter spooled message, keeping it in the spool")
+		return false
+	}
+	log.Warn().
+		Str("topic", letter.Topic).
+		Err(cause).
+		Msg("Routed spooled message rejected by the broker to dead letter sink")
+	return true
+}
+
+// publish publishes a spooled record through the wrapped publisher
+func (p *Publisher) publish(ctx context.Context, data []byte) error {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+
+	switch rec.Kind {
+	case kindEntity:
+		e, err := entity.UnmarshalFromEvent(rec.Data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errCorrupt, err)
+		}
+		return p.inner.PublishEntity(ctx, e, rec.Topic)
+	case kindRaw:
+		return p.inner.PublishRaw(ctx, rec.Key, rec.Data, rec.Topic)
+	default:
+		return fmt.Errorf("%w: unknown kind %q", errCorrupt, rec.Kind)
+	}
+}
+
+// Close stops draining and closes the spool and the wrapped publisher.
+// Messages still in the spool are drained on the next start.
+func (p *Publisher) Close() error {
+	p.cancel()
+	<-p.done
+
+	p.mu.Lock()
+	err := p.log.Close()
+	p.mu.Unlock()
+	if err != nil {
+		log.Error().Err(err).Msg("Error closing spool")
+	}
+
+	return p.inner.Close()
+}
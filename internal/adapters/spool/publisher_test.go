@@ -0,0 +1,170 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+var (
+	errUnavailable = errors.New("broker unavailable")
+	errRejected    = errors.New("message too large")
+)
+
+// switchingPublisher fails raw publishes with err until it is changed
+type switchingPublisher struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (p *switchingPublisher) PublishEntity(ctx context.Context, e *entity.Entity, topic string) error {
+	return p.PublishRaw(ctx, e.ID, nil, topic)
+}
+
+func (p *switchingPublisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *switchingPublisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	return p.PublishRaw(ctx, "", nil, topic)
+}
+
+func (p *switchingPublisher) Close() error {
+	return nil
+}
+
+func (p *switchingPublisher) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// memoryDeadLetters keeps dead letters in memory and fails sends with err
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	letters []*entity.DeadLetter
+	err     error
+}
+
+func (s *memoryDeadLetters) Send(ctx context.Context, letter *entity.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func (s *memoryDeadLetters) Replay(ctx context.Context, publish func(ctx context.Context, letter *entity.DeadLetter) error) (int, error) {
+	return 0, nil
+}
+
+func (s *memoryDeadLetters) Close() error {
+	return nil
+}
+
+func (s *memoryDeadLetters) received() []*entity.DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*entity.DeadLetter(nil), s.letters...)
+}
+
+func (s *memoryDeadLetters) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// spoolRejected spools a message while the broker is unavailable and makes the
+// broker reject it from then on
+func spoolRejected(t *testing.T, inner *switchingPublisher, deadLetters *memoryDeadLetters) *Publisher {
+	t.Helper()
+
+	config := PublisherConfig{
+		Dir:           t.TempDir(),
+		DrainInterval: 5 * time.Millisecond,
+		Unavailable:   func(err error) bool { return errors.Is(err, errUnavailable) },
+	}
+	if deadLetters != nil {
+		config.DeadLetters = deadLetters
+	}
+	publisher, err := NewPublisher(inner, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Close() })
+
+	inner.fail(errUnavailable)
+	if err := publisher.PublishRaw(context.Background(), "key", []byte("payload"), "topic"); err != nil {
+		t.Fatal(err)
+	}
+	if !publisher.Spooling() {
+		t.Fatal("expected the message to be spooled")
+	}
+	inner.fail(errRejected)
+	return publisher
+}
+
+// waitDrained waits for the spool to be empty
+func waitDrained(t *testing.T, publisher *Publisher) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for publisher.Spooling() {
+		if time.Now().After(deadline) {
+			t.Fatal("spool was not drained")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDrainDeadLettersRejectedMessages(t *testing.T) {
+	deadLetters := &memoryDeadLetters{}
+	publisher := spoolRejected(t, &switchingPublisher{}, deadLetters)
+	waitDrained(t, publisher)
+
+	letters := deadLetters.received()
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+	letter := letters[0]
+	if letter.Topic != "topic" || letter.Key != "key" || string(letter.Payload) != "payload" {
+		t.Fatalf("unexpected dead letter %+v", letter)
+	}
+	if letter.Error != errRejected.Error() || letter.Headers["kind"] != kindRaw {
+		t.Fatalf("unexpected dead letter error %q and headers %v", letter.Error, letter.Headers)
+	}
+}
+
+func TestDrainKeepsMessagesTheDeadLetterSinkRefuses(t *testing.T) {
+	deadLetters := &memoryDeadLetters{err: errors.New("disk full")}
+	publisher := spoolRejected(t, &switchingPublisher{}, deadLetters)
+
+	// The message stays spooled while the sink fails
+	time.Sleep(50 * time.Millisecond)
+	if !publisher.Spooling() || publisher.Used() == 0 {
+		t.Fatal("expected the message to stay in the spool")
+	}
+
+	deadLetters.fail(nil)
+	waitDrained(t, publisher)
+	if letters := deadLetters.received(); len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+}
+
+func TestDrainDropsRejectedMessagesWithoutDeadLetterSink(t *testing.T) {
+	publisher := spoolRejected(t, &switchingPublisher{}, nil)
+	waitDrained(t, publisher)
+
+	if used := publisher.Used(); used != 0 {
+		t.Fatalf("expected an empty spool, got %d bytes", used)
+	}
+}
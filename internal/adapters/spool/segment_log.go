@@ -0,0 +1,432 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrFull is returned when appending would exceed the spool's disk limit
+var ErrFull = errors.New("spool is full")
+
+// frameHeaderSize is the size of the length and checksum preceding each record
+const frameHeaderSize = 8
+
+const (
+	segmentSuffix  = ".seg"
+	checkpointFile = "checkpoint.json"
+)
+
+// position identifies a record boundary in the log
+type position struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// segmentLog is an append-only log split into numbered segment files. Records
+// are read back in append order from a persisted checkpoint, and segments are
+// deleted once fully read. It is not safe for concurrent use.
+type segmentLog struct {
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+
+	segments  []int64 // segment IDs in order, the last one is written to
+	writer    *os.File
+	writeSize int64
+	read      position
+	used      int64
+}
+
+// openSegmentLog opens or creates a segment log in dir
+func openSegmentLog(dir string, segmentBytes, maxBytes int64) (*segmentLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+
+	l := &segmentLog{
+		dir:          dir,
+		segmentBytes: segmentBytes,
+		maxBytes:     maxBytes,
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, id)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+
+	if err := l.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	// Drop segments that were fully read before the last shutdown
+	for len(l.segments) > 0 && l.segments[0] < l.read.Segment {
+		if err := os.Remove(l.segmentPath(l.segments[0])); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error removing drained spool segment: %w", err)
+		}
+		l.segments = l.segments[1:]
+	}
+	if len(l.segments) > 0 && l.read.Segment < l.segments[0] {
+		l.read = position{Segment: l.segments[0]}
+	}
+
+	if len(l.segments) > 0 {
+		// Cut off a record torn by a crash so new records are appended after valid data
+		last := l.segments[len(l.segments)-1]
+		size, err := l.validSize(last)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Truncate(l.segmentPath(last), size); err != nil {
+			return nil, fmt.Errorf("error truncating spool segment: %w", err)
+		}
+	}
+
+	for _, id := range l.segments {
+		info, err := os.Stat(l.segmentPath(id))
+		if err != nil {
+			return nil, fmt.Errorf("error reading spool segment: %w", err)
+		}
+		l.used += info.Size()
+	}
+
+	return l, nil
+}
+
+// segmentPath returns the file path of a segment
+func (l *segmentLog) segmentPath(id int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+// loadCheckpoint restores the read position
+func (l *segmentLog) loadCheckpoint() error {
+	data, err := os.ReadFile(filepath.Join(l.dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading spool checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.read); err != nil {
+		return fmt.Errorf("error parsing spool checkpoint: %w", err)
+	}
+	return nil
+}
+
+// saveCheckpoint atomically persists the read position
+func (l *segmentLog) saveCheckpoint() error {
+	data, err := json.Marshal(l.read)
+	if err != nil {
+		return fmt.Errorf("error marshaling spool checkpoint: %w", err)
+	}
+
+	path := filepath.Join(l.dir, checkpointFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing spool checkpoint: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error renaming spool checkpoint: %w", err)
+	}
+	return nil
+}
+
+// validSize returns the length of the valid prefix of a segment
+func (l *segmentLog) validSize(id int64) (int64, error) {
+	file, err := os.Open(l.segmentPath(id))
+	if err != nil {
+		return 0, fmt.Errorf("error opening spool segment: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("error reading spool segment size: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		record, err := readFrame(reader, l.frameLimit(info.Size()-size))
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Warn().
+					Str("segment", l.segmentPath(id)).
+					Int64("offset", size).
+					Err(err).
+					Msg("Truncating torn spool segment")
+			}
+			return size, nil
+		}
+		size += int64(frameHeaderSize + len(record))
+	}
+}
+
+// Append appends records to the log and syncs them to disk
+func (l *segmentLog) Append(records ...[]byte) error {
+	var size int64
+	for _, record := range records {
+		size += int64(frameHeaderSize + len(record))
+	}
+	if l.used+size > l.maxBytes {
+		return ErrFull
+	}
+
+	for _, record := range records {
+		if l.writer == nil || (l.writeSize > 0 && l.writeSize+int64(frameHeaderSize+len(record)) > l.segmentBytes) {
+			if err := l.rotate(); err != nil {
+				return err
+			}
+		}
+
+		frame := make([]byte, frameHeaderSize+len(record))
+		binary.BigEndian.PutUint32(frame[0:4], uint32(len(record)))
+		binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(record))
+		copy(frame[frameHeaderSize:], record)
+
+		if _, err := l.writer.Write(frame); err != nil {
+			return fmt.Errorf("error writing to spool: %w", err)
+		}
+		l.writeSize += int64(len(frame))
+		l.used += int64(len(frame))
+	}
+
+	if err := l.writer.Sync(); err != nil {
+		return fmt.Errorf("error syncing spool: %w", err)
+	}
+	return nil
+}
+
+// rotate closes the current segment and starts writing the next one. After a
+// restart the last existing segment is reopened for appending first.
+func (l *segmentLog) rotate() error {
+	if l.writer == nil && len(l.segments) > 0 {
+		last := l.segments[len(l.segments)-1]
+		if info, err := os.Stat(l.segmentPath(last)); err == nil && info.Size() < l.segmentBytes {
+			return l.openWriter(last, info.Size())
+		}
+	}
+
+	if l.writer != nil {
+		if err := l.writer.Close(); err != nil {
+			return fmt.Errorf("error closing spool segment: %w", err)
+		}
+		l.writer = nil
+	}
+
+	id := int64(1)
+	if len(l.segments) > 0 {
+		id = l.segments[len(l.segments)-1] + 1
+	}
+	if len(l.segments) == 0 && l.read.Segment > id {
+		id = l.read.Segment
+	}
+	l.segments = append(l.segments, id)
+	if len(l.segments) == 1 {
+		l.read = position{Segment: id}
+	}
+	return l.openWriter(id, 0)
+}
+
+// openWriter opens a segment for appending
+func (l *segmentLog) openWriter(id, size int64) error {
+	file, err := os.OpenFile(l.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening spool segment: %w", err)
+	}
+	l.writer = file
+	l.writeSize = size
+	return nil
+}
+
+// Read returns up to max records from the read position along with the
+// position following each record. It returns no records once caught up.
+func (l *segmentLog) Read(max int) ([][]byte, []position, error) {
+	var records [][]byte
+	var positions []position
+
+	pos := l.read
+	for len(records) < max {
+		index := sort.Search(len(l.segments), func(i int) bool { return l.segments[i] >= pos.Segment })
+		if index == len(l.segments) {
+			break
+		}
+		if l.segments[index] != pos.Segment {
+			pos = position{Segment: l.segments[index]}
+		}
+
+		read, ends, err := l.readSegment(pos, max-len(records))
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, read...)
+		positions = append(positions, ends...)
+
+		if len(records) >= max || index == len(l.segments)-1 {
+			break
+		}
+
+		// The segment is exhausted, continue with the next one
+		pos = position{Segment: l.segments[index+1]}
+		if len(positions) > 0 {
+			positions[len(positions)-1] = pos
+		} else {
+			l.read = pos
+		}
+	}
+
+	return records, positions, nil
+}
+
+// readSegment reads up to max records of a segment starting at pos
+func (l *segmentLog) readSegment(pos position, max int) ([][]byte, []position, error) {
+	file, err := os.Open(l.segmentPath(pos.Segment))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening spool segment: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(pos.Offset, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("error seeking spool segment: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading spool segment size: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	var records [][]byte
+	var positions []position
+	offset := pos.Offset
+	for len(records) < max {
+		record, err := readFrame(reader, l.frameLimit(info.Size()-offset))
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Error().
+					Str("segment", l.segmentPath(pos.Segment)).
+					Int64("offset", offset).
+					Err(err).
+					Msg("Skipping corrupt spool segment tail")
+			}
+			break
+		}
+		offset += int64(frameHeaderSize + len(record))
+		records = append(records, record)
+		positions = append(positions, position{Segment: pos.Segment, Offset: offset})
+	}
+
+	return records, positions, nil
+}
+
+// Commit advances the read position, deleting segments that were fully read
+func (l *segmentLog) Commit(pos position) error {
+	l.read = pos
+
+	// Remove every segment before the read position, never the one being written
+	for len(l.segments) > 1 && l.segments[0] < pos.Segment {
+		path := l.segmentPath(l.segments[0])
+		if info, err := os.Stat(path); err == nil {
+			l.used -= info.Size()
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing drained spool segment: %w", err)
+		}
+		l.segments = l.segments[1:]
+	}
+
+	return l.saveCheckpoint()
+}
+
+// Reset truncates a fully read log so disk space is reclaimed
+func (l *segmentLog) Reset() error {
+	if l.writer != nil {
+		if err := l.writer.Close(); err != nil {
+			return fmt.Errorf("error closing spool segment: %w", err)
+		}
+		l.writer = nil
+	}
+
+	next := l.read.Segment + 1
+	for _, id := range l.segments {
+		if err := os.Remove(l.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing drained spool segment: %w", err)
+		}
+		if id >= next {
+			next = id + 1
+		}
+	}
+	l.segments = nil
+	l.used = 0
+	l.read = position{Segment: next}
+	return l.saveCheckpoint()
+}
+
+// Used returns the disk space used by the log in bytes
+func (l *segmentLog) Used() int64 {
+	return l.used
+}
+
+// Close closes the log
+func (l *segmentLog) Close() error {
+	if l.writer == nil {
+		return nil
+	}
+	err := l.writer.Close()
+	l.writer = nil
+	return err
+}
+
+// frameLimit returns the largest frame that can start with left bytes left
+// in its segment: no frame is larger than the spool itself
+func (l *segmentLog) frameLimit(left int64) int64 {
+	return min(left, l.maxBytes)
+}
+
+// readFrame reads one length-prefixed, checksummed record of at most limit
+// bytes, header included. A larger length can only come from a torn or
+// corrupt header, so it is rejected before the record is allocated.
+func readFrame(reader *bufio.Reader, limit int64) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated record header: %w", err)
+		}
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length) > limit-frameHeaderSize {
+		return nil, fmt.Errorf("record length %d exceeds the %d bytes left", length, max(limit-frameHeaderSize, 0))
+	}
+	record := make([]byte, length)
+	if _, err := io.ReadFull(reader, record); err != nil {
+		return nil, fmt.Errorf("truncated record: %w", err)
+	}
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("record checksum mismatch")
+	}
+	return record, nil
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Config holds the configuration for tracing
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector, either host:port or a URL
+	// such as http://localhost:4318. Tracing is disabled when empty.
+	Endpoint string
+
+	// Insecure disables TLS when Endpoint is given as host:port
+	Insecure bool
+
+	ServiceName    string
+	ServiceVersion string
+
+	// SampleRatio is the share of runs traced (default 1, every run)
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider exporting spans over OTLP/HTTP and
+// the W3C trace context propagator. The returned function flushes and stops the
+// exporter. When no endpoint is configured the propagator is still installed and
+// spans are not recorded.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	// Set defaults for configuration
+	if config.ServiceName == "" {
+		config.ServiceName = "thegraph-extraction"
+	}
+	if config.SampleRatio <= 0 {
+		config.SampleRatio = 1
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if config.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlptracehttp.Option
+	if strings.Contains(config.Endpoint, "://") {
+		options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+	} else {
+		options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+	}
+
+	attributes := []resource.Option{
+		resource.WithAttributes(semconv.ServiceName(config.ServiceName)),
+		resource.WithHost(),
+		resource.WithProcessPID(),
+	}
+	if config.ServiceVersion != "" {
+		attributes = append(attributes, resource.WithAttributes(semconv.ServiceVersion(config.ServiceVersion)))
+	}
+	res, err := resource.New(ctx, attributes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(5*time.Second)),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	log.Info().
+		Str("endpoint", config.Endpoint).
+		Str("service", config.ServiceName).
+		Float64("sampleRatio", config.SampleRatio).
+		Msg("Exporting traces over OTLP")
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusError is returned when a destination answers with a non-2xx status
+type StatusError struct {
+	Destination string
+	StatusCode  int
+	Body        string
+	RetryAfter  time.Duration
+}
+
+// Error describes the rejected delivery
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook %s responded with %d: %s", e.Destination, e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the destination may accept the delivery later
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500
+}
+
+// retryable reports whether a failed request is worth retrying
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// IsUnavailable reports whether a delivery error means the destination could
+// not take the delivery right now, as opposed to rejecting it.
+// Batch errors are unavailable only if every failure is.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !IsUnavailable(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,451 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// Headers set on every delivery
+const (
+	HeaderDelivery  = "X-Thegraph-Delivery"
+	HeaderTimestamp = "X-Thegraph-Timestamp"
+	HeaderSignature = "X-Thegraph-Signature"
+	HeaderTopic     = "X-Thegraph-Topic"
+)
+
+// Destination is an HTTP endpoint receiving entity batches
+type Destination struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Secret signs deliveries with HMAC-SHA256; deliveries are unsigned when empty
+	Secret string `json:"secret"`
+
+	// QueryTypes limits the entities delivered to these query types. Destinations
+	// without a filter receive every entity as well as raw payloads.
+	QueryTypes []string `json:"query_types"`
+
+	// Concurrency caps the number of requests in flight to this destination
+	Concurrency int `json:"concurrency"`
+
+	// Headers are added to every request, e.g. for authentication
+	Headers map[string]string `json:"headers"`
+}
+
+// Delivery is the JSON body posted to destinations
+type Delivery struct {
+	ID       string            `json:"id"`
+	Producer string            `json:"producer"`
+	Topic    string            `json:"topic"`
+	SentAt   time.Time         `json:"sent_at"`
+	Entities []json.RawMessage `json:"entities,omitempty"`
+	Key      string            `json:"key,omitempty"`
+	Raw      json.RawMessage   `json:"raw,omitempty"`
+}
+
+// destination is a configured destination with its concurrency limit
+type destination struct {
+	Destination
+	queryTypes map[string]bool
+	slots      chan struct{}
+}
+
+// accepts reports whether the destination receives entities of a query type
+func (d *destination) accepts(queryType string) bool {
+	return len(d.queryTypes) == 0 || d.queryTypes[queryType]
+}
+
+// Publisher is an adapter that implements the ports.EventPublisher interface by
+// POSTing batches of entities to HTTP destinations
+type Publisher struct {
+	destinations  []*destination
+	client        *http.Client
+	producer      string
+	maxBatchSize  int
+	maxRetries    int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+// PublisherConfig holds the configuration for the webhook publisher
+type PublisherConfig struct {
+	Destinations []Destination
+	Producer     string
+
+	// Client sends the requests; a client with Timeout is created when nil
+	Client  *http.Client
+	Timeout time.Duration
+
+	// MaxBatchSize splits larger batches into several requests
+	MaxBatchSize int
+
+	// MaxRetries is the number of retries after a failed request; 0 disables
+	// retries and a negative value selects the default of 3. Network errors,
+	// 429 and 5xx responses are retried with exponential backoff starting at
+	// RetryDelay and capped at MaxRetryDelay.
+	MaxRetries    int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// LoadDestinations reads webhook destinations from a JSON file
+func LoadDestinations(path string) ([]Destination, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading webhook config: %w", err)
+	}
+
+	var destinations []Destination
+	if err := json.Unmarshal(data, &destinations); err != nil {
+		return nil, fmt.Errorf("error parsing webhook config %s: %w", path, err)
+	}
+	return destinations, nil
+}
+
+// NewPublisher creates a new webhook publisher
+func NewPublisher(config PublisherConfig) (*Publisher, error) {
+	// Set defaults for configuration
+	if config.Producer == "" {
+		config.Producer = "thegraph-extraction"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: config.Timeout}
+	}
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = 100
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 3
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 500 * time.Millisecond
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = 30 * time.Second
+	}
+
+	if len(config.Destinations) == 0 {
+		return nil, fmt.Errorf("no webhook destinations configured")
+	}
+
+	destinations := make([]*destination, 0, len(config.Destinations))
+	for i, d := range config.Destinations {
+		parsed, err := url.Parse(d.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid url %q for webhook destination %d", d.URL, i)
+		}
+		if d.Name == "" {
+			d.Name = parsed.Host
+		}
+		if d.Concurrency <= 0 {
+			d.Concurrency = 4
+		}
+
+		queryTypes := make(map[string]bool, len(d.QueryTypes))
+		for _, queryType := range d.QueryTypes {
+			queryTypes[queryType] = true
+		}
+
+		destinations = append(destinations, &destination{
+			Destination: d,
+			queryTypes:  queryTypes,
+			slots:       make(chan struct{}, d.Concurrency),
+		})
+	}
+
+	return &Publisher{
+		destinations:  destinations,
+		client:        config.Client,
+		producer:      config.Producer,
+		maxBatchSize:  config.MaxBatchSize,
+		maxRetries:    config.MaxRetries,
+		retryDelay:    config.RetryDelay,
+		maxRetryDelay: config.MaxRetryDelay,
+	}, nil
+}
+
+// EnsureTopics has nothing to provision for HTTP destinations
+func (p *Publisher) EnsureTopics(ctx context.Context, topics []string) error {
+	return nil
+}
+
+// Ping always succeeds; destinations are only reached when delivering
+func (p *Publisher) Ping(ctx context.Context) error {
+	return nil
+}
+
+// PublishEntity publishes an entity to the destinations accepting its query type
+func (p *Publisher) PublishEntity(ctx context.Context, e *entity.Entity, topic string) error {
+	err := p.PublishBatch(ctx, []*entity.Entity{e}, topic)
+	if batchErr, ok := err.(*ports.BatchError); ok {
+		return batchErr.Errors[0]
+	}
+	return err
+}
+
+// PublishRaw publishes raw data to the destinations without a query type filter
+func (p *Publisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	raw := json.RawMessage(data)
+	if !json.Valid(data) {
+		// Carry non-JSON payloads as a JSON string
+		quoted, err := json.Marshal(string(data))
+		if err != nil {
+			return fmt.Errorf("error marshaling raw payload: %w", err)
+		}
+		raw = quoted
+	}
+
+	delivery := Delivery{Topic: topic, Key: key, Raw: raw}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(p.destinations))
+	for i, d := range p.destinations {
+		if len(d.queryTypes) > 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, d *destination) {
+			defer wg.Done()
+			errs[i] = p.deliver(ctx, d, delivery)
+		}(i, d)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishBatch posts the entities to every destination accepting their query
+// type, split into requests of at most MaxBatchSize entities. An entity fails
+// if any destination it was sent to did not accept it.
+func (p *Publisher) PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	batchErr := &ports.BatchError{Errors: make([]error, len(entities))}
+	payloads := make([]json.RawMessage, len(entities))
+	for i, e := range entities {
+		data, err := e.MarshalForEvent()
+		if err != nil {
+			batchErr.Errors[i] = fmt.Errorf("error marshaling entity: %w", err)
+			continue
+		}
+		payloads[i] = data
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, d := range p.destinations {
+		// Select the entities this destination accepts
+		var indexes []int
+		for i, e := range entities {
+			if payloads[i] != nil && d.accepts(e.Type) {
+				indexes = append(indexes, i)
+			}
+		}
+
+		for start := 0; start < len(indexes); start += p.maxBatchSize {
+			end := start + p.maxBatchSize
+			if end > len(indexes) {
+				end = len(indexes)
+			}
+			chunk := indexes[start:end]
+
+			delivery := Delivery{Topic: topic, Entities: make([]json.RawMessage, len(chunk))}
+			for j, i := range chunk {
+				delivery.Entities[j] = payloads[i]
+			}
+
+			wg.Add(1)
+			go func(d *destination, chunk []int, delivery Delivery) {
+				defer wg.Done()
+				if err := p.deliver(ctx, d, delivery); err != nil {
+					mu.Lock()
+					for _, i := range chunk {
+						if batchErr.Errors[i] == nil {
+							batchErr.Errors[i] = err
+						}
+					}
+					mu.Unlock()
+				}
+			}(d, chunk, delivery)
+		}
+	}
+	wg.Wait()
+
+	if failed := batchErr.Failed(); len(failed) > 0 {
+		log.Error().
+			Str("topic", topic).
+			Int("failed", len(failed)).
+			Int("total", len(entities)).
+			Err(batchErr.Errors[failed[0]]).
+			Msg("Failed to deliver batch to webhooks")
+		return batchErr
+	}
+
+	return nil
+}
+
+// deliver posts a delivery to a destination, retrying transient failures
+func (p *Publisher) deliver(ctx context.Context, d *destination, delivery Delivery) error {
+	// Wait for a free slot of the destination
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-d.slots }()
+
+	// The delivery ID stays the same across retries so receivers can deduplicate
+	delivery.ID = uuid.New().String()
+	delivery.Producer = p.producer
+
+	var lastErr error
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := p.backoff(attempt, lastErr)
+			log.Warn().
+				Str("destination", d.Name).
+				Str("delivery", delivery.ID).
+				Int("attempt", attempt).
+				Dur("delay", delay).
+				Err(lastErr).
+				Msg("Retrying webhook delivery")
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return fmt.Errorf("webhook delivery to %s cancelled: %w", d.Name, ctx.Err())
+			}
+		}
+
+		lastErr = p.send(ctx, d, delivery)
+		if lastErr == nil {
+			log.Debug().
+				Str("destination", d.Name).
+				Str("delivery", delivery.ID).
+				Str("topic", delivery.Topic).
+				Int("entities", len(delivery.Entities)).
+				Msg("Delivered webhook")
+			return nil
+		}
+		if !retryable(lastErr) {
+			break
+		}
+	}
+
+	return lastErr
+}
+
+// send performs a single signed request
+func (p *Publisher) send(ctx context.Context, d *destination, delivery Delivery) error {
+	delivery.SentAt = time.Now().UTC()
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook delivery: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(delivery.SentAt.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", p.producer)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderTopic, delivery.Topic)
+	if d.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, body))
+	}
+	for key, value := range d.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request to %s failed: %w", d.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &StatusError{
+		Destination: d.Name,
+		StatusCode:  resp.StatusCode,
+		Body:        string(message),
+		RetryAfter:  retryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// backoff returns the delay before a retry: exponential with jitter, or the
+// destination's Retry-After when it asked for one
+func (p *Publisher) backoff(attempt int, lastErr error) time.Duration {
+	if statusErr, ok := lastErr.(*StatusError); ok && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > p.maxRetryDelay {
+			return p.maxRetryDelay
+		}
+		return statusErr.RetryAfter
+	}
+
+	delay := p.retryDelay << (attempt - 1)
+	if delay <= 0 || delay > p.maxRetryDelay {
+		delay = p.maxRetryDelay
+	}
+	// Add up to 20% jitter so destinations are not hit in lockstep
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// Close releases idle connections
+func (p *Publisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+// Sign returns the signature header value of a delivery: the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the destination secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery signature in constant time; receivers can use it
+// to authenticate requests
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,281 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// receiver records the deliveries posted to it
+type receiver struct {
+	mu         sync.Mutex
+	deliveries []Delivery
+	bodies     [][]byte
+	headers    []http.Header
+}
+
+// record stores a request and decodes its delivery
+func (r *receiver) record(t *testing.T, req *http.Request) Delivery {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	var delivery Delivery
+	if err := json.Unmarshal(body, &delivery); err != nil {
+		t.Error(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries = append(r.deliveries, delivery)
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	return delivery
+}
+
+// entityIDs returns the IDs of the entities delivered so far, sorted
+func (r *receiver) entityIDs(t *testing.T) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for _, delivery := range r.deliveries {
+		for _, data := range delivery.Entities {
+			var e struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(data, &e); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, e.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// newReceiver starts a receiver answering every request with handle
+func newReceiver(t *testing.T, handle func(w http.ResponseWriter, delivery Delivery)) (*receiver, *httptest.Server) {
+	r := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		delivery := r.record(t, req)
+		if handle != nil {
+			handle(w, delivery)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+// newTestPublisher creates a publisher with short retry delays
+func newTestPublisher(t *testing.T, maxRetries int, destinations ...Destination) *Publisher {
+	publisher, err := NewPublisher(PublisherConfig{
+		Destinations:  destinations,
+		MaxRetries:    maxRetries,
+		RetryDelay:    time.Millisecond,
+		MaxRetryDelay: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Close() })
+	return publisher
+}
+
+// testEntity returns an entity of a query type
+func testEntity(id, queryType string) *entity.Entity {
+	return &entity.Entity{
+		ID:        id,
+		Type:      queryType,
+		Timestamp: time.Unix(1700000000, 0),
+		Data:      map[string]interface{}{"id": id},
+	}
+}
+
+func TestSignatureHeader(t *testing.T) {
+	signed, signedServer := newReceiver(t, nil)
+	unsigned, unsignedServer := newReceiver(t, nil)
+	publisher := newTestPublisher(t, 0,
+		Destination{URL: signedServer.URL, Secret: "s3cret"},
+		Destination{URL: unsignedServer.URL},
+	)
+
+	if err := publisher.PublishEntity(context.Background(), testEntity("0x01", "swaps"), "thegraph_swaps"); err != nil {
+		t.Fatal(err)
+	}
+
+	header, body := signed.headers[0], signed.bodies[0]
+	timestamp := header.Get(HeaderTimestamp)
+	if !Verify("s3cret", timestamp, body, header.Get(HeaderSignature)) {
+		t.Errorf("signature %q does not verify", header.Get(HeaderSignature))
+	}
+	if Verify("other", timestamp, body, header.Get(HeaderSignature)) {
+		t.Error("signature verifies with another secret")
+	}
+	if header.Get(HeaderDelivery) != signed.deliveries[0].ID || header.Get(HeaderTopic) != "thegraph_swaps" {
+		t.Errorf("delivery headers = %v", header)
+	}
+
+	if signature := unsigned.headers[0].Get(HeaderSignature); signature != "" {
+		t.Errorf("delivery without a secret is signed with %q", signature)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		// statuses are answered in turn, then 200
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "recovers after 5xx", maxRetries: 3, statuses: []int{500, 503}, wantAttempts: 3},
+		{name: "recovers after 429", maxRetries: 3, statuses: []int{429}, wantAttempts: 2},
+		{name: "gives up after retries", maxRetries: 2, statuses: []int{502, 502, 502, 502}, wantAttempts: 3, wantErr: true},
+		{name: "does not retry 4xx", maxRetries: 3, statuses: []int{400}, wantAttempts: 1, wantErr: true},
+		{name: "retries disabled", maxRetries: 0, statuses: []int{503}, wantAttempts: 1, wantErr: true},
+		{name: "negative uses the default", maxRetries: -1, statuses: []int{503, 503, 503}, wantAttempts: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			r, server := newReceiver(t, func(w http.ResponseWriter, _ Delivery) {
+				if attempt := int(attempts.Add(1)); attempt <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[attempt-1])
+				}
+			})
+			publisher := newTestPublisher(t, tt.maxRetries, Destination{URL: server.URL})
+
+			err := publisher.PublishEntity(context.Background(), testEntity("0x01", "swaps"), "thegraph_swaps")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PublishEntity error = %v, want error %v", err, tt.wantErr)
+			}
+			var statusErr *StatusError
+			if tt.wantErr && !errors.As(err, &statusErr) {
+				t.Errorf("PublishEntity error = %v, want a StatusError", err)
+			}
+			if got := int(attempts.Load()); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+
+			// Retries carry the same delivery ID so receivers can deduplicate
+			for _, delivery := range r.deliveries {
+				if delivery.ID != r.deliveries[0].ID {
+					t.Errorf("delivery ID changed from %s to %s", r.deliveries[0].ID, delivery.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryTypeFiltering(t *testing.T) {
+	swaps, swapsServer := newReceiver(t, nil)
+	all, allServer := newReceiver(t, nil)
+	publisher := newTestPublisher(t, 0,
+		Destination{URL: swapsServer.URL, QueryTypes: []string{"swaps"}},
+		Destination{URL: allServer.URL},
+	)
+	ctx := context.Background()
+
+	entities := []*entity.Entity{
+		testEntity("0x01", "swaps"),
+		testEntity("0x02", "mints"),
+		testEntity("0x03", "swaps"),
+	}
+	if err := publisher.PublishBatch(ctx, entities, "thegraph"); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.PublishRaw(ctx, "key", []byte(`{"summary":true}`), "thegraph_runs"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := swaps.entityIDs(t); len(got) != 2 || got[0] != "0x01" || got[1] != "0x03" {
+		t.Errorf("filtered destination received %v, want [0x01 0x03]", got)
+	}
+	if got := all.entityIDs(t); len(got) != 3 {
+		t.Errorf("unfiltered destination received %v, want every entity", got)
+	}
+
+	// Raw payloads only go to destinations without a filter
+	if len(swaps.deliveries) != 1 {
+		t.Errorf("filtered destination received %d deliveries, want 1", len(swaps.deliveries))
+	}
+	if len(all.deliveries) != 2 || string(all.deliveries[1].Raw) != `{"summary":true}` {
+		t.Errorf("unfiltered destination did not receive the raw payload: %+v", all.deliveries)
+	}
+}
+
+func TestConcurrencyCap(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	r, server := newReceiver(t, func(w http.ResponseWriter, _ Delivery) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			peak := maxInFlight.Load()
+			if n <= peak || maxInFlight.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	publisher, err := NewPublisher(PublisherConfig{
+		Destinations: []Destination{{URL: server.URL, Concurrency: 2}},
+		MaxBatchSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	entities := make([]*entity.Entity, 10)
+	for i := range entities {
+		entities[i] = testEntity(string(rune('a'+i)), "swaps")
+	}
+	if err := publisher.PublishBatch(context.Background(), entities, "thegraph_swaps"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.deliveries) != len(entities) {
+		t.Errorf("received %d deliveries, want %d", len(r.deliveries), len(entities))
+	}
+	if peak := maxInFlight.Load(); peak != 2 {
+		t.Errorf("at most %d requests were in flight, want 2", peak)
+	}
+}
+
+func TestPublishBatchReportsFailedEntities(t *testing.T) {
+	_, okServer := newReceiver(t, nil)
+	_, failingServer := newReceiver(t, func(w http.ResponseWriter, _ Delivery) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	publisher := newTestPublisher(t, 0,
+		Destination{URL: okServer.URL},
+		Destination{URL: failingServer.URL, QueryTypes: []string{"mints"}},
+	)
+
+	entities := []*entity.Entity{testEntity("0x01", "swaps"), testEntity("0x02", "mints")}
+	err := publisher.PublishBatch(context.Background(), entities, "thegraph")
+
+	// Only the entity sent to the failing destination fails
+	var batchErr *ports.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("PublishBatch error = %v, want a BatchError", err)
+	}
+	if failed := batchErr.Failed(); len(failed) != 1 || failed[0] != 1 {
+		t.Errorf("failed entities = %v, want [1]", failed)
+	}
+}
@@ -1,19 +1,38 @@
 package worker
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// Rejection policies, applied when a task is submitted to a full queue
+const (
+	// RejectBlock makes the submitter wait for room in the queue
+	RejectBlock = "block"
+	// RejectDrop rejects the task with ports.ErrQueueFull
+	RejectDrop = "drop"
+	// RejectCallerRuns runs the task on the submitter's goroutine
+	RejectCallerRuns = "caller-runs"
 )
 
-// DynamicPool implements a worker pool that can adapt its size based on performance metrics
+// DynamicPool implements a worker pool that can adapt its size based on performance metrics.
+// Tasks belong to classes sharing the workers by weight; see fairQueue.
 type DynamicPool struct {
 	workers       map[int]*worker
-	tasks         chan func() error
-	wg            sync.WaitGroup
+	stopping      map[int]*worker
+	queue         *fairQueue
+	tasks         chan *queuedTask
+	free          chan struct{}
+	done          chan struct{}
 	mu            sync.Mutex
 	minWorkers    int
 	maxWorkers    int
@@ -23,15 +42,39 @@ type DynamicPool struct {
 	closed        int32
 	taskLatencies []time.Duration
 	latencyMu     sync.Mutex
-	errorRate     float64
 	totalTasks    int64
 	successTasks  int64
+	rejection     string
+	manualSize    bool
+	taskTimeout   time.Duration
+
+	// queueDepthAverage smooths the queue depth seen by the scaling
+	// logic; it is updated with the lock held
+	queueDepthAverage float64
+
+	// blocked counts the submitters waiting for room in the queue; the
+	// other counters count submissions by outcome of a full queue
+	blocked    int32
+	rejected   int64
+	callerRuns int64
+	cancelled  int64
+
+	// panics counts the tasks that panicked and timeouts the tasks that
+	// failed after their timeout
+	panics   int64
+	timeouts int64
+
+	// pending counts the tasks submitted and not finished yet
+	pendingMu   sync.Mutex
+	pendingCond *sync.Cond
+	pending     int
 }
 
 // worker represents a single worker goroutine
 type worker struct {
 	id         int
-	tasks      <-chan func() error
+	tasks      <-chan *queuedTask
+	free       chan<- struct{}
 	idle       time.Time
 	idleTime   time.Duration
 	processing atomic.Bool
@@ -46,6 +89,24 @@ type PoolConfig struct {
 	IdleTimeout    time.Duration
 	AdjustPeriod   time.Duration
 	QueueSize      int
+
+	// Classes sets the weight and max in flight of task classes by name;
+	// DefaultClass applies to the classes not listed
+	Classes      map[string]ClassConfig
+	DefaultClass ClassConfig
+
+	// Rejection is what a submission to a full queue does: RejectBlock
+	// (default), RejectDrop or RejectCallerRuns
+	Rejection string
+
+	// ManualSize turns the pool's own scaling off, leaving its size to
+	// SetPoolSize, for a controller that sizes the pool from outside
+	ManualSize bool
+
+	// TaskTimeout bounds the context of the tasks of a group; zero leaves
+	// them without a deadline. Tasks submitted without a context cannot be
+	// bounded.
+	TaskTimeout time.Duration
 }
 
 // NewDynamicPool creates a new dynamic worker pool
@@ -69,6 +130,9 @@ func NewDynamicPool(config PoolConfig) *DynamicPool {
 	if config.AdjustPeriod <= 0 {
 		config.AdjustPeriod = 5 * time.Second
 	}
+	if config.Rejection == "" {
+		config.Rejection = RejectBlock
+	}
 	
 	// Ensure consistent configuration
 	if config.MinWorkers > config.InitialWorkers {
@@ -81,18 +145,31 @@ func NewDynamicPool(config PoolConfig) *DynamicPool {
 	// Create pool
 	pool := &DynamicPool{
 		workers:      make(map[int]*worker),
-		tasks:        make(chan func() error, config.QueueSize),
+		stopping:     make(map[int]*worker),
+		queue:        newFairQueue(config.QueueSize, config.DefaultClass, config.Classes),
+		tasks:        make(chan *queuedTask),
+		free:         make(chan struct{}),
+		done:         make(chan struct{}),
 		minWorkers:   config.MinWorkers,
 		maxWorkers:   config.MaxWorkers,
 		idleTimeout:  config.IdleTimeout,
 		adjustPeriod: config.AdjustPeriod,
+		rejection:    config.Rejection,
+		manualSize:   config.ManualSize,
+		taskTimeout:  config.TaskTimeout,
 		taskLatencies: make([]time.Duration, 0, 100),
 	}
+	pool.pendingCond = sync.NewCond(&pool.pendingMu)
 	
 	// Start initial workers
+	pool.mu.Lock()
 	for i := 0; i < config.InitialWorkers; i++ {
 		pool.startWorker()
 	}
+	pool.mu.Unlock()
+	
+	// Hand the queued tasks to the workers
+	go pool.dispatch()
 	
 	// Start the adjustment goroutine
 	go pool.adjustWorkers()
@@ -100,20 +177,18 @@ func NewDynamicPool(config PoolConfig) *DynamicPool {
 	return pool
 }
 
-// startWorker creates and starts a new worker
+// startWorker creates and starts a new worker. It must be called with the lock held.
 func (p *DynamicPool) startWorker() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	
 	// Find an unused worker ID
 	id := 0
-	for ; p.workers[id] != nil; id++ {
+	for ; p.workers[id] != nil || p.stopping[id] != nil; id++ {
 	}
 	
 	// Create and start the worker
 	w := &worker{
 		id:       id,
 		tasks:    p.tasks,
+		free:     p.free,
 		idle:     time.Now(),
 		idleTime: p.idleTimeout,
 		stop:     make(chan struct{}),
@@ -130,11 +205,8 @@ func (p *DynamicPool) startWorker() {
 		Msg("Started new worker")
 }
 
-// stopWorker stops a worker
+// stopWorker stops a worker. It must be called with the lock held.
 func (p *DynamicPool) stopWorker(id int) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	
 	if w, ok := p.workers[id]; ok {
 		// Signal the worker to stop
 		close(w.stop)
@@ -148,22 +220,46 @@ func (p *DynamicPool) stopWorker(id int) {
 	}
 }
 
+// retireWorker stops a busy worker after its current task. It no longer
+// counts towards the pool size but stays in stopping until it exits. It must
+// be called with the lock held.
+func (p *DynamicPool) retireWorker(id int) {
+	if w, ok := p.workers[id]; ok {
+		close(w.stop)
+		delete(p.workers, id)
+		p.stopping[id] = w
+		atomic.AddInt32(&p.currentSize, -1)
+	}
+}
+
 // runWorker runs a worker until it's stopped
 func (p *DynamicPool) runWorker(w *worker) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.stopping, w.id)
+		p.mu.Unlock()
+	}()
+
 	for {
+		// Prefer stopping over taking another task when both are ready
 		select {
 		case <-w.stop:
 			return
-		case task, ok := <-w.tasks:
-			if !ok {
-				return
-			}
-			
+		default:
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case w.free <- struct{}{}:
+			// The dispatcher picks a task for the next free worker
+		case task := <-w.tasks:
 			w.processing.Store(true)
 			startTime := time.Now()
 			
 			// Execute the task
-			err := task()
+			err := p.protect(task.run)
+			p.queue.done(task.class)
 			
 			// Record metrics
 			latency := time.Since(startTime)
@@ -175,6 +271,37 @@ func (p *DynamicPool) runWorker(w *worker) {
 	}
 }
 
+// dispatch hands the queued tasks to the workers in fair order until the pool
+// is closed. A task is picked only once a worker is free, so that tasks
+// queued while every worker is busy compete for the next free worker.
+func (p *DynamicPool) dispatch() {
+	for {
+		select {
+		case <-p.free:
+		case <-p.done:
+			return
+		}
+
+		task := p.queue.next()
+		for task == nil {
+			select {
+			case <-p.queue.ready:
+				task = p.queue.next()
+			case <-p.done:
+				return
+			}
+		}
+
+		select {
+		case p.tasks <- task:
+		case <-p.done:
+			p.queue.done(task.class)
+			task.drop()
+			return
+		}
+	}
+}
+
 // recordTaskCompletion records metrics for a completed task
 func (p *DynamicPool) recordTaskCompletion(latency time.Duration, success bool) {
 	// Update latency metrics
@@ -193,12 +320,51 @@ func (p *DynamicPool) recordTaskCompletion(latency time.Duration, success bool)
 		atomic.AddInt64(&p.successTasks, 1)
 	}
 	
-	// Recalculate error rate
+}
+
+// errorRate returns the share of completed tasks that failed
+func (p *DynamicPool) errorRate() float64 {
 	total := atomic.LoadInt64(&p.totalTasks)
-	successful := atomic.LoadInt64(&p.successTasks)
-	if total > 0 {
-		p.errorRate = 1.0 - float64(successful)/float64(total)
+	if total == 0 {
+		return 0
+	}
+	return 1.0 - float64(atomic.LoadInt64(&p.successTasks))/float64(total)
+}
+
+// protect runs a task, recovering a panic into a *ports.PanicError so that
+// one task cannot crash the process
+func (p *DynamicPool) protect(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&p.panics, 1)
+			panicErr := &ports.PanicError{Value: r, Stack: debug.Stack()}
+			log.Error().
+				Interface("panic", r).
+				Str("stack", string(panicErr.Stack)).
+				Msg("Recovered from a panic in a task")
+			err = panicErr
+		}
+	}()
+	return run()
+}
+
+// taskContext returns the context of a task of a group, bounded by the task
+// timeout of the pool
+func (p *DynamicPool) taskContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.taskTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeoutCause(ctx, p.taskTimeout, ports.ErrTaskTimeout)
+}
+
+// timedOut wraps the error of a task whose context reached the task timeout
+// in ports.ErrTaskTimeout and counts it
+func (p *DynamicPool) timedOut(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(context.Cause(ctx), ports.ErrTaskTimeout) {
+		return err
+	}
+	atomic.AddInt64(&p.timeouts, 1)
+	return fmt.Errorf("%w after %s: %w", ports.ErrTaskTimeout, p.taskTimeout, err)
 }
 
 // adjustWorkers periodically adjusts the worker pool size based on metrics
@@ -221,8 +387,17 @@ func (p *DynamicPool) adjustWorkers() {
 			
 			// Calculate average latency
 			avgLatency := p.getAverageLatency()
-			errorRate := p.errorRate
-			queueSize := len(p.tasks)
+			errorRate := p.errorRate()
+			queueSize := p.queue.runnable()
+			
+			// Smooth the queue depth so that a burst does not add workers
+			// that will sit idle a tick later
+			p.queueDepthAverage = (p.queueDepthAverage + float64(queueSize)) / 2
+			blocked := int(atomic.LoadInt32(&p.blocked))
+			if p.manualSize {
+				p.mu.Unlock()
+				continue
+			}
 			
 			// Adaptive scaling logic
 			switch {
@@ -230,8 +405,8 @@ func (p *DynamicPool) adjustWorkers() {
 				// If error rate is high, reduce workers (possible API throttling)
 				p.scaleDown(1)
 				
-			case queueSize > currentSize*2 && currentSize < p.maxWorkers:
-				// If queue is filling up, add workers
+			case (blocked > 0 || p.queueDepthAverage > float64(currentSize*2)) && currentSize < p.maxWorkers:
+				// If queue is filling up or submitters wait for room, add workers
 				p.scaleUp(min(p.maxWorkers-currentSize, 2))
 				
 			case avgLatency > 2*time.Second && currentSize < p.maxWorkers:
@@ -266,9 +441,9 @@ func (p *DynamicPool) scaleUp(count int) {
 	
 	log.Info().
 		Int("newSize", int(atomic.LoadInt32(&p.currentSize))).
-		Float64("errorRate", p.errorRate).
+		Float64("errorRate", p.errorRate()).
 		Dur("avgLatency", p.getAverageLatency()).
-		Int("queueSize", len(p.tasks)).
+		Int("queueSize", p.queue.len()).
 		Msg("Scaled up worker pool")
 }
 
@@ -291,9 +466,9 @@ func (p *DynamicPool) scaleDown(count int) {
 	if len(workersToStop) > 0 {
 		log.Info().
 			Int("newSize", int(atomic.LoadInt32(&p.currentSize))).
-			Float64("errorRate", p.errorRate).
+			Float64("errorRate", p.errorRate()).
 			Dur("avgLatency", p.getAverageLatency()).
-			Int("queueSize", len(p.tasks)).
+			Int("queueSize", p.queue.len()).
 			Msg("Scaled down worker pool")
 	}
 }
@@ -315,39 +490,132 @@ func (p *DynamicPool) getAverageLatency() time.Duration {
 	return total / time.Duration(len(p.taskLatencies))
 }
 
-// Submit submits a task to the worker pool
+// Submit submits a task of the default class to the worker pool. With the
+// RejectBlock policy it waits for room in the queue as long as it takes;
+// use SubmitCtx to bound the wait.
 func (p *DynamicPool) Submit(task func() error) error {
+	return p.SubmitClass(context.Background(), DefaultClass, task)
+}
+
+// SubmitCtx submits a task of the default class to the worker pool. With
+// the RejectBlock policy it waits for room in the queue until ctx is done.
+func (p *DynamicPool) SubmitCtx(ctx context.Context, task func() error) error {
+	return p.SubmitClass(ctx, DefaultClass, task)
+}
+
+// TrySubmit submits a task of the default class to the worker pool without
+// waiting. It returns ports.ErrQueueFull when the queue is full, whatever
+// the rejection policy.
+func (p *DynamicPool) TrySubmit(task func() error) error {
+	return p.submit(context.Background(), DefaultClass, task, func() {}, RejectDrop)
+}
+
+// SubmitClass submits a task of a class to the worker pool. The classes with
+// tasks waiting share the workers by weight, and a class at its max in
+// flight waits for one of its tasks to finish. A full queue applies the
+// rejection policy of the pool, waiting until ctx is done with RejectBlock.
+func (p *DynamicPool) SubmitClass(ctx context.Context, class string, task func() error) error {
+	return p.submit(ctx, class, task, func() {}, p.rejection)
+}
+
+// submit queues a task of a class, applying a rejection policy when the
+// queue is full. drop is called instead of the task when the pool is
+// closed before a worker picks it up.
+func (p *DynamicPool) submit(ctx context.Context, class string, task func() error, drop func(), rejection string) error {
 	if atomic.LoadInt32(&p.closed) != 0 {
-		return errors.New("worker pool is closed")
+		return errPoolClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	
+	p.addPending(1)
+	run := func() error {
+		defer p.addPending(-1)
+		return task()
+	}
+	dropped := func() {
+		defer p.addPending(-1)
+		drop()
+	}
+	
+	err := p.enqueue(ctx, class, run, dropped, rejection)
+	if err != nil {
+		p.addPending(-1)
+	}
+	return err
+}
+
+// enqueue pushes a task to the queue, applying a rejection policy when it is full
+func (p *DynamicPool) enqueue(ctx context.Context, class string, run func() error, drop func(), rejection string) error {
 	// Submit the task to the queue
-	select {
-	case p.tasks <- task:
-		return nil
-	default:
+	room, err := p.queue.push(class, run, drop)
+	if errors.Is(err, ports.ErrQueueFull) && !p.manualSize && int(atomic.LoadInt32(&p.currentSize)) < p.maxWorkers {
 		// If the queue is full, try to add more workers
-		currentSize := int(atomic.LoadInt32(&p.currentSize))
-		if currentSize < p.maxWorkers {
-			p.mu.Lock()
-			p.scaleUp(1)
-			p.mu.Unlock()
-			
-			// Try again now that we've added a worker
-			select {
-			case p.tasks <- task:
-				return nil
-			default:
-				return errors.New("task queue is full")
-			}
+		p.mu.Lock()
+		p.scaleUp(1)
+		p.mu.Unlock()
+		
+		// Try again now that we've added a worker
+		room, err = p.queue.push(class, run, drop)
+	}
+	if !errors.Is(err, ports.ErrQueueFull) {
+		return err
+	}
+	
+	switch rejection {
+	case RejectDrop:
+		atomic.AddInt64(&p.rejected, 1)
+		return err
+		
+	case RejectCallerRuns:
+		// Slow the submitter down by the time the task takes
+		atomic.AddInt64(&p.callerRuns, 1)
+		startTime := time.Now()
+		taskErr := p.protect(run)
+		p.recordTaskCompletion(time.Since(startTime), taskErr == nil)
+		return nil
+	}
+	
+	// Wait for room in the queue
+	atomic.AddInt32(&p.blocked, 1)
+	defer atomic.AddInt32(&p.blocked, -1)
+	for {
+		select {
+		case <-room:
+		case <-ctx.Done():
+			atomic.AddInt64(&p.cancelled, 1)
+			return ctx.Err()
+		case <-p.done:
+			return errPoolClosed
+		}
+		
+		room, err = p.queue.push(class, run, drop)
+		if !errors.Is(err, ports.ErrQueueFull) {
+			return err
 		}
-		return errors.New("task queue is full")
 	}
 }
 
-// Wait waits for all tasks to complete
+// addPending counts submitted tasks in or out
+func (p *DynamicPool) addPending(delta int) {
+	p.pendingMu.Lock()
+	p.pending += delta
+	if p.pending == 0 {
+		p.pendingCond.Broadcast()
+	}
+	p.pendingMu.Unlock()
+}
+
+// Wait waits for every task submitted to the pool, by any caller, to complete.
+// Use a Group to wait for a batch of tasks.
 func (p *DynamicPool) Wait() error {
-	p.wg.Wait()
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	
+	for p.pending > 0 {
+		p.pendingCond.Wait()
+	}
 	return nil
 }
 
@@ -388,13 +656,21 @@ func (p *DynamicPool) SetPoolSize(size int) {
 			p.stopWorker(id)
 		}
 		
-		// If we couldn't find enough idle workers, just log it
+		// Busy workers stop once their current task completes, so that a
+		// saturated pool can still shrink
 		if len(idleWorkers) < toStop {
-			log.Info().
+			busy := toStop - len(idleWorkers)
+			for id := range p.workers {
+				if busy == 0 {
+					break
+				}
+				p.retireWorker(id)
+				busy--
+			}
+			log.Debug().
 				Int("requested", size).
-				Int("current", currentSize).
-				Int("stopped", len(idleWorkers)).
-				Msg("Could not stop all requested workers, waiting for tasks to complete")
+				Int("idleStopped", len(idleWorkers)).
+				Msg("Stopping busy workers after their current task")
 		}
 	}
 	
@@ -410,8 +686,11 @@ func (p *DynamicPool) Close() error {
 		return nil // Already closed
 	}
 	
-	// Close the tasks channel to signal workers to exit
-	close(p.tasks)
+	// Stop dispatching tasks and drop the queued ones
+	close(p.done)
+	for _, task := range p.queue.drain() {
+		task.drop()
+	}
 	
 	// Stop all workers
 	p.mu.Lock()
@@ -429,4 +708,89 @@ func min(a, b int) int {
 		return a
 	}
 	return b
-} 
\ No newline at end of file
+}
+
+// PoolStats is a snapshot of the pool's state and performance metrics
+type PoolStats struct {
+	Workers        int
+	BusyWorkers    int
+	MinWorkers     int
+	MaxWorkers     int
+	QueueDepth     int
+	QueueCapacity  int
+	TotalTasks     int64
+	FailedTasks    int64
+	ErrorRate      float64
+	AverageLatency time.Duration
+
+	// QueueDepthAverage is the smoothed queue depth the pool scales on and
+	// BlockedSubmitters the submitters waiting for room in the queue
+	QueueDepthAverage float64
+	BlockedSubmitters int
+
+	// RejectedTasks were refused by a full queue, CallerRunTasks ran on
+	// the submitter's goroutine and CancelledSubmits gave up waiting for room
+	RejectedTasks    int64
+	CallerRunTasks   int64
+	CancelledSubmits int64
+
+	// PanickedTasks panicked and TimedOutTasks failed after their timeout
+	PanickedTasks int64
+	TimedOutTasks int64
+
+	// StoppingWorkers were removed from the pool while busy and stop after
+	// their current task; they are not counted in Workers
+	StoppingWorkers int
+
+	// Classes are the task classes with tasks queued or running
+	Classes []ClassStats
+}
+
+// Stats returns a snapshot of the pool's state and performance metrics
+func (p *DynamicPool) Stats() PoolStats {
+	p.mu.Lock()
+	busy := 0
+	for _, w := range p.workers {
+		if w.processing.Load() {
+			busy++
+		}
+	}
+	for _, w := range p.stopping {
+		if w.processing.Load() {
+			busy++
+		}
+	}
+	stopping := len(p.stopping)
+	queueDepthAverage := p.queueDepthAverage
+	p.mu.Unlock()
+
+	total := atomic.LoadInt64(&p.totalTasks)
+	failed := total - atomic.LoadInt64(&p.successTasks)
+	var errorRate float64
+	if total > 0 {
+		errorRate = float64(failed) / float64(total)
+	}
+
+	return PoolStats{
+		Workers:        int(atomic.LoadInt32(&p.currentSize)),
+		BusyWorkers:    busy,
+		MinWorkers:     p.minWorkers,
+		MaxWorkers:     p.maxWorkers,
+		QueueDepth:     p.queue.len(),
+		QueueCapacity:  p.queue.capacity,
+		TotalTasks:     total,
+		FailedTasks:    failed,
+		ErrorRate:      errorRate,
+		AverageLatency: p.getAverageLatency(),
+		Classes:        p.queue.stats(),
+
+		StoppingWorkers:   stopping,
+		QueueDepthAverage: queueDepthAverage,
+		BlockedSubmitters: int(atomic.LoadInt32(&p.blocked)),
+		RejectedTasks:     atomic.LoadInt64(&p.rejected),
+		CallerRunTasks:    atomic.LoadInt64(&p.callerRuns),
+		CancelledSubmits:  atomic.LoadInt64(&p.cancelled),
+		PanickedTasks:     atomic.LoadInt64(&p.panics),
+		TimedOutTasks:     atomic.LoadInt64(&p.timeouts),
+	}
+}
@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+func TestSetPoolSizeStopsBusyWorkersAfterTheirTask(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{
+		InitialWorkers: 6,
+		MinWorkers:     2,
+		MaxWorkers:     6,
+		ManualSize:     true,
+	})
+	defer pool.Close()
+
+	// Occupy every worker
+	release := make(chan struct{})
+	started := make(chan struct{}, 6)
+	group := pool.Group(context.Background(), false)
+	for i := 0; i < 6; i++ {
+		group.Go(DefaultClass, func(ctx context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	}
+	for i := 0; i < 6; i++ {
+		<-started
+	}
+
+	// The busy workers leave the pool size at once but keep running their task
+	pool.SetPoolSize(2)
+	stats := pool.Stats()
+	if stats.Workers != 2 || stats.StoppingWorkers != 4 || stats.BusyWorkers != 6 {
+		t.Errorf("workers %d, stopping %d, busy %d, want 2, 4 and 6", stats.Workers, stats.StoppingWorkers, stats.BusyWorkers)
+	}
+
+	// Asking for the same size again does not stop more workers
+	pool.SetPoolSize(2)
+	if stats := pool.Stats(); stats.Workers != 2 || stats.StoppingWorkers != 4 {
+		t.Errorf("workers %d, stopping %d after a second resize, want 2 and 4", stats.Workers, stats.StoppingWorkers)
+	}
+
+	close(release)
+	if err := group.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := pool.Stats()
+		if stats.StoppingWorkers == 0 && stats.BusyWorkers == 0 {
+			if stats.Workers != 2 {
+				t.Errorf("workers %d after the busy workers stopped, want 2", stats.Workers)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stopping %d, busy %d after their tasks finished, want 0", stats.StoppingWorkers, stats.BusyWorkers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fullPool returns a pool of one busy worker and a full queue of one task,
+// and a function releasing them
+func fullPool(t *testing.T, rejection string) (*DynamicPool, func()) {
+	t.Helper()
+
+	pool := NewDynamicPool(PoolConfig{
+		InitialWorkers: 1,
+		MinWorkers:     1,
+		MaxWorkers:     1,
+		QueueSize:      1,
+		ManualSize:     true,
+		Rejection:      rejection,
+	})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := pool.Submit(func() error {
+		close(started)
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := pool.Submit(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			close(release)
+			pool.Close()
+		})
+	}
+	t.Cleanup(done)
+	return pool, done
+}
+
+func TestDropPolicyRejectsWhenQueueIsFull(t *testing.T) {
+	pool, _ := fullPool(t, RejectDrop)
+
+	var ran atomic.Bool
+	err := pool.SubmitCtx(context.Background(), func() error {
+		ran.Store(true)
+		return nil
+	})
+	if !errors.Is(err, ports.ErrQueueFull) {
+		t.Fatalf("submit returned %v, want ErrQueueFull", err)
+	}
+	if ran.Load() {
+		t.Error("rejected task ran")
+	}
+	if stats := pool.Stats(); stats.RejectedTasks != 1 {
+		t.Errorf("%d rejected tasks, want 1", stats.RejectedTasks)
+	}
+}
+
+func TestCallerRunsPolicyRunsOnSubmitter(t *testing.T) {
+	pool, _ := fullPool(t, RejectCallerRuns)
+
+	// The task runs before Submit returns, even though the only worker is busy
+	var ran atomic.Bool
+	if err := pool.Submit(func() error {
+		ran.Store(true)
+		return errors.New("task error")
+	}); err != nil {
+		t.Fatalf("submit returned %v, want nil", err)
+	}
+	if !ran.Load() {
+		t.Fatal("task did not run on the submitter")
+	}
+	if stats := pool.Stats(); stats.CallerRunTasks != 1 {
+		t.Errorf("%d caller run tasks, want 1", stats.CallerRunTasks)
+	}
+}
+
+func TestTrySubmitFailsOnFullQueue(t *testing.T) {
+	// TrySubmit never waits, whatever the pool's rejection policy
+	pool, _ := fullPool(t, RejectBlock)
+
+	if err := pool.TrySubmit(func() error { return nil }); !errors.Is(err, ports.ErrQueueFull) {
+		t.Fatalf("TrySubmit returned %v, want ErrQueueFull", err)
+	}
+	if stats := pool.Stats(); stats.BlockedSubmitters != 0 {
+		t.Errorf("%d blocked submitters, want 0", stats.BlockedSubmitters)
+	}
+}
+
+func TestCancelBlockedSubmit(t *testing.T) {
+	pool, release := fullPool(t, RejectBlock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran atomic.Bool
+	result := make(chan error, 1)
+	go func() {
+		result <- pool.SubmitCtx(ctx, func() error {
+			ran.Store(true)
+			return nil
+		})
+	}()
+
+	// Wait for the submitter to block on the full queue
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().BlockedSubmitters != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("submitter did not block on the full queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("submit returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("submit did not return once its context was cancelled")
+	}
+	if stats := pool.Stats(); stats.CancelledSubmits != 1 || stats.BlockedSubmitters != 0 {
+		t.Errorf("%d cancelled submits and %d blocked submitters, want 1 and 0", stats.CancelledSubmits, stats.BlockedSubmitters)
+	}
+
+	// The cancelled task never runs once the queue drains
+	release()
+	if err := pool.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ran.Load() {
+		t.Error("cancelled task ran")
+	}
+}
@@ -0,0 +1,275 @@
+package worker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// DefaultClass is the class of tasks submitted without one
+const DefaultClass = "default"
+
+// errPoolClosed is returned for tasks submitted to or dropped by a closed pool
+var errPoolClosed = errors.New("worker pool is closed")
+
+// ClassConfig sets how the tasks of a class share the workers
+type ClassConfig struct {
+	// Weight is the share of the workers the class gets while other classes
+	// have tasks waiting: a class of weight 2 starts twice as many tasks as a
+	// class of weight 1 (default 1)
+	Weight int `json:"weight"`
+
+	// MaxInFlight caps the tasks of the class running at once; zero leaves
+	// them uncapped
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// ClassStats is a snapshot of the tasks of a class
+type ClassStats struct {
+	Name        string
+	Weight      int
+	MaxInFlight int
+	Queued      int
+	InFlight    int
+}
+
+// queuedTask is a task waiting in the queue of its class. drop is called
+// instead of run when the queue is drained.
+type queuedTask struct {
+	class *taskClass
+	run   func() error
+	drop  func()
+	seq   uint64
+}
+
+// taskClass is the queue and the share of the workers of a class
+type taskClass struct {
+	name   string
+	config ClassConfig
+	queue  []*queuedTask
+
+	inFlight int
+
+	// pass is the virtual time of the next task of the class; it advances
+	// by 1/weight for every task started, and the class with the lowest
+	// pass goes next
+	pass float64
+}
+
+// eligible reports whether the class has a task it may start
+func (c *taskClass) eligible() bool {
+	return len(c.queue) > 0 && (c.config.MaxInFlight <= 0 || c.inFlight < c.config.MaxInFlight)
+}
+
+// idle reports whether the class has no task queued or running
+func (c *taskClass) idle() bool {
+	return len(c.queue) == 0 && c.inFlight == 0
+}
+
+// fairQueue holds the tasks waiting for a worker, one FIFO queue per class.
+// Classes share the workers by weighted fair queuing (stride scheduling):
+// a worker takes the oldest task of the eligible class that has received
+// the least service for its weight. Classes at their max in flight are
+// passed over until one of their tasks finishes.
+type fairQueue struct {
+	mu       sync.Mutex
+	classes  map[string]*taskClass
+	configs  map[string]ClassConfig
+	defaults ClassConfig
+	capacity int
+	queued   int
+	seq      uint64
+	closed   bool
+
+	// vtime is the pass of the last class served. A class becoming active
+	// starts from it, so idle classes do not bank service for later.
+	vtime float64
+
+	// ready is signalled when a task may have become eligible
+	ready chan struct{}
+
+	// room is closed, and replaced, when a full queue frees a place
+	room chan struct{}
+}
+
+// newFairQueue creates a queue of capacity tasks
+func newFairQueue(capacity int, defaults ClassConfig, configs map[string]ClassConfig) *fairQueue {
+	return &fairQueue{
+		classes:  make(map[string]*taskClass),
+		configs:  configs,
+		defaults: defaults,
+		capacity: capacity,
+		ready:    make(chan struct{}, 1),
+		room:     make(chan struct{}),
+	}
+}
+
+// classConfig returns the configuration of a class
+func (q *fairQueue) classConfig(name string) ClassConfig {
+	config, ok := q.configs[name]
+	if !ok {
+		config = q.defaults
+	}
+	if config.Weight <= 0 {
+		config.Weight = 1
+	}
+	return config
+}
+
+// push queues a task of a class. When the queue is full, it returns
+// ports.ErrQueueFull and a channel closed once a place is freed.
+func (q *fairQueue) push(name string, run func() error, drop func()) (<-chan struct{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, errPoolClosed
+	}
+	if q.queued >= q.capacity {
+		return q.room, ports.ErrQueueFull
+	}
+
+	class, ok := q.classes[name]
+	if !ok {
+		class = &taskClass{name: name, config: q.classConfig(name), pass: q.vtime}
+		q.classes[name] = class
+	}
+	if class.idle() && class.pass < q.vtime {
+		class.pass = q.vtime
+	}
+
+	q.seq++
+	class.queue = append(class.queue, &queuedTask{class: class, run: run, drop: drop, seq: q.seq})
+	q.queued++
+	q.signal()
+	return nil, nil
+}
+
+// next removes the task to start next and counts it in flight. It returns
+// nil when no class has a task it may start.
+func (q *fairQueue) next() *queuedTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var best *taskClass
+	for _, class := range q.classes {
+		if !class.eligible() {
+			continue
+		}
+		if best == nil || class.pass < best.pass ||
+			(class.pass == best.pass && class.queue[0].seq < best.queue[0].seq) {
+			best = class
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	task := best.queue[0]
+	best.queue[0] = nil
+	best.queue = best.queue[1:]
+	best.inFlight++
+	q.freePlace()
+
+	q.vtime = best.pass
+	best.pass += 1 / float64(best.config.Weight)
+	return task
+}
+
+// done records that a task of a class has finished
+func (q *fairQueue) done(class *taskClass) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	class.inFlight--
+	if class.idle() && class.pass <= q.vtime {
+		// Nothing to remember about the class
+		delete(q.classes, class.name)
+	}
+	q.signal()
+}
+
+// drain refuses new tasks and removes the queued ones
+func (q *fairQueue) drain() []*queuedTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	var tasks []*queuedTask
+	for name, class := range q.classes {
+		tasks = append(tasks, class.queue...)
+		class.queue = nil
+		if class.inFlight == 0 {
+			delete(q.classes, name)
+		}
+	}
+	q.queued = 0
+	close(q.room)
+	q.room = make(chan struct{})
+	return tasks
+}
+
+// freePlace removes a task from the count of queued tasks and wakes the
+// submitters waiting for a place. It must be called with the lock held.
+func (q *fairQueue) freePlace() {
+	if q.queued == q.capacity {
+		close(q.room)
+		q.room = make(chan struct{})
+	}
+	q.queued--
+}
+
+// signal wakes the dispatcher. It must be called with the lock held.
+func (q *fairQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// len returns the number of queued tasks
+func (q *fairQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// runnable returns the number of queued tasks not held back by the max in
+// flight of their class
+func (q *fairQueue) runnable() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	runnable := 0
+	for _, class := range q.classes {
+		waiting := len(class.queue)
+		if class.config.MaxInFlight > 0 {
+			waiting = min(waiting, max(class.config.MaxInFlight-class.inFlight, 0))
+		}
+		runnable += waiting
+	}
+	return runnable
+}
+
+// stats returns a snapshot of every class with tasks queued or running, by name
+func (q *fairQueue) stats() []ClassStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make([]ClassStats, 0, len(q.classes))
+	for _, class := range q.classes {
+		stats = append(stats, ClassStats{
+			Name:        class.name,
+			Weight:      class.config.Weight,
+			MaxInFlight: class.config.MaxInFlight,
+			Queued:      len(class.queue),
+			InFlight:    class.inFlight,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFairQueueWeights(t *testing.T) {
+	q := newFairQueue(100, ClassConfig{}, map[string]ClassConfig{
+		"live":     {Weight: 2},
+		"backfill": {Weight: 1},
+	})
+	for i := 0; i < 12; i++ {
+		q.push("backfill", func() error { return nil }, func() {})
+		q.push("live", func() error { return nil }, func() {})
+	}
+
+	// While both classes have tasks waiting, live starts two tasks for every backfill task
+	started := make(map[string]int)
+	for i := 0; i < 12; i++ {
+		task := q.next()
+		started[task.class.name]++
+		q.done(task.class)
+	}
+	if started["live"] != 8 || started["backfill"] != 4 {
+		t.Errorf("started %v, want 8 live and 4 backfill tasks", started)
+	}
+}
+
+func TestBlockedClassDoesNotStarveOthers(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{
+		InitialWorkers: 4,
+		MinWorkers:     4,
+		MaxWorkers:     4,
+		ManualSize:     true,
+		Classes: map[string]ClassConfig{
+			"backfill": {Weight: 1, MaxInFlight: 2},
+			"live":     {Weight: 3},
+		},
+	})
+	defer pool.Close()
+
+	// Backfill tasks block until released, more of them than there are workers
+	release := make(chan struct{})
+	var backfillRunning, backfillPeak atomic.Int32
+	backfill := pool.Group(context.Background(), false)
+	for i := 0; i < 8; i++ {
+		backfill.Go("backfill", func(ctx context.Context) error {
+			n := backfillRunning.Add(1)
+			defer backfillRunning.Add(-1)
+			for {
+				peak := backfillPeak.Load()
+				if n <= peak || backfillPeak.CompareAndSwap(peak, n) {
+					break
+				}
+			}
+			<-release
+			return nil
+		})
+	}
+
+	// Live tasks run on the workers backfill may not take
+	var liveDone atomic.Int32
+	live := pool.Group(context.Background(), false)
+	for i := 0; i < 20; i++ {
+		live.Go("live", func(ctx context.Context) error {
+			liveDone.Add(1)
+			return nil
+		})
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		live.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%d of 20 live tasks completed while backfill tasks were blocked", liveDone.Load())
+	}
+
+	if peak := backfillPeak.Load(); peak != 2 {
+		t.Errorf("%d backfill tasks ran at once, want 2", peak)
+	}
+	for _, class := range pool.Stats().Classes {
+		if class.Name == "backfill" && (class.InFlight != 2 || class.Queued != 6) {
+			t.Errorf("backfill has %d tasks in flight and %d queued, want 2 and 6", class.InFlight, class.Queued)
+		}
+	}
+
+	// The queued backfill tasks still run two at a time once released
+	close(release)
+	if err := backfill.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if peak := backfillPeak.Load(); peak != 2 {
+		t.Errorf("%d backfill tasks ran at once, want 2", peak)
+	}
+}
+
+func TestClassArrivingWhileWorkersAreBusyGoesFirst(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{
+		InitialWorkers: 1,
+		MinWorkers:     1,
+		MaxWorkers:     1,
+		ManualSize:     true,
+		Classes: map[string]ClassConfig{
+			"backfill": {Weight: 1},
+			"live":     {Weight: 3},
+		},
+	})
+	defer pool.Close()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+
+	// Occupy the only worker with a backfill task
+	release := make(chan struct{})
+	started := make(chan struct{})
+	group := pool.Group(context.Background(), false)
+	group.Go("backfill", func(ctx context.Context) error {
+		record("backfill-1")
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// A second backfill task waits; give the dispatcher time to look at it
+	group.Go("backfill", func(ctx context.Context) error {
+		record("backfill-2")
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	// The live class arrives later but has received less service, so it
+	// takes the worker first once it is free
+	group.Go("live", func(ctx context.Context) error {
+		record("live")
+		return nil
+	})
+
+	close(release)
+	if err := group.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"backfill-1", "live", "backfill-2"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("tasks started in order %v, want %v", order, want)
+	}
+}
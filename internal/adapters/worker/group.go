@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// Group is a batch of tasks run by the workers of a pool, with its own wait
+// and errors, so that callers sharing a pool do not wait for each other's
+// tasks. Use DynamicPool.Group to create one.
+type Group struct {
+	pool          *DynamicPool
+	ctx           context.Context
+	cancel        context.CancelCauseFunc
+	cancelOnError bool
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// Group creates a group of tasks run by the pool. Its tasks receive a
+// context of ctx that is cancelled once Wait returns. With cancelOnError,
+// the first task error also cancels it, with the error as its cause.
+func (p *DynamicPool) Group(ctx context.Context, cancelOnError bool) ports.TaskGroup {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Group{
+		pool:          p,
+		ctx:           ctx,
+		cancel:        cancel,
+		cancelOnError: cancelOnError,
+	}
+}
+
+// Go submits a task of a class to the pool, waiting for room in the queue
+// until the group's context is done under the RejectBlock policy. The task
+// receives a context of the group's bounded by the pool's task timeout; a
+// task that panics fails with a *ports.PanicError. Errors of the task are
+// returned by Wait; Go only returns the submission error, in which case the
+// task is not part of the group.
+func (g *Group) Go(class string, task func(ctx context.Context) error) error {
+	g.wg.Add(1)
+	err := g.pool.submit(g.ctx, class, func() error {
+		defer g.wg.Done()
+
+		ctx, cancel := g.pool.taskContext(g.ctx)
+		defer cancel()
+		err := g.pool.protect(func() error {
+			return task(ctx)
+		})
+		err = g.pool.timedOut(ctx, err)
+		if err != nil {
+			g.fail(err)
+		}
+		return err
+	}, func() {
+		defer g.wg.Done()
+		g.fail(errPoolClosed)
+	}, g.pool.rejection)
+	if err != nil {
+		g.wg.Done()
+	}
+	return err
+}
+
+// Wait waits for the tasks of the group and returns their errors joined
+// with errors.Join, or nil when they all succeeded
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel(nil)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
+
+// fail records the error of a task
+func (g *Group) fail(err error) {
+	g.mu.Lock()
+	g.errs = append(g.errs, err)
+	g.mu.Unlock()
+
+	if g.cancelOnError {
+		g.cancel(err)
+	}
+}
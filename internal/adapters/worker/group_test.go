@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+func TestGroupFirstErrorCancelsContext(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{InitialWorkers: 4, MinWorkers: 4, MaxWorkers: 4, ManualSize: true})
+	defer pool.Close()
+
+	first := errors.New("first failure")
+	group := pool.Group(context.Background(), true)
+
+	// Siblings wait for the group's context to be cancelled
+	var cause atomic.Value
+	for i := 0; i < 3; i++ {
+		group.Go(DefaultClass, func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				cause.Store(context.Cause(ctx))
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return errors.New("context not cancelled")
+			}
+		})
+	}
+	group.Go(DefaultClass, func(ctx context.Context) error {
+		return first
+	})
+
+	err := group.Wait()
+	if !errors.Is(err, first) {
+		t.Fatalf("Wait returned %v, want the first error", err)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || joined.Unwrap()[0] != first {
+		t.Errorf("Wait returned %v, want the first error ahead of the others", err)
+	}
+	if got := cause.Load(); got != first {
+		t.Errorf("siblings were cancelled with cause %v, want the first error", got)
+	}
+}
+
+func TestGroupWithoutCancelOnErrorKeepsRunning(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{InitialWorkers: 2, MinWorkers: 2, MaxWorkers: 2, ManualSize: true})
+	defer pool.Close()
+
+	failure := errors.New("failure")
+	group := pool.Group(context.Background(), false)
+
+	failed := make(chan struct{})
+	group.Go(DefaultClass, func(ctx context.Context) error {
+		defer close(failed)
+		return failure
+	})
+
+	// Tasks running and submitted after the failure still complete
+	var completed atomic.Int32
+	for i := 0; i < 5; i++ {
+		group.Go(DefaultClass, func(ctx context.Context) error {
+			<-failed
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			completed.Add(1)
+			return nil
+		})
+	}
+	<-failed
+	if err := group.Go(DefaultClass, func(ctx context.Context) error {
+		completed.Add(1)
+		return ctx.Err()
+	}); err != nil {
+		t.Fatalf("submitting after a failure: %v", err)
+	}
+
+	err := group.Wait()
+	if !errors.Is(err, failure) {
+		t.Fatalf("Wait returned %v, want the task failure", err)
+	}
+	if n := completed.Load(); n != 6 {
+		t.Errorf("%d tasks completed after the failure, want 6", n)
+	}
+}
+
+func TestGroupReturnsPanicWithStack(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{InitialWorkers: 1, MinWorkers: 1, MaxWorkers: 1, ManualSize: true})
+	defer pool.Close()
+
+	group := pool.Group(context.Background(), false)
+	group.Go(DefaultClass, func(ctx context.Context) error {
+		panicInTask()
+		return nil
+	})
+
+	err := group.Wait()
+	var panicErr *ports.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Wait returned %v, want a *ports.PanicError", err)
+	}
+	if panicErr.Value != "boom" {
+		t.Errorf("panic value %v, want boom", panicErr.Value)
+	}
+	if !strings.Contains(string(panicErr.Stack), "panicInTask") {
+		t.Errorf("stack does not show the panicking function:\n%s", panicErr.Stack)
+	}
+	if stats := pool.Stats(); stats.PanickedTasks != 1 {
+		t.Errorf("%d panicked tasks, want 1", stats.PanickedTasks)
+	}
+
+	// The worker survives the panic
+	group = pool.Group(context.Background(), false)
+	group.Go(DefaultClass, func(ctx context.Context) error { return nil })
+	if err := group.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// panicInTask panics so that its name shows in the recovered stack
+func panicInTask() {
+	panic("boom")
+}
+
+func TestGroupTaskTimeout(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{
+		InitialWorkers: 1,
+		MinWorkers:     1,
+		MaxWorkers:     1,
+		ManualSize:     true,
+		TaskTimeout:    20 * time.Millisecond,
+	})
+	defer pool.Close()
+
+	group := pool.Group(context.Background(), false)
+	group.Go(DefaultClass, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	err := group.Wait()
+	if !errors.Is(err, ports.ErrTaskTimeout) {
+		t.Fatalf("Wait returned %v, want ErrTaskTimeout", err)
+	}
+	if stats := pool.Stats(); stats.TimedOutTasks != 1 {
+		t.Errorf("%d timed out tasks, want 1", stats.TimedOutTasks)
+	}
+}
+
+func TestGroupTaskWithoutTimeoutIsLeftAlone(t *testing.T) {
+	pool := NewDynamicPool(PoolConfig{InitialWorkers: 1, MinWorkers: 1, MaxWorkers: 1, ManualSize: true})
+	defer pool.Close()
+
+	// Without a task timeout tasks get no deadline and may outlast any bound
+	group := pool.Group(context.Background(), false)
+	group.Go(DefaultClass, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			return errors.New("task has a deadline")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		}
+	})
+
+	// Errors that are not timeouts are not reported as such
+	failure := errors.New("failure")
+	group.Go(DefaultClass, func(ctx context.Context) error {
+		return failure
+	})
+
+	err := group.Wait()
+	if !errors.Is(err, failure) || errors.Is(err, ports.ErrTaskTimeout) {
+		t.Fatalf("Wait returned %v, want only the task failure", err)
+	}
+	if stats := pool.Stats(); stats.TimedOutTasks != 0 {
+		t.Errorf("%d timed out tasks, want 0", stats.TimedOutTasks)
+	}
+}
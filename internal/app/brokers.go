@@ -0,0 +1,199 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/cloudevents"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/fanout"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/file"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/kafka"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/nats"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/rabbitmq"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/webhook"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+)
+
+// Supported brokers
+const (
+	BrokerKafka    = "kafka"
+	BrokerNATS     = "nats"
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerWebhook  = "webhook"
+
+	// BrokerFile writes events as JSON lines under FileSinkDir
+	BrokerFile = "file"
+
+	// SinkFile is the name of the file publisher when used as a sink
+	SinkFile = BrokerFile
+)
+
+// BrokerPublisher is an event publisher that can provision the topics it publishes to
+type BrokerPublisher interface {
+	ports.EventPublisher
+
+	// EnsureTopics checks that the broker is reachable and provisions the topics
+	EnsureTopics(ctx context.Context, topics []string) error
+
+	// Ping checks that the broker is reachable
+	Ping(ctx context.Context) error
+}
+
+// newBrokerPublisher creates the publisher of the configured broker along with
+// the function telling outages apart from rejected messages
+func newBrokerPublisher(config Config, router *routing.Router, encoder ports.EventEncoder) (BrokerPublisher, func(error) bool, error) {
+	switch config.Broker {
+	case "", BrokerKafka:
+		var envelope *cloudevents.Envelope
+		if config.CloudEventsMode != "" {
+			var err error
+			envelope, err = cloudevents.NewEnvelope(cloudevents.Config{
+				Mode:   config.CloudEventsMode,
+				Source: config.CloudEventsSource,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		publisher, err := kafka.NewPublisher(kafka.PublisherConfig{
+			Brokers:       config.KafkaBrokers,
+			Router:        router,
+			Producer:      config.KafkaProducer,
+			BatchSize:     config.KafkaBatchSize,
+			FlushInterval: config.KafkaLinger,
+			RequiredAcks:  config.KafkaRequiredAcks,
+			Compression:   config.KafkaCompression,
+			Topics:        kafkaTopicConfig(config),
+			Encoder:       encoder,
+			CloudEvents:   envelope,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return publisher, kafka.IsUnavailable, nil
+
+	case BrokerNATS:
+		publisher, err := nats.NewPublisher(nats.PublisherConfig{
+			URL:          config.NATSURL,
+			Producer:     config.KafkaProducer,
+			Router:       router,
+			Encoder:      encoder,
+			Stream:       config.NATSStream,
+			CreateStream: config.NATSCreateStream,
+			Replicas:     config.NATSReplicas,
+			MaxAge:       config.NATSMaxAge,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return publisher, nats.IsUnavailable, nil
+
+	case BrokerRabbitMQ:
+		publisher, err := rabbitmq.NewPublisher(rabbitmq.PublisherConfig{
+			URL:           config.RabbitMQURL,
+			Producer:      config.KafkaProducer,
+			Exchange:      config.RabbitMQExchange,
+			DeclareQueues: config.RabbitMQDeclareQueues,
+			Router:        router,
+			Encoder:       encoder,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return publisher, rabbitmq.IsUnavailable, nil
+
+	case BrokerWebhook:
+		destinations := config.WebhookDestinations
+		if len(destinations) == 0 && config.WebhookConfig != "" {
+			var err error
+			destinations, err = webhook.LoadDestinations(config.WebhookConfig)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		publisher, err := webhook.NewPublisher(webhook.PublisherConfig{
+			Destinations: destinations,
+			Producer:     config.KafkaProducer,
+			MaxRetries:   config.WebhookRetries,
+			Timeout:      config.WebhookTimeout,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return publisher, webhook.IsUnavailable, nil
+
+	case BrokerFile:
+		publisher, err := newFilePublisher(config, router)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Write errors are not outages, so nothing is spooled
+		return publisher, func(error) bool { return false }, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown broker %q", config.Broker)
+}
+
+// newEventPublisher fans the broker publisher out to the configured sinks.
+// The broker publisher is returned as is when no sinks are configured.
+func newEventPublisher(config Config, router *routing.Router, encoder ports.EventEncoder, broker BrokerPublisher) (BrokerPublisher, error) {
+	if len(config.Sinks) == 0 {
+		return broker, nil
+	}
+
+	required := make(map[string]bool, len(config.RequiredSinks))
+	for _, name := range config.RequiredSinks {
+		required[name] = true
+	}
+
+	brokerName := config.Broker
+	if brokerName == "" {
+		brokerName = BrokerKafka
+	}
+	sinks := []fanout.Sink{{Name: brokerName, Publisher: broker, Required: true}}
+
+	closeSinks := func() {
+		for _, sink := range sinks[1:] {
+			sink.Publisher.Close()
+		}
+	}
+
+	for _, name := range config.Sinks {
+		if name == brokerName {
+			continue
+		}
+
+		sinkConfig := config
+		sinkConfig.Broker = name
+		publisher, _, err := newBrokerPublisher(sinkConfig, router, encoder)
+		if err != nil {
+			closeSinks()
+			return nil, fmt.Errorf("error creating sink %s: %w", name, err)
+		}
+
+		sinks = append(sinks, fanout.Sink{Name: name, Publisher: publisher, Required: required[name]})
+	}
+
+	return fanout.NewPublisher(sinks...)
+}
+
+// newFilePublisher creates the publisher writing JSON lines under FileSinkDir
+func newFilePublisher(config Config, router *routing.Router) (*file.Publisher, error) {
+	dir := config.FileSinkDir
+	if dir == "" {
+		dir = filepath.Join(config.OutputDir, "events")
+	}
+	return file.NewPublisher(file.PublisherConfig{Dir: dir, Router: router})
+}
+
+// kafkaTopicConfig returns the provisioning settings of Kafka topics
+func kafkaTopicConfig(config Config) kafka.TopicConfig {
+	return kafka.TopicConfig{
+		Create:            config.KafkaCreateTopics,
+		Partitions:        config.KafkaTopicPartitions,
+		ReplicationFactor: config.KafkaTopicReplication,
+		Retention:         config.KafkaTopicRetention,
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/worker"
+)
+
+// GetEnvOrDefault returns the value of an environment variable, or defaultValue when it is unset or empty
+func GetEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GetEnvInt returns an environment variable parsed as an int, or defaultValue when it is unset or invalid
+func GetEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+// GetEnvBool returns an environment variable parsed as a bool, or defaultValue when it is unset or invalid
+func GetEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// GetEnvFloat returns an environment variable parsed as a float64, or defaultValue when it is unset or invalid
+func GetEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// GetEnvDuration returns an environment variable parsed as a duration, or defaultValue when it is unset or invalid
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
+
+// GetEnvList returns a comma-separated environment variable, or defaultValue when it is unset or empty
+func GetEnvList(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		return strings.Split(value, ",")
+	}
+	return defaultValue
+}
+
+// GetEnvJSONList returns an environment variable holding a JSON array of strings, or defaultValue when it is unset or empty.
+// It returns an error when the variable is not a valid JSON array of strings.
+func GetEnvJSONList(key string, defaultValue []string) ([]string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		return nil, fmt.Errorf("invalid %s, expected a JSON array of strings: %w", key, err)
+	}
+	return list, nil
+}
+
+// GetEnvPoolClasses returns an environment variable holding the JSON task classes of the worker pool, or defaultValue when it is unset or invalid
+func GetEnvPoolClasses(key string, defaultValue map[string]worker.ClassConfig) map[string]worker.ClassConfig {
+	if value := os.Getenv(key); value != "" {
+		var classes map[string]worker.ClassConfig
+		if err := json.Unmarshal([]byte(value), &classes); err == nil {
+			return classes
+		}
+	}
+	return defaultValue
+}
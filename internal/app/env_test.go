@@ -0,0 +1,44 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolTaskTimeoutIsOffUnlessSet(t *testing.T) {
+	t.Setenv("POOL_TASK_TIMEOUT", "")
+	config, err := ConfigFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PoolTaskTimeout != 0 {
+		t.Fatalf("task timeout %s without POOL_TASK_TIMEOUT, want none", config.PoolTaskTimeout)
+	}
+
+	t.Setenv("POOL_TASK_TIMEOUT", "90s")
+	config, err = ConfigFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PoolTaskTimeout != 90*time.Second {
+		t.Fatalf("task timeout %s with POOL_TASK_TIMEOUT=90s, want 1m30s", config.PoolTaskTimeout)
+	}
+}
+
+func TestMalformedEndpointsAreRejected(t *testing.T) {
+	t.Setenv("ENDPOINTS_JSON", `["QmA", "QmB"]`)
+	config, err := ConfigFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Endpoints) != 2 || config.Endpoints[1] != "QmB" {
+		t.Fatalf("endpoints %v, want the two of ENDPOINTS_JSON", config.Endpoints)
+	}
+
+	// A typo must not fall back to the default endpoints
+	t.Setenv("ENDPOINTS_JSON", `["QmA", "QmB"`)
+	if _, err := ConfigFromEnvironment(); err == nil || !strings.Contains(err.Error(), "ENDPOINTS_JSON") {
+		t.Fatalf("ConfigFromEnvironment returned %v, want an error naming ENDPOINTS_JSON", err)
+	}
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/leader"
+)
+
+// newElector creates the leader elector of the configured election method
+func newElector(config Config, prefix string) (leader.Elector, error) {
+	group := config.LeaderGroup
+	if group == "" {
+		group = prefix + "-leader"
+	}
+
+	switch config.LeaderElection {
+	case "", leader.MethodNone:
+		return leader.Standalone{}, nil
+	case leader.MethodFile:
+		path := config.LeaderLockFile
+		if path == "" {
+			path = filepath.Join(config.OutputDir, "leader.lock")
+		}
+		return leader.NewFileLock(leader.FileLockConfig{
+			Path:          path,
+			RetryInterval: config.LeaderRetryInterval,
+		})
+	case leader.MethodKafka:
+		topic := config.LeaderTopic
+		if topic == "" {
+			topic = prefix + "_leader"
+		}
+		return leader.NewKafkaGroup(leader.KafkaGroupConfig{
+			Brokers:           config.KafkaBrokers,
+			GroupID:           group,
+			Topic:             topic,
+			ReplicationFactor: config.KafkaTopicReplication,
+			SessionTimeout:    config.LeaderSessionTimeout,
+		})
+	case leader.MethodPostgres:
+		return leader.NewPostgresLock(leader.PostgresLockConfig{
+			DSN:           config.LeaderPostgresDSN,
+			Name:          group,
+			Key:           config.LeaderLockKey,
+			RetryInterval: config.LeaderRetryInterval,
+			CheckInterval: config.LeaderSessionTimeout,
+		})
+	default:
+		return nil, fmt.Errorf("unknown leader election method %q", config.LeaderElection)
+	}
+}
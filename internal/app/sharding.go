@@ -0,0 +1,53 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/leader"
+	"github.com/panoramablock/thegraph-data-extraction/internal/adapters/sharding"
+)
+
+// newCoordinator creates the shard coordinator of the configured sharding mode
+func newCoordinator(config Config, prefix string) (sharding.Coordinator, error) {
+	if config.Sharding != "" && config.Sharding != sharding.ModeNone &&
+		config.LeaderElection != "" && config.LeaderElection != leader.MethodNone {
+		return nil, fmt.Errorf("sharding and leader election cannot be combined, every shard member schedules its own tasks")
+	}
+
+	name := config.ShardID
+	if name == "" {
+		name = leader.Identity()
+	}
+
+	switch config.Sharding {
+	case "", sharding.ModeNone:
+		return sharding.NewStandalone(), nil
+	case sharding.ModeStatic:
+		return sharding.NewStatic(sharding.StaticConfig{
+			Members: config.ShardMembers,
+			Name:    name,
+		})
+	case sharding.ModeKafka:
+		group := config.ShardGroup
+		if group == "" {
+			group = prefix + "-shards"
+		}
+		topic := config.ShardTopic
+		if topic == "" {
+			topic = prefix + "_shards"
+		}
+		return sharding.NewKafkaGroup(sharding.KafkaGroupConfig{
+			Brokers:           config.KafkaBrokers,
+			GroupID:           group,
+			Topic:             topic,
+			Name:              name,
+			Slots:             config.ShardSlots,
+			ReplicationFactor: config.KafkaTopicReplication,
+			SessionTimeout:    config.LeaderSessionTimeout,
+			HandoffTimeout:    config.ShardHandoffTimeout,
+			RetryInterval:     config.LeaderRetryInterval,
+		})
+	default:
+		return nil, fmt.Errorf("unknown sharding mode %q", config.Sharding)
+	}
+}
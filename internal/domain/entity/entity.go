@@ -7,13 +7,38 @@ import (
 
 // Entity represents a generic entity with ID and metadata
 type Entity struct {
-	ID          string                 `json:"id"`
-	Type        string                 `json:"type"`
-	Deployment  string                 `json:"deployment"`
-	Timestamp   time.Time              `json:"timestamp"`
-	Cursor      string                 `json:"cursor,omitempty"`
-	Data        map[string]interface{} `json:"data"`
-	MetaData    map[string]interface{} `json:"meta_data,omitempty"`
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Deployment string                 `json:"deployment"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Cursor     string                 `json:"cursor,omitempty"`
+	Data       map[string]interface{} `json:"data"`
+	MetaData   map[string]interface{} `json:"meta_data,omitempty"`
+}
+
+// Page represents a single page of entities produced by a paginated extraction
+type Page struct {
+	Endpoint   string    `json:"endpoint"`
+	QueryType  string    `json:"query_type"`
+	Number     int       `json:"number"`
+	Cursor     string    `json:"cursor,omitempty"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Entities   []*Entity `json:"entities"`
+	FetchedAt  time.Time `json:"fetched_at"`
+
+	// Block is the block the subgraph was indexed up to when the page was fetched
+	Block int64 `json:"block,omitempty"`
+}
+
+// DeadLetter represents a message that could not be published, kept for later replay
+type DeadLetter struct {
+	Topic    string            `json:"topic"`
+	Key      string            `json:"key"`
+	Payload  []byte            `json:"payload"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Error    string            `json:"error"`
+	Attempts int               `json:"attempts"`
+	FailedAt time.Time         `json:"failed_at"`
 }
 
 // GraphResponse represents the raw response from TheGraph API
@@ -24,9 +49,9 @@ type GraphResponse struct {
 
 // GraphError represents errors returned by TheGraph API
 type GraphError struct {
-	Message   string                 `json:"message"`
-	Locations []GraphErrorLocation   `json:"locations,omitempty"`
-	Path      []string               `json:"path,omitempty"`
+	Message    string                 `json:"message"`
+	Locations  []GraphErrorLocation   `json:"locations,omitempty"`
+	Path       []string               `json:"path,omitempty"`
 	Extensions map[string]interface{} `json:"extensions,omitempty"`
 }
 
@@ -56,4 +81,4 @@ func MarshalJSON(v interface{}) ([]byte, error) {
 // UnmarshalJSON unmarshals JSON data into the given interface
 func UnmarshalJSON(data []byte, v interface{}) error {
 	return json.Unmarshal(data, v)
-} 
\ No newline at end of file
+}
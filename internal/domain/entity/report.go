@@ -0,0 +1,56 @@
+package entity
+
+import "time"
+
+// RunReport is the structured report of an extraction run
+type RunReport struct {
+	RunStatus
+
+	// Tasks reports every endpoint and query type extracted by the run
+	Tasks []TaskReport `json:"tasks"`
+}
+
+// TaskReport reports the extraction of one query type from one endpoint within a run
+type TaskReport struct {
+	Endpoint   string    `json:"endpoint"`
+	QueryType  string    `json:"queryType"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Priority   int       `json:"priority"`
+
+	Pages    int `json:"pages"`
+	Entities int `json:"entities"`
+	Retries  int `json:"retries"`
+
+	// Errors counts the entities that could not be published plus the error
+	// that stopped the extraction, if any; Error is the first of them
+	Errors int    `json:"errors"`
+	Error  string `json:"error,omitempty"`
+
+	CursorBefore string `json:"cursorBefore"`
+	CursorAfter  string `json:"cursorAfter"`
+
+	// Block is the block the subgraph was indexed up to when the last page was fetched
+	Block int64 `json:"block,omitempty"`
+
+	// Interrupted is set when a shutdown stopped the task before it finished
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// Entities returns the number of entities extracted by every task of the run
+func (r *RunReport) Entities() int {
+	total := 0
+	for _, task := range r.Tasks {
+		total += task.Entities
+	}
+	return total
+}
+
+// Errors returns the number of errors of every task of the run
+func (r *RunReport) Errors() int {
+	total := 0
+	for _, task := range r.Tasks {
+		total += task.Errors
+	}
+	return total
+}
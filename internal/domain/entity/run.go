@@ -0,0 +1,25 @@
+package entity
+
+import "context"
+
+// Metadata keys set on extracted entities
+const (
+	// MetaBlockNumber is the block the subgraph was indexed up to when the entity was fetched
+	MetaBlockNumber = "block_number"
+	// MetaRunID identifies the extraction run that produced the entity
+	MetaRunID = "run_id"
+)
+
+// runIDKey is the context key of the extraction run ID
+type runIDKey struct{}
+
+// WithRunID returns a context carrying the ID of an extraction run
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the extraction run ID carried by a context, if any
+func RunIDFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
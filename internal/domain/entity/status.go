@@ -0,0 +1,74 @@
+package entity
+
+import "time"
+
+// Task states reported by TaskStatus
+const (
+	TaskIdle      = "idle"
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+
+	// TaskInterrupted is reported for tasks stopped by a shutdown
+	TaskInterrupted = "interrupted"
+)
+
+// TaskStatus describes the extraction of one query type from one endpoint
+type TaskStatus struct {
+	Endpoint  string `json:"endpoint"`
+	QueryType string `json:"queryType"`
+
+	// Cursor is the checkpoint the next extraction resumes from
+	Cursor string `json:"cursor"`
+
+	// Schedule and Priority are the cron schedule the task runs on and its
+	// priority when waiting for workers and the rate budget
+	Schedule string `json:"schedule"`
+	Priority int    `json:"priority"`
+
+	// Owned is false when the task is extracted by another instance
+	Owned bool `json:"owned"`
+
+	State          string    `json:"state"`
+	LastStartedAt  time.Time `json:"lastStartedAt"`
+	LastFinishedAt time.Time `json:"lastFinishedAt"`
+	LastSuccessAt  time.Time `json:"lastSuccessAt"`
+	LastError      string    `json:"lastError,omitempty"`
+
+	// Entities is the number of entities extracted by the last extraction
+	Entities int `json:"entities"`
+}
+
+// InterruptedTask records a task stopped by a shutdown before it finished
+type InterruptedTask struct {
+	Endpoint  string `json:"endpoint"`
+	QueryType string `json:"queryType"`
+	RunID     string `json:"runId"`
+
+	// Cursor is the checkpoint of the last page the task completed, which
+	// the next extraction resumes from
+	Cursor string `json:"cursor"`
+
+	// Pages is the number of pages completed before the interruption
+	Pages         int       `json:"pages"`
+	InterruptedAt time.Time `json:"interruptedAt"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// RunStatus describes an extraction run over every endpoint and query type
+type RunStatus struct {
+	ID         string    `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Running reports whether the run has not finished yet
+func (r RunStatus) Running() bool {
+	return r.FinishedAt.IsZero()
+}
+
+// Succeeded reports whether the run finished without errors
+func (r RunStatus) Succeeded() bool {
+	return !r.Running() && r.Error == ""
+}
@@ -0,0 +1,88 @@
+package ports
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when extracting a single endpoint and query type
+var (
+	ErrUnknownTask = errors.New("unknown endpoint or query type")
+	ErrTaskRunning = errors.New("extraction already running")
+)
+
+// Errors returned while the extraction service is shutting down
+var (
+	ErrShuttingDown = errors.New("extraction service is shutting down")
+	ErrInterrupted  = errors.New("extraction interrupted by shutdown")
+)
+
+// Errors returned when tasks are sharded across instances
+var (
+	ErrNotOwner   = errors.New("task is owned by another instance")
+	ErrReassigned = errors.New("task reassigned to another instance")
+)
+
+// Errors returned by worker pools
+var (
+	ErrQueueFull   = errors.New("task queue is full")
+	ErrTaskTimeout = errors.New("task timed out")
+)
+
+// PanicError is returned for a task of a worker pool that panicked, with
+// the panic value and the stack trace of the panicking goroutine
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// Error describes the panic without its stack trace
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
+// Unwrap exposes a panic value that is an error to errors.Is and errors.As
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// ErrRunNotFound is returned when a run report does not exist
+var ErrRunNotFound = errors.New("run not found")
+
+// BatchError reports the per-message outcome of a batch publish.
+// Errors is aligned with the published batch: Errors[i] is nil when message i was written.
+type BatchError struct {
+	Errors []error
+}
+
+// Error summarizes the failed messages of the batch
+func (e *BatchError) Error() string {
+	failed := e.Failed()
+	if len(failed) == 0 {
+		return fmt.Sprintf("batch of %d messages published without errors", len(e.Errors))
+	}
+	return fmt.Sprintf("failed to publish %d of %d messages: %v", len(failed), len(e.Errors), e.Errors[failed[0]])
+}
+
+// Failed returns the indexes of the messages that could not be published
+func (e *BatchError) Failed() []int {
+	var failed []int
+	for i, err := range e.Errors {
+		if err != nil {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
+// Unwrap exposes the individual message errors to errors.Is and errors.As
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
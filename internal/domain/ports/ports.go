@@ -9,54 +9,120 @@ import (
 
 // GraphQLClient defines the interface for interacting with GraphQL APIs
 type GraphQLClient interface {
-	// Query executes a GraphQL query and returns the result
-	Query(ctx context.Context, query string, variables map[string]interface{}, response interface{}) error
-	
-	// SetEndpoint configures the client to use a specific endpoint
-	SetEndpoint(endpoint string)
+	// Query executes a GraphQL query against an endpoint and returns the
+	// result. It is safe to call concurrently for different endpoints.
+	Query(ctx context.Context, endpoint, query string, variables map[string]interface{}, response interface{}) error
 }
 
 // EventPublisher defines the interface for publishing events to a message bus
 type EventPublisher interface {
 	// PublishEntity publishes an entity to the message bus
 	PublishEntity(ctx context.Context, entity *entity.Entity, topic string) error
-	
+
 	// PublishRaw publishes raw data to the message bus
 	PublishRaw(ctx context.Context, key string, data []byte, topic string) error
-	
+
+	// PublishBatch publishes a batch of entities in a single write.
+	// Partial failures are reported as a *BatchError aligned with entities.
+	PublishBatch(ctx context.Context, entities []*entity.Entity, topic string) error
+
 	// Close closes the publisher connection
 	Close() error
 }
 
+// BatchRetrier is implemented by publishers that retry only the part of a
+// publish that failed, such as the sinks of a fan-out that failed
+type BatchRetrier interface {
+	// RetryBatch republishes entities that failed to publish. causes is aligned
+	// with entities and holds the errors of their last attempt, as reported by
+	// PublishBatch or RetryBatch. Failures are reported like PublishBatch does.
+	RetryBatch(ctx context.Context, entities []*entity.Entity, causes []error, topic string) error
+}
+
+// DeadLetterSink defines the interface for storing messages that could not be published
+type DeadLetterSink interface {
+	// Send stores a dead letter
+	Send(ctx context.Context, letter *entity.DeadLetter) error
+
+	// Replay hands every stored dead letter to publish, removing those it accepts.
+	// It returns the number of dead letters replayed successfully.
+	Replay(ctx context.Context, publish func(ctx context.Context, letter *entity.DeadLetter) error) (int, error)
+
+	// Close closes the sink
+	Close() error
+}
+
+// EventEncoder defines the interface for serializing entities into message payloads
+type EventEncoder interface {
+	// Encode serializes an entity for publishing to topic
+	Encode(ctx context.Context, entity *entity.Entity, topic string) ([]byte, error)
+
+	// ContentType returns the MIME type of encoded payloads
+	ContentType() string
+}
+
 // Repository defines the interface for data persistence
 type Repository interface {
 	// SaveEntity saves an entity to the repository
 	SaveEntity(ctx context.Context, entity *entity.Entity) error
-	
+
 	// GetLatestCursor gets the latest cursor for a given entity type and deployment
 	GetLatestCursor(ctx context.Context, entityType, deployment string) (string, error)
-	
+
+	// SaveCursor durably stores the cursor to resume from for an entity type and deployment
+	SaveCursor(ctx context.Context, entityType, deployment, cursor string) error
+
+	// SaveInterrupted records the tasks interrupted by a shutdown, replacing
+	// the previous record. An empty list clears it.
+	SaveInterrupted(ctx context.Context, tasks []entity.InterruptedTask) error
+
+	// GetInterrupted returns the tasks recorded by the last SaveInterrupted
+	GetInterrupted(ctx context.Context) ([]entity.InterruptedTask, error)
+
+	// Flush waits for pending writes and makes stored cursors durable
+	Flush(ctx context.Context) error
+
 	// Close closes the repository connection
 	Close() error
 }
 
+// RunStore defines the interface for persisting the reports of extraction runs
+type RunStore interface {
+	// SaveRun stores the report of a finished run
+	SaveRun(ctx context.Context, report *entity.RunReport) error
+
+	// ListRuns returns the most recent reports, newest first. All reports are
+	// returned when limit is not positive.
+	ListRuns(ctx context.Context, limit int) ([]*entity.RunReport, error)
+
+	// GetRun returns the report of a run, or ErrRunNotFound
+	GetRun(ctx context.Context, runID string) (*entity.RunReport, error)
+}
+
+// PageHandler receives each page produced by a streaming extraction.
+// The extraction does not move past a page until its handler returns.
+type PageHandler func(ctx context.Context, page *entity.Page) error
+
 // ExtractionService defines the interface for the core extraction logic
 type ExtractionService interface {
 	// ExtractEntities extracts entities from a given endpoint and query type
 	ExtractEntities(ctx context.Context, endpoint, queryType string) ([]*entity.Entity, error)
-	
+
 	// ExtractAll extracts all configured entity types from all endpoints
 	ExtractAll(ctx context.Context) error
-	
+
 	// ExtractWithDelta extracts only new entities since the last extraction
 	ExtractWithDelta(ctx context.Context, endpoint, queryType, cursor string) ([]*entity.Entity, error)
+
+	// StreamEntities extracts entities page by page starting at cursor, handing each page to handler
+	StreamEntities(ctx context.Context, endpoint, queryType, cursor string, handler PageHandler) error
 }
 
 // QueryGenerator defines the interface for generating GraphQL queries
 type QueryGenerator interface {
 	// GenerateQuery generates a GraphQL query for a given endpoint and type
 	GenerateQuery(endpoint, queryType string) string
-	
+
 	// GeneratePaginatedQuery generates a paginated query with cursor
 	GeneratePaginatedQuery(endpoint, queryType, cursor string, first int) string
 }
@@ -65,25 +131,73 @@ type QueryGenerator interface {
 type RateLimiter interface {
 	// Wait blocks until a request is allowed according to rate limits
 	Wait(ctx context.Context) error
-	
+
 	// Done signals that a request has completed
 	Done(success bool, latency time.Duration)
-	
+
 	// UpdateRateLimit updates the rate limit based on API response
 	UpdateRateLimit(rateLimit, remaining int, resetAt time.Time)
 }
 
-// WorkerPool defines the interface for managing a dynamic pool of workers
+// WorkerPool defines the interface for managing a dynamic pool of workers.
+// A task that panics fails with a *PanicError instead of crashing the process.
 type WorkerPool interface {
 	// Submit submits a task to the worker pool
 	Submit(task func() error) error
-	
-	// Wait waits for all tasks to complete
+
+	// SubmitCtx submits a task, giving up when ctx is done before the
+	// pool has room for it
+	SubmitCtx(ctx context.Context, task func() error) error
+
+	// TrySubmit submits a task without waiting; it returns ErrQueueFull
+	// when the pool has no room for it
+	TrySubmit(task func() error) error
+
+	// SubmitClass submits a task of a class like SubmitCtx. Classes share
+	// the workers fairly, so the tasks of one class cannot hold back the others.
+	SubmitClass(ctx context.Context, class string, task func() error) error
+
+	// Group creates a batch of tasks sharing the workers of the pool, with
+	// its own wait and errors. With cancelOnError, the first task error
+	// cancels the context of the group's tasks. The context of each task
+	// carries the pool's task timeout, if any.
+	Group(ctx context.Context, cancelOnError bool) TaskGroup
+
+	// Wait waits for all tasks of every caller to complete
 	Wait() error
-	
+
 	// SetPoolSize dynamically adjusts the worker pool size
 	SetPoolSize(size int)
-	
+
 	// Close shuts down the worker pool
 	Close() error
-} 
\ No newline at end of file
+}
+
+// TaskGroup is a batch of tasks run by a worker pool
+type TaskGroup interface {
+	// Go submits a task of a class. It returns the submission error only;
+	// the task's error is returned by Wait.
+	Go(class string, task func(ctx context.Context) error) error
+
+	// Wait waits for the tasks of the group and returns their errors joined
+	Wait() error
+}
+
+// Metrics defines the interface for recording extraction metrics
+type Metrics interface {
+	// ObserveQuery records a GraphQL query attempt and its outcome
+	ObserveQuery(endpoint, queryType string, duration time.Duration, err error)
+
+	// RecordRetry records a retried GraphQL query
+	RecordRetry(endpoint, queryType string)
+
+	// RecordEntities records entities extracted from a page and how many of them were
+	// published, dead-lettered or lost
+	RecordEntities(endpoint, queryType string, extracted, published, deadLettered, lost int)
+
+	// RecordCheckpoint records that the cursor of an endpoint and query type was saved
+	RecordCheckpoint(endpoint, queryType string)
+
+	// RecordRun records the outcome of an extraction run
+	RecordRun(duration time.Duration, err error)
+}
@@ -0,0 +1,231 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/queries"
+)
+
+// Key strategies supported by the router
+const (
+	// KeyEntityID keys messages by entity ID
+	KeyEntityID = "entity-id"
+	// KeyPoolID keys messages by the pool the entity belongs to, keeping per-pool ordering
+	KeyPoolID = "pool-id"
+	// KeyTokenAddress keys messages by the token the entity refers to
+	KeyTokenAddress = "token-address"
+	// KeyEndpointQuery keys messages by endpoint and query type
+	KeyEndpointQuery = "endpoint-query"
+)
+
+// DefaultTopicTemplate is the topic naming used when no template is configured
+const DefaultTopicTemplate = "{{.Prefix}}_{{.Alias}}_{{.QueryType}}"
+
+// Deployment holds the routing metadata of a subgraph deployment
+type Deployment struct {
+	Alias string `json:"alias"`
+	Chain string `json:"chain"`
+}
+
+// Config holds the routing configuration shared by all publishers
+type Config struct {
+	// Prefix is available to topic templates as {{.Prefix}}
+	Prefix string `json:"prefix"`
+
+	// TopicTemplate is a text/template rendered with TopicData
+	TopicTemplate string `json:"topic_template"`
+
+	// KeyStrategy is the default key strategy
+	KeyStrategy string `json:"key_strategy"`
+
+	// KeyStrategies overrides the key strategy per query type
+	KeyStrategies map[string]string `json:"key_strategies"`
+
+	// Partitions pins message keys to explicit partitions
+	Partitions map[string]int `json:"partitions"`
+
+	// Deployments holds aliases and chains keyed by deployment ID
+	Deployments map[string]Deployment `json:"deployments"`
+
+	// DefaultChain is used for deployments without a configured chain
+	DefaultChain string `json:"default_chain"`
+}
+
+// TopicData is the data available to topic templates
+type TopicData struct {
+	Prefix     string
+	Endpoint   string
+	EndpointID string
+	Alias      string
+	Chain      string
+	QueryType  string
+}
+
+// Router resolves topics, keys and partitions for published entities
+type Router struct {
+	config   Config
+	template *template.Template
+}
+
+// LoadConfig reads a routing configuration from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("error reading routing config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("error parsing routing config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// NewRouter creates a new router
+func NewRouter(config Config) (*Router, error) {
+	// Set defaults for configuration
+	if config.TopicTemplate == "" {
+		config.TopicTemplate = DefaultTopicTemplate
+	}
+	if config.KeyStrategy == "" {
+		config.KeyStrategy = KeyEntityID
+	}
+	if config.DefaultChain == "" {
+		config.DefaultChain = "avax"
+	}
+
+	for _, strategy := range append([]string{config.KeyStrategy}, values(config.KeyStrategies)...) {
+		if !validKeyStrategy(strategy) {
+			return nil, fmt.Errorf("unknown key strategy %q", strategy)
+		}
+	}
+	for key, partition := range config.Partitions {
+		if partition < 0 {
+			return nil, fmt.Errorf("invalid partition %d for key %q", partition, key)
+		}
+	}
+
+	tmpl, err := template.New("topic").Option("missingkey=error").Parse(config.TopicTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic template: %w", err)
+	}
+
+	return &Router{
+		config:   config,
+		template: tmpl,
+	}, nil
+}
+
+// Topic returns the topic for an endpoint and query type
+func (r *Router) Topic(endpoint, queryType string) (string, error) {
+	var b strings.Builder
+	if err := r.template.Execute(&b, r.topicData(endpoint, queryType)); err != nil {
+		return "", fmt.Errorf("error rendering topic for %s on %s: %w", queryType, endpoint, err)
+	}
+	return b.String(), nil
+}
+
+// topicData builds the template data for an endpoint and query type
+func (r *Router) topicData(endpoint, queryType string) TopicData {
+	data := TopicData{
+		Prefix:     r.config.Prefix,
+		Endpoint:   endpoint,
+		EndpointID: queries.GetEndpointID(endpoint),
+		QueryType:  queryType,
+	}
+
+	deployment := r.config.Deployments[endpoint]
+	data.Alias = deployment.Alias
+	if data.Alias == "" {
+		data.Alias = data.EndpointID
+	}
+	data.Chain = deployment.Chain
+	if data.Chain == "" {
+		data.Chain = r.config.DefaultChain
+	}
+
+	return data
+}
+
+// Key returns the message key of an entity according to its query type's key strategy.
+// Entities without the field a strategy needs fall back to their ID.
+func (r *Router) Key(e *entity.Entity) string {
+	strategy := r.config.KeyStrategy
+	if override, ok := r.config.KeyStrategies[e.Type]; ok {
+		strategy = override
+	}
+
+	var key string
+	switch strategy {
+	case KeyPoolID:
+		if e.Type == "pools" {
+			return e.ID
+		}
+		key = referenceID(e.Data, "pool")
+	case KeyTokenAddress:
+		if e.Type == "tokens" {
+			return e.ID
+		}
+		key = referenceID(e.Data, "token", "tokenAddress", "token0")
+	case KeyEndpointQuery:
+		key = r.RawKey(e.Deployment, e.Type)
+	}
+
+	if key == "" {
+		key = e.ID
+	}
+	return key
+}
+
+// RawKey returns the key for payloads that are not individual entities
+func (r *Router) RawKey(endpoint, queryType string) string {
+	return fmt.Sprintf("%s-%s", queries.GetEndpointID(endpoint), queryType)
+}
+
+// Partition returns the partition a key is pinned to, if any
+func (r *Router) Partition(key string) (int, bool) {
+	partition, ok := r.config.Partitions[key]
+	return partition, ok
+}
+
+// referenceID returns the ID referenced by the first present field, which may
+// be a plain string or a nested object with an id
+func referenceID(data map[string]interface{}, fields ...string) string {
+	for _, field := range fields {
+		switch value := data[field].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case map[string]interface{}:
+			if id, ok := value["id"].(string); ok && id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// validKeyStrategy reports whether a key strategy is supported
+func validKeyStrategy(strategy string) bool {
+	switch strategy {
+	case KeyEntityID, KeyPoolID, KeyTokenAddress, KeyEndpointQuery:
+		return true
+	}
+	return false
+}
+
+// values returns the values of a string map
+func values(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
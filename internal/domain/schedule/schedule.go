@@ -0,0 +1,223 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// DefaultCron is the schedule used when no default is configured
+const DefaultCron = "*/5 * * * *"
+
+// DefaultJob names the job running the tasks on the default schedule
+const DefaultJob = "extraction"
+
+// Wildcard matches any endpoint or query type in a rule, like an empty field
+const Wildcard = "*"
+
+// Rule sets the schedule and priority of the tasks it matches
+type Rule struct {
+	// Endpoint and QueryType select the tasks of the rule. An empty field
+	// or "*" matches any endpoint or query type.
+	Endpoint  string `json:"endpoint"`
+	QueryType string `json:"query_type"`
+
+	// Cron is the schedule of the matched tasks. When empty, the schedule
+	// of a less specific rule or the default schedule applies.
+	Cron string `json:"cron"`
+
+	// Priority orders tasks waiting for a worker or for the rate budget;
+	// higher priorities go first
+	Priority int `json:"priority"`
+}
+
+// Config holds the schedules and priorities of the extraction tasks
+type Config struct {
+	// Default is the schedule of tasks no rule sets one for
+	Default string `json:"default"`
+
+	// Rules are matched from the most to the least specific: endpoint and
+	// query type, then query type, then endpoint, then neither. The most
+	// specific matching rule sets the priority; the schedule comes from the
+	// most specific matching rule that has one.
+	Rules []Rule `json:"rules"`
+}
+
+// Task is the extraction of a query type from an endpoint at a priority
+type Task struct {
+	Endpoint  string `json:"endpoint"`
+	QueryType string `json:"queryType"`
+	Priority  int    `json:"priority"`
+}
+
+// Job is a set of tasks sharing a schedule
+type Job struct {
+	// Name identifies the job in logs and metrics: DefaultJob for the
+	// default schedule, the cron expression otherwise
+	Name string
+
+	Cron string
+
+	// Tasks are ordered by priority, highest first
+	Tasks []Task
+}
+
+// Planner resolves the schedule and priority of tasks. A nil planner puts
+// every task on DefaultCron at priority zero.
+type Planner struct {
+	config Config
+}
+
+// LoadConfig reads a schedule configuration from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("error reading schedule config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("error parsing schedule config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// NewPlanner creates a new planner
+func NewPlanner(config Config) (*Planner, error) {
+	// Set defaults for configuration
+	if config.Default == "" {
+		config.Default = DefaultCron
+	}
+
+	rules := make([]Rule, len(config.Rules))
+	seen := make(map[[2]string]bool, len(config.Rules))
+	for i, rule := range config.Rules {
+		if rule.Endpoint == Wildcard {
+			rule.Endpoint = ""
+		}
+		if rule.QueryType == Wildcard {
+			rule.QueryType = ""
+		}
+
+		selector := [2]string{rule.Endpoint, rule.QueryType}
+		if seen[selector] {
+			return nil, fmt.Errorf("duplicate schedule rule for endpoint %q and query type %q", rule.Endpoint, rule.QueryType)
+		}
+		seen[selector] = true
+		rules[i] = rule
+	}
+	config.Rules = rules
+
+	return &Planner{config: config}, nil
+}
+
+// Default returns the default schedule
+func (p *Planner) Default() string {
+	if p == nil {
+		return DefaultCron
+	}
+	return p.config.Default
+}
+
+// Resolve returns the schedule and priority of a query type on an endpoint
+func (p *Planner) Resolve(endpoint, queryType string) (cron string, priority int) {
+	rules := p.match(endpoint, queryType)
+	if len(rules) > 0 {
+		priority = rules[0].Priority
+	}
+	for _, rule := range rules {
+		if rule.Cron != "" {
+			return rule.Cron, priority
+		}
+	}
+	return p.Default(), priority
+}
+
+// Priority returns the priority of a query type on an endpoint
+func (p *Planner) Priority(endpoint, queryType string) int {
+	_, priority := p.Resolve(endpoint, queryType)
+	return priority
+}
+
+// Plan groups tasks into one job per schedule, setting their priorities.
+// The default job comes first, followed by the others ordered by schedule.
+func (p *Planner) Plan(tasks []Task) []Job {
+	byCron := make(map[string]*Job)
+	var crons []string
+	for _, task := range tasks {
+		cron, priority := p.Resolve(task.Endpoint, task.QueryType)
+		task.Priority = priority
+
+		job, ok := byCron[cron]
+		if !ok {
+			job = &Job{Name: cron, Cron: cron}
+			if cron == p.Default() {
+				job.Name = DefaultJob
+			}
+			byCron[cron] = job
+			crons = append(crons, cron)
+		}
+		job.Tasks = append(job.Tasks, task)
+	}
+
+	sort.Slice(crons, func(i, j int) bool {
+		if (crons[i] == p.Default()) != (crons[j] == p.Default()) {
+			return crons[i] == p.Default()
+		}
+		return crons[i] < crons[j]
+	})
+
+	jobs := make([]Job, 0, len(crons))
+	for _, cron := range crons {
+		job := byCron[cron]
+		SortTasks(job.Tasks)
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// SortTasks orders tasks by priority, highest first, keeping the order of equal priorities
+func SortTasks(tasks []Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].Priority > tasks[j].Priority
+	})
+}
+
+// match returns the rules matching a query type on an endpoint, most specific first
+func (p *Planner) match(endpoint, queryType string) []Rule {
+	if p == nil {
+		return nil
+	}
+
+	var rules []Rule
+	for _, rule := range p.config.Rules {
+		if rule.Endpoint != "" && rule.Endpoint != endpoint {
+			continue
+		}
+		if rule.QueryType != "" && rule.QueryType != queryType {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	// A query type is more specific than an endpoint, so that a rule for
+	// swaps applies to the swaps of every endpoint unless one overrides it
+	sort.SliceStable(rules, func(i, j int) bool {
+		return specificity(rules[i]) > specificity(rules[j])
+	})
+	return rules
+}
+
+// specificity ranks how narrowly a rule selects tasks
+func specificity(rule Rule) int {
+	score := 0
+	if rule.QueryType != "" {
+		score += 2
+	}
+	if rule.Endpoint != "" {
+		score++
+	}
+	return score
+}
@@ -2,15 +2,20 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
 	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/schedule"
 )
 
 // ExtractionService implements the core extraction logic
@@ -21,12 +26,28 @@ type ExtractionService struct {
 	queryGenerator ports.QueryGenerator
 	rateLimiter    ports.RateLimiter
 	workerPool     ports.WorkerPool
-	
-	endpoints      []string
-	queryTypes     []string
-	pageSize       int
-	maxRetries     int
-	retryDelay     time.Duration
+	router         *routing.Router
+	deadLetters    ports.DeadLetterSink
+	metrics        ports.Metrics
+	runs           ports.RunStore
+	planner        *schedule.Planner
+	status         statusTracker
+	life           *lifecycle
+	shards         *ownership
+	queue          taskQueue
+	rateGate       priorityGate
+
+	endpoints     []string
+	queryTypes    []string
+	pageSize      int
+	maxRetries    int
+	retryDelay    time.Duration
+	pipelineDepth int
+
+	publishRetries int
+	publishDelay   time.Duration
+	reportTopic    string
+	taskClasses    string
 }
 
 // ExtractionConfig holds the configuration for the extraction service
@@ -34,6 +55,28 @@ type ExtractionConfig struct {
 	PageSize   int
 	MaxRetries int
 	RetryDelay time.Duration
+
+	// PipelineDepth is the number of pages allowed in flight between the
+	// fetch and sink stages. With the default of 1 a page is handed off
+	// before the next one is fetched.
+	PipelineDepth int
+
+	// PublishRetries is how many times failed entities of a page are
+	// republished before they are sent to the dead letter sink
+	PublishRetries    int
+	PublishRetryDelay time.Duration
+
+	// ReportTopic receives the report of every run as a run summary event.
+	// Summaries are not published when empty.
+	ReportTopic string
+
+	// Schedule sets the schedule and priority of every task. Without one,
+	// every task runs on the default schedule at priority zero.
+	Schedule *schedule.Planner
+
+	// TaskClasses sets the classes sharing the worker pool fairly:
+	// ClassByEndpoint (default) or ClassByPriority
+	TaskClasses string
 }
 
 // NewExtractionService creates a new extraction service
@@ -45,6 +88,10 @@ func NewExtractionService(
 	queryGenerator ports.QueryGenerator,
 	rateLimiter ports.RateLimiter,
 	workerPool ports.WorkerPool,
+	router *routing.Router,
+	deadLetters ports.DeadLetterSink,
+	metrics ports.Metrics,
+	runs ports.RunStore,
 	endpoints []string,
 	queryTypes []string,
 	config ExtractionConfig,
@@ -58,7 +105,22 @@ func NewExtractionService(
 	if config.RetryDelay <= 0 {
 		config.RetryDelay = 5 * time.Second // Default retry delay
 	}
-	
+	if config.PipelineDepth <= 0 {
+		config.PipelineDepth = 1 // Default to one page in flight
+	}
+	if config.PublishRetries <= 0 {
+		config.PublishRetries = 2 // Default publish retries
+	}
+	if config.PublishRetryDelay <= 0 {
+		config.PublishRetryDelay = 1 * time.Second // Default publish retry delay
+	}
+	if config.TaskClasses == "" {
+		config.TaskClasses = ClassByEndpoint
+	}
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
 	return &ExtractionService{
 		client:         client,
 		publisher:      publisher,
@@ -66,98 +128,170 @@ func NewExtractionService(
 		queryGenerator: queryGenerator,
 		rateLimiter:    rateLimiter,
 		workerPool:     workerPool,
+		router:         router,
+		deadLetters:    deadLetters,
+		metrics:        metrics,
+		runs:           runs,
+		planner:        config.Schedule,
+		life:           newLifecycle(),
+		shards:         newOwnership(),
 		endpoints:      endpoints,
 		queryTypes:     queryTypes,
 		pageSize:       config.PageSize,
 		maxRetries:     config.MaxRetries,
 		retryDelay:     config.RetryDelay,
+		pipelineDepth:  config.PipelineDepth,
+		publishRetries: config.PublishRetries,
+		publishDelay:   config.PublishRetryDelay,
+		reportTopic:    config.ReportTopic,
+		taskClasses:    config.TaskClasses,
 	}
 }
 
-// ExtractAll extracts all configured entity types from all endpoints
+// ExtractAll extracts all configured entity types from all endpoints,
+// highest priority first
 func (s *ExtractionService) ExtractAll(ctx context.Context) error {
-	var wg sync.WaitGroup
+	return s.extractRun(ctx, s.tasks())
+}
+
+// ExtractTasks extracts a set of tasks as one run, highest priority first.
+// It returns ports.ErrUnknownTask when one of them is not configured.
+func (s *ExtractionService) ExtractTasks(ctx context.Context, tasks []schedule.Task) error {
+	for _, task := range tasks {
+		if !s.hasPair(taskKey{endpoint: task.Endpoint, queryType: task.QueryType}) {
+			return fmt.Errorf("%w: %s from %s", ports.ErrUnknownTask, task.QueryType, task.Endpoint)
+		}
+	}
+	return s.extractRun(ctx, tasks)
+}
+
+// Jobs returns the tasks of the service grouped by schedule
+func (s *ExtractionService) Jobs() []schedule.Job {
+	return s.planner.Plan(s.tasks())
+}
+
+// extractRun extracts tasks as one run and waits for all of them to finish.
+// Tasks are queued by priority, so that when workers are scarce the highest
+// priority tasks of every run in progress are started first.
+// It returns ports.ErrShuttingDown once the service is shutting down.
+func (s *ExtractionService) extractRun(ctx context.Context, tasks []schedule.Task) (err error) {
+	if !s.life.enter() {
+		return ports.ErrShuttingDown
+	}
+	defer s.life.leave()
+	ctx, release := s.life.bind(ctx)
+	defer release()
+
+	// Tag every entity of this run, unless the caller already started one
+	if entity.RunIDFromContext(ctx) == "" {
+		ctx = entity.WithRunID(ctx, uuid.New().String())
+	}
+	runID := entity.RunIDFromContext(ctx)
+
+	ctx, span := tracer.Start(ctx, "extraction.run", trace.WithAttributes(
+		attribute.String("run.id", runID),
+		attribute.Int("run.endpoints", len(s.endpoints)),
+		attribute.Int("run.query_types", len(s.queryTypes)),
+		attribute.Int("run.tasks", len(tasks)),
+	))
+	startTime := time.Now()
+	s.status.startRun(runID)
+	report := newRunReport(runID)
+	defer func() {
+		s.status.finishRun(err)
+		s.recordRun(ctx, report.finish(err))
+		s.metrics.RecordRun(time.Since(startTime), err)
+		endSpan(span, err)
+	}()
+	log.Info().
+		Str("runId", entity.RunIDFromContext(ctx)).
+		Int("tasks", len(tasks)).
+		Msg("Starting extraction run")
+
+	// The tasks of this run are waited for on their own, apart from the
+	// tasks other runs and on-demand extractions share the pool with
+	group := s.workerPool.Group(ctx, false)
 	var errMu sync.Mutex
 	var errs []error
-	
-	for _, endpoint := range s.endpoints {
-		for _, queryType := range s.queryTypes {
-			wg.Add(1)
-			
-			// Submit extraction task to worker pool
-			err := s.workerPool.Submit(func() error {
-				defer wg.Done()
-				
-				// Get the latest cursor to perform delta extraction
-				cursor, err := s.repository.GetLatestCursor(ctx, queryType, endpoint)
-				if err != nil {
-					log.Error().
-						Str("endpoint", endpoint).
-						Str("queryType", queryType).
-						Err(err).
-						Msg("Failed to get latest cursor")
-					// Continue with empty cursor (full extraction)
-					cursor = ""
-				}
-				
-				// Extract entities with delta if cursor exists
-				var entities []*entity.Entity
-				if cursor != "" {
-					entities, err = s.ExtractWithDelta(ctx, endpoint, queryType, cursor)
-				} else {
-					entities, err = s.ExtractEntities(ctx, endpoint, queryType)
-				}
-				
-				if err != nil {
-					errMu.Lock()
-					errs = append(errs, fmt.Errorf("error extracting %s from %s: %w", queryType, endpoint, err))
-					errMu.Unlock()
-					return err
-				}
-				
-				// Publish entities to message bus
-				topic := fmt.Sprintf("%s.%s", endpoint, queryType)
-				for _, e := range entities {
-					if err := s.publisher.PublishEntity(ctx, e, topic); err != nil {
-						log.Error().
-							Str("endpoint", endpoint).
-							Str("queryType", queryType).
-							Str("entityId", e.ID).
-							Err(err).
-							Msg("Failed to publish entity")
-						errMu.Lock()
-						errs = append(errs, fmt.Errorf("error publishing entity %s: %w", e.ID, err))
-						errMu.Unlock()
-					}
+
+	tasks = append([]schedule.Task(nil), tasks...)
+	schedule.SortTasks(tasks)
+	for _, task := range tasks {
+		key := taskKey{endpoint: task.Endpoint, queryType: task.QueryType}
+		endpoint, queryType := key.endpoint, key.queryType
+
+		// Leave the tasks of other instances to them
+		if !s.shards.owns(key) {
+			log.Debug().
+				Str("endpoint", endpoint).
+				Str("queryType", queryType).
+				Msg("Skipping pair owned by another instance")
+			continue
+		}
+
+		// Leave pairs alone while an on-demand extraction or another run is extracting them
+		if !s.status.begin(key) {
+			log.Warn().
+				Str("endpoint", endpoint).
+				Str("queryType", queryType).
+				Msg("Skipping pair already being extracted")
+			continue
+		}
+
+		priority := task.Priority
+
+		// Queue the extraction task for the worker pool
+		s.queue.submit(group, s.taskClass(task), priority, func(ctx context.Context) error {
+			// Release the pair before the pool recovers a panic into an error
+			defer func() {
+				if r := recover(); r != nil {
+					s.status.finish(key, 0, fmt.Errorf("extraction panicked: %v", r))
+					panic(r)
 				}
-				
+			}()
+
+			taskReport, publishErrs, err := s.extractTask(withPriority(ctx, priority), endpoint, queryType)
+			report.add(taskReport)
+
+			errMu.Lock()
+			errs = append(errs, publishErrs...)
+			errMu.Unlock()
+
+			statusErr := err
+			if statusErr == nil && len(publishErrs) > 0 {
+				statusErr = fmt.Errorf("%d entities could not be published: %w", len(publishErrs), publishErrs[0])
+			}
+			s.status.finish(key, taskReport.Entities, statusErr)
+
+			if errors.Is(err, ports.ErrReassigned) {
+				// The new owner resumes the task from its checkpoint
 				log.Info().
 					Str("endpoint", endpoint).
 					Str("queryType", queryType).
-					Int("entityCount", len(entities)).
-					Msg("Successfully extracted and published entities")
-					
+					Msg("Task handed over to another instance")
 				return nil
-			})
-			
+			}
 			if err != nil {
-				log.Error().
-					Str("endpoint", endpoint).
-					Str("queryType", queryType).
-					Err(err).
-					Msg("Failed to submit extraction task")
-				errMu.Lock()
-				errs = append(errs, fmt.Errorf("error submitting task for %s from %s: %w", queryType, endpoint, err))
-				errMu.Unlock()
+				return fmt.Errorf("error extracting %s from %s: %w", queryType, endpoint, err)
 			}
-		}
+			return nil
+		}, func(err error) {
+			log.Error().
+				Str("endpoint", endpoint).
+				Str("queryType", queryType).
+				Err(err).
+				Msg("Failed to submit extraction task")
+			err = fmt.Errorf("error submitting task for %s from %s: %w", queryType, endpoint, err)
+			s.status.finish(key, 0, err)
+			errMu.Lock()
+			errs = append(errs, err)
+			errMu.Unlock()
+		})
 	}
-	
-	// Wait for all extraction tasks to complete
-	if err := s.workerPool.Wait(); err != nil {
-		return fmt.Errorf("error waiting for worker pool completion: %w", err)
-	}
-	
+
+	// Wait for the extraction tasks of this run to complete
+	errs = append(errs, joinedErrors(group.Wait())...)
+
 	// Check if there were any errors
 	if len(errs) > 0 {
 		log.Error().
@@ -165,158 +299,184 @@ func (s *ExtractionService) ExtractAll(ctx context.Context) error {
 			Msg("Extraction completed with errors")
 		return fmt.Errorf("completed with %d errors", len(errs))
 	}
-	
+
 	log.Info().Msg("All data extracted and published successfully")
 	return nil
 }
 
-// ExtractEntities extracts entities from a given endpoint and query type
-func (s *ExtractionService) ExtractEntities(ctx context.Context, endpoint, queryType string) ([]*entity.Entity, error) {
-	// Generate initial query
-	query := s.queryGenerator.GenerateQuery(endpoint, queryType)
-	if query == "" {
-		return nil, fmt.Errorf("no query defined for %s on endpoint %s", queryType, endpoint)
+// joinedErrors returns the errors joined in err by errors.Join
+func joinedErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
 	}
-	
-	// Set client endpoint
-	s.client.SetEndpoint(endpoint)
-	
-	// Execute query with pagination
-	return s.executeQueryWithPagination(ctx, endpoint, queryType, query, "")
+	if err != nil {
+		return []error{err}
+	}
+	return nil
 }
 
-// ExtractWithDelta extracts only new entities since the last extraction
-func (s *ExtractionService) ExtractWithDelta(ctx context.Context, endpoint, queryType, cursor string) ([]*entity.Entity, error) {
-	// Generate paginated query with cursor
-	query := s.queryGenerator.GeneratePaginatedQuery(endpoint, queryType, cursor, s.pageSize)
-	if query == "" {
-		return nil, fmt.Errorf("no paginated query defined for %s on endpoint %s", queryType, endpoint)
+// extractTask extracts a query type from an endpoint, resuming from its cursor
+// and checkpointing after every page. It returns the report of the task, the
+// entities that could not be published and the error that stopped the extraction.
+// It returns ports.ErrReassigned when this instance does not own the task or
+// stopped it to hand it over.
+func (s *ExtractionService) extractTask(ctx context.Context, endpoint, queryType string) (report entity.TaskReport, publishErrs []error, err error) {
+	key := taskKey{endpoint: endpoint, queryType: queryType}
+	ctx, owned, ok := s.shards.start(ctx, key)
+	if !ok {
+		err = fmt.Errorf("%w: %s from %s", ports.ErrReassigned, queryType, endpoint)
+		return entity.TaskReport{Endpoint: endpoint, QueryType: queryType, Error: err.Error()}, nil, err
 	}
-	
-	// Set client endpoint
-	s.client.SetEndpoint(endpoint)
-	
-	// Execute query with pagination
-	return s.executeQueryWithPagination(ctx, endpoint, queryType, query, cursor)
-}
+	defer s.shards.finish(key, owned)
 
-// executeQueryWithPagination executes a query with pagination to retrieve all results
-func (s *ExtractionService) executeQueryWithPagination(
-	ctx context.Context,
-	endpoint, queryType, query, startCursor string,
-) ([]*entity.Entity, error) {
-	var allEntities []*entity.Entity
-	var currentCursor = startCursor
-	hasMore := true
-	
-	for hasMore {
-		// Rate limit the request
-		if err := s.rateLimiter.Wait(ctx); err != nil {
-			return nil, fmt.Errorf("rate limit error: %w", err)
+	ctx, span := tracer.Start(ctx, "extraction.task", trace.WithAttributes(
+		attribute.String("endpoint", endpoint),
+		attribute.String("query_type", queryType),
+	))
+	ctx, counters := withTaskCounters(ctx)
+
+	report = entity.TaskReport{
+		Endpoint:  endpoint,
+		QueryType: queryType,
+		StartedAt: time.Now().UTC(),
+		Priority:  priorityFromContext(ctx),
+	}
+	defer func() {
+		stopped := errors.Is(err, ports.ErrInterrupted) || ctx.Err() != nil
+
+		// Tasks handed over to another instance resume there from their checkpoint
+		if err != nil && stopped && !s.life.stopped() && s.shards.revoked(key) {
+			err = fmt.Errorf("%w: %w", ports.ErrReassigned, err)
+			report.Interrupted = true
 		}
-		
-		startTime := time.Now()
-		var response entity.GraphResponse
-		var err error
-		var success bool
-		
-		// Retry logic
-		for retry := 0; retry <= s.maxRetries; retry++ {
-			if retry > 0 {
-				log.Warn().
-					Str("endpoint", endpoint).
-					Str("queryType", queryType).
-					Int("retry", retry).
-					Err(err).
-					Msg("Retrying query")
-				time.Sleep(s.retryDelay)
-			}
-			
-			// Execute the query
-			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-			err = s.client.Query(ctx, query, nil, &response)
-			cancel()
-			
-			if err == nil {
-				success = true
-				break
+
+		// Record tasks stopped by a shutdown so the next start resumes them
+		if err != nil && stopped && s.life.stopped() {
+			if !errors.Is(err, ports.ErrInterrupted) {
+				err = fmt.Errorf("%w: %w", ports.ErrInterrupted, err)
 			}
+			report.Interrupted = true
+			s.life.interrupt(entity.InterruptedTask{
+				Endpoint:      endpoint,
+				QueryType:     queryType,
+				RunID:         entity.RunIDFromContext(ctx),
+				Cursor:        report.CursorAfter,
+				Pages:         report.Pages,
+				InterruptedAt: time.Now().UTC(),
+				Error:         err.Error(),
+			})
 		}
-		
-		// Report request completion to rate limiter
-		latency := time.Since(startTime)
-		s.rateLimiter.Done(success, latency)
-		
-		if !success {
-			return nil, fmt.Errorf("query failed after %d retries: %w", s.maxRetries, err)
+
+		report.FinishedAt = time.Now().UTC()
+		report.Retries = int(counters.retries.Load())
+		report.Errors = len(publishErrs)
+		if len(publishErrs) > 0 {
+			report.Error = publishErrs[0].Error()
 		}
-		
-		// Process the response into entities
-		entities, nextCursor, more := s.processResponse(endpoint, queryType, response.Data)
-		allEntities = append(allEntities, entities...)
-		
-		// Check if we have more pages
-		if !more || nextCursor == currentCursor || nextCursor == "" {
-			hasMore = false
-		} else {
-			currentCursor = nextCursor
-			query = s.queryGenerator.GeneratePaginatedQuery(endpoint, queryType, currentCursor, s.pageSize)
+		if err != nil {
+			report.Errors++
+			report.Error = err.Error()
 		}
+
+		span.SetAttributes(attribute.Int("entities", report.Entities))
+		endSpan(span, err)
+	}()
+
+	// Get the latest cursor to perform delta extraction
+	cursor, err := s.repository.GetLatestCursor(ctx, queryType, endpoint)
+	if err != nil {
+		log.Error().
+			Str("endpoint", endpoint).
+			Str("queryType", queryType).
+			Err(err).
+			Msg("Failed to get latest cursor")
+		// Continue with empty cursor (full extraction)
+		cursor = ""
 	}
-	
-	return allEntities, nil
-}
+	report.CursorBefore = cursor
+	report.CursorAfter = cursor
 
-// processResponse processes a GraphQL response into domain entities
-func (s *ExtractionService) processResponse(endpoint, queryType string, data map[string]interface{}) ([]*entity.Entity, string, bool) {
-	var entities []*entity.Entity
-	var nextCursor string
-	hasMore := false
-	
-	// Extract the data array for the query type
-	if data == nil {
-		return entities, nextCursor, hasMore
+	topic, err := s.router.Topic(endpoint, queryType)
+	if err != nil {
+		return report, nil, err
 	}
-	
-	if items, ok := data[queryType].([]interface{}); ok {
-		for _, item := range items {
-			if itemMap, ok := item.(map[string]interface{}); ok {
-				id, _ := itemMap["id"].(string)
-				if id == "" {
-					id = uuid.New().String()
-				}
-				
-				// Create entity
-				entity := &entity.Entity{
-					ID:         id,
-					Type:       queryType,
-					Deployment: endpoint,
-					Timestamp:  time.Now().UTC(),
-					Data:       itemMap,
-				}
-				
-				entities = append(entities, entity)
-				
-				// Extract cursor from the last item
-				if cursor, ok := itemMap["id"].(string); ok {
-					nextCursor = cursor
-				}
-			}
+
+	// Stream pages straight to the publisher, checkpointing after each one
+	err = s.StreamEntities(ctx, endpoint, queryType, cursor, func(ctx context.Context, page *entity.Page) error {
+		lost := s.publishPage(ctx, page, topic)
+		publishErrs = append(publishErrs, lost...)
+		report.Pages++
+		report.Entities += len(page.Entities)
+		if page.Block > 0 {
+			report.Block = page.Block
 		}
-	}
-	
-	// Check if there are more pages
-	if pageInfo, ok := data["pageInfo"].(map[string]interface{}); ok {
-		if hasNextPage, ok := pageInfo["hasNextPage"].(bool); ok {
-			hasMore = hasNextPage
+
+		// Keep the checkpoint before a page with entities that were neither
+		// published nor dead-lettered, so the next run extracts it again
+		if len(lost) > 0 {
+			return fmt.Errorf("%d entities of page %d were neither published nor dead-lettered, checkpoint kept: %w",
+				len(lost), page.Number, lost[0])
+		}
+
+		if err := s.repository.SaveCursor(ctx, queryType, endpoint, page.NextCursor); err != nil {
+			return fmt.Errorf("error saving cursor: %w", err)
 		}
-		if endCursor, ok := pageInfo["endCursor"].(string); ok && endCursor != "" {
-			nextCursor = endCursor
+		report.CursorAfter = page.NextCursor
+		s.metrics.RecordCheckpoint(endpoint, queryType)
+		return nil
+	})
+	if err != nil {
+		return report, publishErrs, err
+	}
+
+	log.Info().
+		Str("endpoint", endpoint).
+		Str("queryType", queryType).
+		Int("entityCount", report.Entities).
+		Msg("Successfully extracted and published entities")
+
+	return report, publishErrs, nil
+}
+
+// Topics returns the topics the service publishes to, one per endpoint and
+// query type, followed by the run summary topic
+func (s *ExtractionService) Topics() ([]string, error) {
+	pairs := s.pairs()
+	topics := make([]string, 0, len(pairs))
+	for _, key := range pairs {
+		topic, err := s.router.Topic(key.endpoint, key.queryType)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		// If we don't have explicit pageInfo, assume there's more if we got a full page
-		hasMore = len(entities) >= s.pageSize
+		topics = append(topics, topic)
+	}
+	if s.reportTopic != "" {
+		topics = append(topics, s.reportTopic)
 	}
-	
-	return entities, nextCursor, hasMore
-} 
\ No newline at end of file
+	return topics, nil
+}
+
+// ExtractEntities extracts entities from a given endpoint and query type.
+// All pages are collected in memory; use StreamEntities for large datasets.
+func (s *ExtractionService) ExtractEntities(ctx context.Context, endpoint, queryType string) ([]*entity.Entity, error) {
+	return s.collectEntities(ctx, endpoint, queryType, "")
+}
+
+// ExtractWithDelta extracts only new entities since the last extraction.
+// All pages are collected in memory; use StreamEntities for large datasets.
+func (s *ExtractionService) ExtractWithDelta(ctx context.Context, endpoint, queryType, cursor string) ([]*entity.Entity, error) {
+	return s.collectEntities(ctx, endpoint, queryType, cursor)
+}
+
+// collectEntities runs the streaming pipeline and accumulates every page
+func (s *ExtractionService) collectEntities(ctx context.Context, endpoint, queryType, cursor string) ([]*entity.Entity, error) {
+	var allEntities []*entity.Entity
+	err := s.StreamEntities(ctx, endpoint, queryType, cursor, func(ctx context.Context, page *entity.Page) error {
+		allEntities = append(allEntities, page.Entities...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allEntities, nil
+}
@@ -0,0 +1,17 @@
+package service
+
+import "time"
+
+// noopMetrics discards metrics when the service is created without a recorder
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveQuery(endpoint, queryType string, duration time.Duration, err error) {}
+
+func (noopMetrics) RecordRetry(endpoint, queryType string) {}
+
+func (noopMetrics) RecordEntities(endpoint, queryType string, extracted, published, deadLettered, lost int) {
+}
+
+func (noopMetrics) RecordCheckpoint(endpoint, queryType string) {}
+
+func (noopMetrics) RecordRun(duration time.Duration, err error) {}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/shard"
+)
+
+// ownership tracks the tasks this instance owns and the owned tasks in
+// progress, so that tasks reassigned to another instance stop before the
+// other instance takes their checkpoint over
+type ownership struct {
+	mu         sync.Mutex
+	assignment shard.Assignment
+	running    map[taskKey]*ownedTask
+}
+
+// ownedTask is an owned task in progress
+type ownedTask struct {
+	// stopped is set when the task should stop after its current page
+	stopped bool
+
+	// cancel stops the task right away; done is closed once it has returned
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// newOwnership creates an ownership of every task
+func newOwnership() *ownership {
+	return &ownership{
+		assignment: shard.All{},
+		running:    make(map[taskKey]*ownedTask),
+	}
+}
+
+// owns reports whether this instance owns a task
+func (o *ownership) owns(key taskKey) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.assignment.Owns(key.endpoint, key.queryType)
+}
+
+// start registers a task in progress and returns its context. It returns
+// false when the task is not owned.
+func (o *ownership) start(ctx context.Context, key taskKey) (context.Context, *ownedTask, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.assignment.Owns(key.endpoint, key.queryType) {
+		return ctx, nil, false
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	task := &ownedTask{cancel: cancel, done: make(chan struct{})}
+	o.running[key] = task
+	return ctx, task, true
+}
+
+// finish unregisters a task in progress
+func (o *ownership) finish(key taskKey, task *ownedTask) {
+	o.mu.Lock()
+	if o.running[key] == task {
+		delete(o.running, key)
+	}
+	o.mu.Unlock()
+
+	task.cancel(nil)
+	close(task.done)
+}
+
+// revoked reports whether a task in progress was reassigned
+func (o *ownership) revoked(key taskKey) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	task, ok := o.running[key]
+	return ok && task.stopped
+}
+
+// reassign replaces the assignment and asks the tasks in progress that are
+// no longer owned to stop. It returns those tasks.
+func (o *ownership) reassign(assignment shard.Assignment) []*ownedTask {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.assignment = assignment
+
+	var revoked []*ownedTask
+	for key, task := range o.running {
+		if assignment.Owns(key.endpoint, key.queryType) {
+			continue
+		}
+		task.stopped = true
+		revoked = append(revoked, task)
+	}
+	return revoked
+}
+
+// OwnedTasks returns the number of tasks this instance owns
+func (s *ExtractionService) OwnedTasks() int {
+	owned := 0
+	for _, key := range s.pairs() {
+		if s.shards.owns(key) {
+			owned++
+		}
+	}
+	return owned
+}
+
+// Reassign sets the tasks this instance owns. Runs skip the tasks it does
+// not own. Tasks in progress that are no longer owned stop once the page
+// they are on has been published and checkpointed; tasks still running
+// when ctx is done are cancelled. The checkpoint store is then flushed, so
+// that the new owner resumes from the last checkpoint once Reassign has
+// returned. It returns ctx's error when tasks had to be cancelled.
+func (s *ExtractionService) Reassign(ctx context.Context, assignment shard.Assignment) error {
+	revoked := s.shards.reassign(assignment)
+	if len(revoked) == 0 {
+		return nil
+	}
+	log.Info().
+		Int("tasks", len(revoked)).
+		Msg("Handing over reassigned tasks after their current page")
+
+	var err error
+wait:
+	for _, task := range revoked {
+		select {
+		case <-task.done:
+		case <-ctx.Done():
+			err = fmt.Errorf("reassigned tasks still running at the handover deadline: %w", ctx.Err())
+			break wait
+		}
+	}
+	if err != nil {
+		log.Warn().Msg("Handover deadline reached, cancelling reassigned tasks")
+		for _, task := range revoked {
+			task.cancel(ports.ErrReassigned)
+		}
+		grace := time.After(abortGrace)
+	abort:
+		for _, task := range revoked {
+			select {
+			case <-task.done:
+			case <-grace:
+				log.Error().Msg("Reassigned tasks did not stop after being cancelled")
+				break abort
+			}
+		}
+	}
+
+	// The new owner reads the checkpoints as soon as the handover is over
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortGrace)
+	defer cancel()
+	if flushErr := s.repository.Flush(flushCtx); flushErr != nil {
+		return errors.Join(err, fmt.Errorf("error flushing checkpoints of reassigned tasks: %w", flushErr))
+	}
+	return err
+}
@@ -0,0 +1,364 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// rawPage is a fetched page whose items have not been turned into entities yet
+type rawPage struct {
+	number     int
+	cursor     string
+	nextCursor string
+	items      []interface{}
+	block      int64
+	fetchedAt  time.Time
+}
+
+// errPaginationStopped ends the fetch stage between pages on shutdown
+var errPaginationStopped = errors.New("pagination stopped")
+
+// StreamEntities extracts entities page by page and hands each page to handler.
+//
+// The extraction runs as a three stage pipeline (fetch -> transform -> sink)
+// connected by bounded channels. At most pipelineDepth pages are in flight at
+// any time: the fetch stage takes a slot before requesting a page and the
+// sink releases it once handler has returned, so memory use is proportional
+// to the page size rather than the size of the dataset.
+//
+// Once the service is shutting down, or the task has been reassigned to
+// another instance, no further page is fetched; the pages already fetched
+// are still handed off and ports.ErrInterrupted is returned.
+func (s *ExtractionService) StreamEntities(
+	ctx context.Context,
+	endpoint, queryType, cursor string,
+	handler ports.PageHandler,
+) error {
+	query := s.queryGenerator.GeneratePaginatedQuery(endpoint, queryType, cursor, s.pageSize)
+	if query == "" {
+		return fmt.Errorf("no paginated query defined for %s on endpoint %s", queryType, endpoint)
+	}
+
+	// Upstream stages are cancelled on the first error; the sink keeps the
+	// caller's context so pages already fetched can still be handed off.
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	slots := make(chan struct{}, s.pipelineDepth)
+	rawPages := make(chan *rawPage, s.pipelineDepth)
+	pages := make(chan *entity.Page, s.pipelineDepth)
+
+	var wg sync.WaitGroup
+	var stopped bool
+	wg.Add(2)
+
+	// Fetch stage
+	go func() {
+		defer wg.Done()
+		defer close(rawPages)
+		err := s.fetchPages(fetchCtx, endpoint, queryType, query, cursor, slots, rawPages)
+		switch {
+		case errors.Is(err, errPaginationStopped):
+			stopped = true
+		case err != nil:
+			fail(err)
+		}
+	}()
+
+	// Transform stage
+	go func() {
+		defer wg.Done()
+		defer close(pages)
+		if err := s.transformPages(fetchCtx, endpoint, queryType, rawPages, pages); err != nil {
+			fail(err)
+		}
+	}()
+
+	// Sink stage runs on the caller's goroutine
+	for page := range pages {
+		if err := handler(ctx, page); err != nil {
+			fail(fmt.Errorf("error handling page %d: %w", page.Number, err))
+			break
+		}
+		<-slots
+
+		log.Debug().
+			Str("endpoint", endpoint).
+			Str("queryType", queryType).
+			Int("page", page.Number).
+			Int("entityCount", len(page.Entities)).
+			Str("nextCursor", page.NextCursor).
+			Msg("Page handed off")
+	}
+
+	wg.Wait()
+	if firstErr == nil && stopped {
+		return ports.ErrInterrupted
+	}
+	return firstErr
+}
+
+// fetchPages requests pages until the dataset is exhausted, sending each raw page downstream
+func (s *ExtractionService) fetchPages(
+	ctx context.Context,
+	endpoint, queryType, query, startCursor string,
+	slots chan<- struct{},
+	out chan<- *rawPage,
+) error {
+	currentCursor := startCursor
+
+	for number := 1; ; number++ {
+		// Stop between pages on shutdown or handover
+		if s.life.stopped() || s.shards.revoked(taskKey{endpoint: endpoint, queryType: queryType}) {
+			return errPaginationStopped
+		}
+
+		// Wait for a free slot so no more than pipelineDepth pages are in flight
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		data, err := s.fetchPage(ctx, endpoint, queryType, query)
+		if err != nil {
+			return err
+		}
+
+		items, nextCursor, more := s.pageItems(queryType, data)
+		page := &rawPage{
+			number:     number,
+			cursor:     currentCursor,
+			nextCursor: nextCursor,
+			items:      items,
+			block:      metaBlock(data),
+			fetchedAt:  time.Now().UTC(),
+		}
+		if page.nextCursor == "" {
+			page.nextCursor = currentCursor
+		}
+
+		select {
+		case out <- page:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// Check if we have more pages
+		if !more || nextCursor == currentCursor || nextCursor == "" {
+			return nil
+		}
+		currentCursor = nextCursor
+		query = s.queryGenerator.GeneratePaginatedQuery(endpoint, queryType, currentCursor, s.pageSize)
+	}
+}
+
+// transformPages converts raw pages into domain entity pages
+func (s *ExtractionService) transformPages(
+	ctx context.Context,
+	endpoint, queryType string,
+	in <-chan *rawPage,
+	out chan<- *entity.Page,
+) error {
+	for raw := range in {
+		page := &entity.Page{
+			Endpoint:   endpoint,
+			QueryType:  queryType,
+			Number:     raw.number,
+			Cursor:     raw.cursor,
+			NextCursor: raw.nextCursor,
+			Entities:   s.toEntities(endpoint, queryType, raw.items, raw.block, entity.RunIDFromContext(ctx)),
+			FetchedAt:  raw.fetchedAt,
+			Block:      raw.block,
+		}
+
+		select {
+		case out <- page:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// fetchPage executes a single page query with rate limiting and retries
+func (s *ExtractionService) fetchPage(ctx context.Context, endpoint, queryType, query string) (data map[string]interface{}, err error) {
+	ctx, span := tracer.Start(ctx, "extraction.fetch_page", trace.WithAttributes(
+		attribute.String("endpoint", endpoint),
+		attribute.String("query_type", queryType),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	// Rate limit the request
+	_, waitSpan := tracer.Start(ctx, "ratelimit.wait")
+	err = s.waitRateLimit(ctx)
+	endSpan(waitSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit error: %w", err)
+	}
+
+	startTime := time.Now()
+	var response entity.GraphResponse
+	var success bool
+
+	// Retry logic
+	for retry := 0; retry <= s.maxRetries; retry++ {
+		if retry > 0 {
+			log.Warn().
+				Str("endpoint", endpoint).
+				Str("queryType", queryType).
+				Int("retry", retry).
+				Err(err).
+				Msg("Retrying query")
+			s.metrics.RecordRetry(endpoint, queryType)
+			countRetry(ctx)
+			span.AddEvent("retry", trace.WithAttributes(
+				attribute.Int("retry", retry),
+				attribute.String("error", err.Error()),
+			))
+			select {
+			case <-time.After(s.retryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		// Execute the query
+		queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		attemptStart := time.Now()
+		err = s.client.Query(queryCtx, endpoint, query, nil, &response)
+		cancel()
+		s.metrics.ObserveQuery(endpoint, queryType, time.Since(attemptStart), err)
+
+		if err == nil {
+			success = true
+			break
+		}
+	}
+
+	// Report request completion to rate limiter
+	latency := time.Since(startTime)
+	s.rateLimiter.Done(success, latency)
+
+	if !success {
+		return nil, fmt.Errorf("query failed after %d retries: %w", s.maxRetries, err)
+	}
+
+	return response.Data, nil
+}
+
+// pageItems extracts the items of a page together with the cursor to continue from
+func (s *ExtractionService) pageItems(queryType string, data map[string]interface{}) ([]interface{}, string, bool) {
+	var nextCursor string
+	hasMore := false
+
+	if data == nil {
+		return nil, nextCursor, hasMore
+	}
+
+	items, _ := data[queryType].([]interface{})
+
+	// Extract cursor from the last item
+	for i := len(items) - 1; i >= 0; i-- {
+		if itemMap, ok := items[i].(map[string]interface{}); ok {
+			if cursor, ok := itemMap["id"].(string); ok {
+				nextCursor = cursor
+				break
+			}
+		}
+	}
+
+	// Check if there are more pages
+	if pageInfo, ok := data["pageInfo"].(map[string]interface{}); ok {
+		if hasNextPage, ok := pageInfo["hasNextPage"].(bool); ok {
+			hasMore = hasNextPage
+		}
+		if endCursor, ok := pageInfo["endCursor"].(string); ok && endCursor != "" {
+			nextCursor = endCursor
+		}
+	} else {
+		// If we don't have explicit pageInfo, assume there's more if we got a full page
+		hasMore = len(items) >= s.pageSize
+	}
+
+	return items, nextCursor, hasMore
+}
+
+// metaBlock returns the block number reported by the _meta field of a response
+func metaBlock(data map[string]interface{}) int64 {
+	meta, _ := data["_meta"].(map[string]interface{})
+	block, _ := meta["block"].(map[string]interface{})
+	switch number := block["number"].(type) {
+	case float64:
+		return int64(number)
+	case json.Number:
+		n, _ := number.Int64()
+		return n
+	case string:
+		n, _ := strconv.ParseInt(number, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// toEntities converts the raw items of a page into domain entities, stamping
+// them with the indexed block and the extraction run when known
+func (s *ExtractionService) toEntities(endpoint, queryType string, items []interface{}, block int64, runID string) []*entity.Entity {
+	entities := make([]*entity.Entity, 0, len(items))
+
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		id, _ := itemMap["id"].(string)
+		if id == "" {
+			id = uuid.New().String()
+		}
+
+		e := &entity.Entity{
+			ID:         id,
+			Type:       queryType,
+			Deployment: endpoint,
+			Timestamp:  time.Now().UTC(),
+			Data:       itemMap,
+		}
+		if block > 0 || runID != "" {
+			e.MetaData = make(map[string]interface{}, 2)
+			if block > 0 {
+				e.MetaData[entity.MetaBlockNumber] = block
+			}
+			if runID != "" {
+				e.MetaData[entity.MetaRunID] = runID
+			}
+		}
+		entities = append(entities, e)
+	}
+
+	return entities
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/schedule"
+)
+
+// Task classes sharing the worker pool
+const (
+	// ClassByEndpoint gives every endpoint its share of the workers, so one
+	// endpoint with many or slow tasks does not hold back the others
+	ClassByEndpoint = "endpoint"
+	// ClassByPriority gives every priority its share of the workers, so
+	// low priority tasks progress while high priority ones are waiting
+	ClassByPriority = "priority"
+)
+
+// taskClass returns the worker pool class of a task
+func (s *ExtractionService) taskClass(task schedule.Task) string {
+	if s.taskClasses == ClassByPriority {
+		return strconv.Itoa(task.Priority)
+	}
+	return task.Endpoint
+}
+
+// taskPriorityKey is the context key of the priority of a running task
+type taskPriorityKey struct{}
+
+// withPriority returns a context running a task at a priority
+func withPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, taskPriorityKey{}, priority)
+}
+
+// priorityFromContext returns the priority of the task running under ctx, zero if none
+func priorityFromContext(ctx context.Context) int {
+	priority, _ := ctx.Value(taskPriorityKey{}).(int)
+	return priority
+}
+
+// prioritized is an entry of a priority heap. Higher priorities come
+// first and equal priorities keep their arrival order.
+type prioritized struct {
+	priority int
+	seq      uint64
+	index    int
+
+	// run and fail complete a queued task; ready is closed when a waiter is admitted
+	run   func(ctx context.Context) error
+	fail  func(error)
+	ready chan struct{}
+}
+
+// priorityHeap implements heap.Interface over prioritized entries
+type priorityHeap []*prioritized
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *priorityHeap) Push(x interface{}) {
+	entry := x.(*prioritized)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// taskQueue orders the tasks handed to the worker pool by priority within
+// their pool class. Every queued task is matched by one submission to its
+// class that runs whichever queued task of the class has the highest
+// priority when a worker picks it up, so a busy pool serves high-priority
+// tasks first whatever order they were submitted in. The pool shares the
+// workers between classes. The zero value is ready to use.
+type taskQueue struct {
+	mu      sync.Mutex
+	pending map[string]*priorityHeap
+	seq     uint64
+}
+
+// submit queues a task of a class and submits a slot for it to the pool
+// through group. The task runs with the context of the slot, which carries
+// the pool's task timeout. When the pool rejects the slot, the lowest priority task of
+// the class still queued fails with the submission error.
+func (q *taskQueue) submit(group ports.TaskGroup, class string, priority int, run func(ctx context.Context) error, fail func(error)) {
+	q.mu.Lock()
+	if q.pending == nil {
+		q.pending = make(map[string]*priorityHeap)
+	}
+	pending, ok := q.pending[class]
+	if !ok {
+		pending = &priorityHeap{}
+		q.pending[class] = pending
+	}
+	q.seq++
+	heap.Push(pending, &prioritized{priority: priority, seq: q.seq, run: run, fail: fail})
+	q.mu.Unlock()
+
+	err := group.Go(class, func(ctx context.Context) error {
+		return q.pop(class).run(ctx)
+	})
+	if err != nil {
+		q.mu.Lock()
+		lowest := q.lowest(class)
+		heap.Remove(q.pending[class], lowest.index)
+		q.dropEmpty(class)
+		q.mu.Unlock()
+		lowest.fail(err)
+	}
+}
+
+// pop removes the queued task of a class served first
+func (q *taskQueue) pop(class string) *prioritized {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task := heap.Pop(q.pending[class]).(*prioritized)
+	q.dropEmpty(class)
+	return task
+}
+
+// lowest returns the queued task of a class served last. It must be called
+// with the lock held.
+func (q *taskQueue) lowest(class string) *prioritized {
+	pending := *q.pending[class]
+	var lowest *prioritized
+	for _, task := range pending {
+		if lowest == nil || pending.Less(lowest.index, task.index) {
+			lowest = task
+		}
+	}
+	return lowest
+}
+
+// dropEmpty forgets a class without queued tasks. It must be called with
+// the lock held.
+func (q *taskQueue) dropEmpty(class string) {
+	if q.pending[class].Len() == 0 {
+		delete(q.pending, class)
+	}
+}
+
+// priorityGate admits one waiter at a time, highest priority first, so the
+// rate budget goes to high-priority tasks while it is constrained.
+// The zero value is ready to use.
+type priorityGate struct {
+	mu      sync.Mutex
+	busy    bool
+	waiting priorityHeap
+	seq     uint64
+}
+
+// acquire blocks until the caller is admitted or ctx is done
+func (g *priorityGate) acquire(ctx context.Context, priority int) error {
+	g.mu.Lock()
+	if !g.busy {
+		g.busy = true
+		g.mu.Unlock()
+		return nil
+	}
+	g.seq++
+	waiter := &prioritized{priority: priority, seq: g.seq, ready: make(chan struct{})}
+	heap.Push(&g.waiting, waiter)
+	g.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if waiter.index >= 0 {
+			heap.Remove(&g.waiting, waiter.index)
+			g.mu.Unlock()
+			return ctx.Err()
+		}
+		g.mu.Unlock()
+
+		// Admitted while giving up, pass the turn on
+		g.release()
+		return ctx.Err()
+	}
+}
+
+// release admits the next waiter
+func (g *priorityGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.waiting.Len() == 0 {
+		g.busy = false
+		return
+	}
+	close(heap.Pop(&g.waiting).(*prioritized).ready)
+}
+
+// waitRateLimit waits for the rate limiter, letting higher priority tasks wait first
+func (s *ExtractionService) waitRateLimit(ctx context.Context) error {
+	if err := s.rateGate.acquire(ctx, priorityFromContext(ctx)); err != nil {
+		return err
+	}
+	defer s.rateGate.release()
+	return s.rateLimiter.Wait(ctx)
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+)
+
+// publishPage publishes a page as a single batch. Entities that fail are
+// retried up to publishRetries times and then handed to the dead letter sink.
+// It returns one error per entity that could not be published nor dead-lettered.
+func (s *ExtractionService) publishPage(ctx context.Context, page *entity.Page, topic string) (errs []error) {
+	ctx, span := tracer.Start(ctx, "extraction.publish_batch", trace.WithAttributes(
+		attribute.String("endpoint", page.Endpoint),
+		attribute.String("query_type", page.QueryType),
+		attribute.String("topic", topic),
+		attribute.Int("page", page.Number),
+		attribute.Int("entities", len(page.Entities)),
+	))
+	defer func() {
+		if len(errs) > 0 {
+			endSpan(span, fmt.Errorf("%d entities could not be published: %w", len(errs), errs[0]))
+			return
+		}
+		span.End()
+	}()
+
+	pending := page.Entities
+	var causes []error
+
+	for attempt := 1; ; attempt++ {
+		err := s.publishBatch(ctx, pending, causes, topic)
+		if err == nil {
+			if attempt > 1 {
+				log.Info().
+					Str("endpoint", page.Endpoint).
+					Str("queryType", page.QueryType).
+					Int("page", page.Number).
+					Int("attempt", attempt).
+					Msg("Republished failed entities")
+			}
+			s.metrics.RecordEntities(page.Endpoint, page.QueryType, len(page.Entities), len(page.Entities), 0, 0)
+			return nil
+		}
+
+		failed, failedErrs := failedEntities(pending, err)
+		if attempt > s.publishRetries || ctx.Err() != nil {
+			return s.deadLetterPage(ctx, page, topic, failed, failedErrs, attempt)
+		}
+
+		log.Warn().
+			Str("endpoint", page.Endpoint).
+			Str("queryType", page.QueryType).
+			Int("page", page.Number).
+			Int("failed", len(failed)).
+			Int("attempt", attempt).
+			Err(err).
+			Msg("Retrying failed entities")
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.Int("failed", len(failed)),
+			attribute.String("error", err.Error()),
+		))
+
+		select {
+		case <-time.After(s.publishDelay * time.Duration(attempt)):
+		case <-ctx.Done():
+			return s.deadLetterPage(ctx, page, topic, failed, failedErrs, attempt)
+		}
+		pending = failed
+		causes = failedErrs
+	}
+}
+
+// publishBatch publishes a batch, or retries the entities that failed with
+// causes through publishers that retry only what failed
+func (s *ExtractionService) publishBatch(ctx context.Context, entities []*entity.Entity, causes []error, topic string) error {
+	if retrier, ok := s.publisher.(ports.BatchRetrier); ok && causes != nil {
+		return retrier.RetryBatch(ctx, entities, causes, topic)
+	}
+	return s.publisher.PublishBatch(ctx, entities, topic)
+}
+
+// failedEntities returns the entities of a batch that failed together with their errors
+func failedEntities(entities []*entity.Entity, err error) ([]*entity.Entity, []error) {
+	var batchErr *ports.BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != len(entities) {
+		// The whole batch failed
+		errs := make([]error, len(entities))
+		for i := range errs {
+			errs[i] = err
+		}
+		return entities, errs
+	}
+
+	var failed []*entity.Entity
+	var errs []error
+	for _, i := range batchErr.Failed() {
+		failed = append(failed, entities[i])
+		errs = append(errs, batchErr.Errors[i])
+	}
+	return failed, errs
+}
+
+// deadLetterPage dead-letters the failed entities of a page and records the outcome of the page
+func (s *ExtractionService) deadLetterPage(
+	ctx context.Context,
+	page *entity.Page,
+	topic string,
+	failed []*entity.Entity,
+	causes []error,
+	attempts int,
+) []error {
+	errs := s.deadLetter(ctx, page, topic, failed, causes, attempts)
+	s.metrics.RecordEntities(
+		page.Endpoint,
+		page.QueryType,
+		len(page.Entities),
+		len(page.Entities)-len(failed),
+		len(failed)-len(errs),
+		len(errs),
+	)
+	return errs
+}
+
+// deadLetter hands entities that exhausted their retries to the dead letter sink
+func (s *ExtractionService) deadLetter(
+	ctx context.Context,
+	page *entity.Page,
+	topic string,
+	entities []*entity.Entity,
+	causes []error,
+	attempts int,
+) []error {
+	var errs []error
+
+	for i, e := range entities {
+		lost := func(err error) {
+			log.Error().
+				Str("endpoint", page.Endpoint).
+				Str("queryType", page.QueryType).
+				Str("entityId", e.ID).
+				Err(err).
+				Msg("Failed to publish entity")
+			errs = append(errs, fmt.Errorf("error publishing entity %s: %w", e.ID, err))
+		}
+
+		if s.deadLetters == nil {
+			lost(causes[i])
+			continue
+		}
+
+		payload, err := e.MarshalForEvent()
+		if err != nil {
+			lost(fmt.Errorf("error marshaling dead letter: %w", err))
+			continue
+		}
+
+		letter := &entity.DeadLetter{
+			Topic:   topic,
+			Key:     s.router.Key(e),
+			Payload: payload,
+			Headers: map[string]string{
+				"deployment": page.Endpoint,
+				"query_type": page.QueryType,
+				"entity_id":  e.ID,
+				"page":       strconv.Itoa(page.Number),
+				"cursor":     page.Cursor,
+			},
+			Error:    causes[i].Error(),
+			Attempts: attempts,
+			FailedAt: time.Now().UTC(),
+		}
+
+		// Use a fresh context so letters are kept even when the run was cancelled
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		err = s.deadLetters.Send(sendCtx, letter)
+		cancel()
+		if err != nil {
+			lost(fmt.Errorf("%v (dead letter failed: %w)", causes[i], err))
+		}
+	}
+
+	if dead := len(entities) - len(errs); dead > 0 {
+		log.Warn().
+			Str("endpoint", page.Endpoint).
+			Str("queryType", page.QueryType).
+			Int("page", page.Number).
+			Int("deadLetters", dead).
+			Msg("Routed failed entities to dead letter sink")
+	}
+
+	return errs
+}
+
+// ReplayDeadLetters republishes every stored dead letter through the publisher
+func (s *ExtractionService) ReplayDeadLetters(ctx context.Context) (int, error) {
+	if s.deadLetters == nil {
+		return 0, errors.New("no dead letter sink configured")
+	}
+
+	return s.deadLetters.Replay(ctx, func(ctx context.Context, letter *entity.DeadLetter) error {
+		e, err := entity.UnmarshalFromEvent(letter.Payload)
+		if err != nil {
+			return fmt.Errorf("error decoding dead letter payload: %w", err)
+		}
+		return s.publisher.PublishEntity(ctx, e, letter.Topic)
+	})
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+)
+
+// taskCountersKey is the context key of the counters of a running task
+type taskCountersKey struct{}
+
+// taskCounters counts events of a task that happen below the page handler
+type taskCounters struct {
+	retries atomic.Int64
+}
+
+// withTaskCounters returns a context counting the events of a task
+func withTaskCounters(ctx context.Context) (context.Context, *taskCounters) {
+	counters := &taskCounters{}
+	return context.WithValue(ctx, taskCountersKey{}, counters), counters
+}
+
+// countRetry records a retried query on the task running under ctx, if any
+func countRetry(ctx context.Context) {
+	if counters, ok := ctx.Value(taskCountersKey{}).(*taskCounters); ok {
+		counters.retries.Add(1)
+	}
+}
+
+// runReport collects the task reports of a run as they finish
+type runReport struct {
+	mu     sync.Mutex
+	report entity.RunReport
+}
+
+// newRunReport starts the report of a run
+func newRunReport(runID string) *runReport {
+	return &runReport{report: entity.RunReport{
+		RunStatus: entity.RunStatus{ID: runID, StartedAt: time.Now().UTC()},
+		Tasks:     []entity.TaskReport{},
+	}}
+}
+
+// add records the report of a finished task
+func (r *runReport) add(task entity.TaskReport) {
+	r.mu.Lock()
+	r.report.Tasks = append(r.report.Tasks, task)
+	r.mu.Unlock()
+}
+
+// finish completes the report with the outcome of the run
+func (r *runReport) finish(err error) *entity.RunReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.report.FinishedAt = time.Now().UTC()
+	if err != nil {
+		r.report.Error = err.Error()
+	}
+	sort.Slice(r.report.Tasks, func(i, j int) bool {
+		a, b := r.report.Tasks[i], r.report.Tasks[j]
+		if a.Endpoint != b.Endpoint {
+			return a.Endpoint < b.Endpoint
+		}
+		return a.QueryType < b.QueryType
+	})
+
+	report := r.report
+	return &report
+}
+
+// recordRun stores the report of a finished run and publishes it as a run summary
+func (s *ExtractionService) recordRun(ctx context.Context, report *entity.RunReport) {
+	// Use a fresh context so the report is kept even when the run was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if s.runs != nil {
+		if err := s.runs.SaveRun(ctx, report); err != nil {
+			log.Error().
+				Str("runId", report.ID).
+				Err(err).
+				Msg("Failed to save run report")
+		}
+	}
+
+	if s.reportTopic == "" {
+		return
+	}
+	data, err := json.Marshal(report)
+	if err == nil {
+		err = s.publisher.PublishRaw(ctx, report.ID, data, s.reportTopic)
+	}
+	if err != nil {
+		log.Error().
+			Str("runId", report.ID).
+			Str("topic", s.reportTopic).
+			Err(err).
+			Msg("Failed to publish run summary")
+	}
+}
+
+// Runs returns the reports of the most recent runs, newest first
+func (s *ExtractionService) Runs(ctx context.Context, limit int) ([]*entity.RunReport, error) {
+	if s.runs == nil {
+		return nil, errors.New("no run store configured")
+	}
+	return s.runs.ListRuns(ctx, limit)
+}
+
+// Run returns the report of a run
+func (s *ExtractionService) Run(ctx context.Context, runID string) (*entity.RunReport, error) {
+	if s.runs == nil {
+		return nil, errors.New("no run store configured")
+	}
+	return s.runs.GetRun(ctx, runID)
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/schedule"
+)
+
+// abortGrace is how long cancelled tasks get to save their reports once the
+// shutdown deadline has passed
+const abortGrace = 5 * time.Second
+
+// lifecycle tracks the runs in progress and stops them on shutdown
+type lifecycle struct {
+	mu       sync.Mutex
+	active   int
+	stopping bool
+
+	// stop is closed when the shutdown starts, idle once no run is left
+	stop chan struct{}
+	idle chan struct{}
+
+	// abortCtx is cancelled when the shutdown deadline has passed
+	abortCtx context.Context
+	abort    context.CancelFunc
+
+	interrupted map[taskKey]entity.InterruptedTask
+}
+
+// newLifecycle creates a lifecycle accepting runs
+func newLifecycle() *lifecycle {
+	abortCtx, abort := context.WithCancel(context.Background())
+	return &lifecycle{
+		stop:        make(chan struct{}),
+		idle:        make(chan struct{}),
+		abortCtx:    abortCtx,
+		abort:       abort,
+		interrupted: make(map[taskKey]entity.InterruptedTask),
+	}
+}
+
+// enter registers a run. It returns false once the shutdown has started.
+func (l *lifecycle) enter() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopping {
+		return false
+	}
+	l.active++
+	return true
+}
+
+// leave unregisters a run
+func (l *lifecycle) leave() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if l.stopping && l.active == 0 {
+		close(l.idle)
+	}
+}
+
+// shutdown refuses new runs and signals the runs in progress to stop
+func (l *lifecycle) shutdown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopping {
+		return
+	}
+	l.stopping = true
+	close(l.stop)
+	if l.active == 0 {
+		close(l.idle)
+	}
+}
+
+// stopped reports whether the shutdown has started
+func (l *lifecycle) stopped() bool {
+	select {
+	case <-l.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// bind returns a context of ctx that is also cancelled when the shutdown deadline passes
+func (l *lifecycle) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopAbort := context.AfterFunc(l.abortCtx, func() {
+		cancel(ports.ErrInterrupted)
+	})
+	return ctx, func() {
+		stopAbort()
+		cancel(nil)
+	}
+}
+
+// interrupt records a task stopped by the shutdown
+func (l *lifecycle) interrupt(task entity.InterruptedTask) {
+	l.mu.Lock()
+	l.interrupted[taskKey{endpoint: task.Endpoint, queryType: task.QueryType}] = task
+	l.mu.Unlock()
+}
+
+// interruptedTasks returns the tasks stopped by the shutdown
+func (l *lifecycle) interruptedTasks() []entity.InterruptedTask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tasks := make([]entity.InterruptedTask, 0, len(l.interrupted))
+	for _, task := range l.interrupted {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Endpoint != tasks[j].Endpoint {
+			return tasks[i].Endpoint < tasks[j].Endpoint
+		}
+		return tasks[i].QueryType < tasks[j].QueryType
+	})
+	return tasks
+}
+
+// Shutdown stops the service gracefully. New runs are refused, tasks that
+// have not started yet stop right away and tasks in progress stop once the
+// page they are on has been published and checkpointed. Tasks still running
+// when ctx is done are cancelled. Every task stopped before it finished is
+// recorded in the repository, so that the next start resumes it first.
+// It returns ctx's error when tasks had to be cancelled.
+func (s *ExtractionService) Shutdown(ctx context.Context) error {
+	s.life.shutdown()
+	log.Info().Msg("Stopping extraction, letting tasks in progress finish their current page")
+
+	var err error
+	select {
+	case <-s.life.idle:
+	case <-ctx.Done():
+		err = fmt.Errorf("tasks still running at the shutdown deadline: %w", ctx.Err())
+		log.Warn().Msg("Shutdown deadline reached, cancelling tasks in progress")
+		s.life.abort()
+
+		select {
+		case <-s.life.idle:
+		case <-time.After(abortGrace):
+			log.Error().Msg("Tasks did not stop after being cancelled")
+		}
+	}
+
+	// Record the interrupted tasks even though the deadline has passed
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortGrace)
+	defer cancel()
+
+	// Keep the records of tasks owned by other instances sharing the store
+	interrupted := s.life.interruptedTasks()
+	records := interrupted
+	if existing, readErr := s.repository.GetInterrupted(saveCtx); readErr == nil {
+		records = append(s.foreignInterrupted(existing), interrupted...)
+	}
+	if saveErr := s.repository.SaveInterrupted(saveCtx, records); saveErr != nil {
+		log.Error().Err(saveErr).Msg("Failed to record interrupted tasks")
+		return errors.Join(err, saveErr)
+	}
+	for _, task := range interrupted {
+		log.Info().
+			Str("endpoint", task.Endpoint).
+			Str("queryType", task.QueryType).
+			Str("cursor", task.Cursor).
+			Int("pages", task.Pages).
+			Msg("Recorded interrupted task")
+	}
+
+	return err
+}
+
+// Ready reports an error once the service is shutting down
+func (s *ExtractionService) Ready(ctx context.Context) error {
+	if s.life.stopped() {
+		return ports.ErrShuttingDown
+	}
+	return nil
+}
+
+// ResumeInterrupted extracts the tasks interrupted by the last shutdown as
+// one run, from the checkpoint of the last page each of them completed, and
+// clears their record once the run is over. Tasks that are no longer
+// configured are dropped; tasks owned by another instance are left to it.
+func (s *ExtractionService) ResumeInterrupted(ctx context.Context) error {
+	if !s.life.enter() {
+		return ports.ErrShuttingDown
+	}
+	defer s.life.leave()
+
+	interrupted, err := s.repository.GetInterrupted(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading interrupted tasks: %w", err)
+	}
+	if len(interrupted) == 0 {
+		return nil
+	}
+
+	foreign := s.foreignInterrupted(interrupted)
+	tasks := make([]schedule.Task, 0, len(interrupted))
+	for _, record := range interrupted {
+		key := taskKey{endpoint: record.Endpoint, queryType: record.QueryType}
+		if !s.hasPair(key) {
+			log.Warn().
+				Str("endpoint", record.Endpoint).
+				Str("queryType", record.QueryType).
+				Msg("Dropping interrupted task that is no longer configured")
+			continue
+		}
+		if !s.shards.owns(key) {
+			continue
+		}
+
+		// The record is written after the task's last checkpoint; restore
+		// the checkpoint from it when the cursor file did not make it to disk
+		cursor, err := s.repository.GetLatestCursor(ctx, record.QueryType, record.Endpoint)
+		if err != nil {
+			return fmt.Errorf("error reading cursor of %s from %s: %w", record.QueryType, record.Endpoint, err)
+		}
+		switch {
+		case cursor == "" && record.Cursor != "":
+			log.Warn().
+				Str("endpoint", record.Endpoint).
+				Str("queryType", record.QueryType).
+				Str("cursor", record.Cursor).
+				Msg("Checkpoint of interrupted task is missing, restoring it from the record")
+			if err := s.repository.SaveCursor(ctx, record.QueryType, record.Endpoint, record.Cursor); err != nil {
+				return fmt.Errorf("error restoring cursor of %s from %s: %w", record.QueryType, record.Endpoint, err)
+			}
+		case cursor != record.Cursor:
+			log.Warn().
+				Str("endpoint", record.Endpoint).
+				Str("queryType", record.QueryType).
+				Str("cursor", cursor).
+				Str("recordedCursor", record.Cursor).
+				Msg("Checkpoint moved since the task was interrupted, resuming from the checkpoint")
+		}
+
+		log.Info().
+			Str("endpoint", record.Endpoint).
+			Str("queryType", record.QueryType).
+			Str("cursor", record.Cursor).
+			Str("interruptedRunId", record.RunID).
+			Msg("Resuming interrupted task")
+
+		tasks = append(tasks, schedule.Task{
+			Endpoint:  record.Endpoint,
+			QueryType: record.QueryType,
+			Priority:  s.planner.Priority(record.Endpoint, record.QueryType),
+		})
+	}
+
+	var runErr error
+	if len(tasks) > 0 {
+		runErr = s.extractRun(ctx, tasks)
+	}
+
+	// A shutdown during the resumed run records its own interrupted tasks
+	if s.life.stopped() {
+		return runErr
+	}
+	if err := s.repository.SaveInterrupted(ctx, foreign); err != nil {
+		return errors.Join(runErr, fmt.Errorf("error clearing interrupted tasks: %w", err))
+	}
+	return runErr
+}
+
+// foreignInterrupted returns the records of configured tasks owned by
+// another instance
+func (s *ExtractionService) foreignInterrupted(records []entity.InterruptedTask) []entity.InterruptedTask {
+	var foreign []entity.InterruptedTask
+	for _, record := range records {
+		key := taskKey{endpoint: record.Endpoint, queryType: record.QueryType}
+		if s.hasPair(key) && !s.shards.owns(key) {
+			foreign = append(foreign, record)
+		}
+	}
+	return foreign
+}
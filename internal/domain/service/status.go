@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/entity"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/ports"
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/schedule"
+)
+
+// taskKey identifies the extraction of a query type from an endpoint
+type taskKey struct {
+	endpoint  string
+	queryType string
+}
+
+// statusTracker records the state of tasks and runs for status reports.
+// The zero value is ready to use.
+type statusTracker struct {
+	mu          sync.Mutex
+	tasks       map[taskKey]*entity.TaskStatus
+	lastRun     entity.RunStatus
+	lastSuccess entity.RunStatus
+}
+
+// begin marks a task as running. It returns false when the task is already running.
+func (t *statusTracker) begin(key taskKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tasks == nil {
+		t.tasks = make(map[taskKey]*entity.TaskStatus)
+	}
+	status, ok := t.tasks[key]
+	if !ok {
+		status = &entity.TaskStatus{Endpoint: key.endpoint, QueryType: key.queryType}
+		t.tasks[key] = status
+	}
+	if status.State == entity.TaskRunning {
+		return false
+	}
+
+	status.State = entity.TaskRunning
+	status.LastStartedAt = time.Now().UTC()
+	return true
+}
+
+// finish records the outcome of a running task
+func (t *statusTracker) finish(key taskKey, entities int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.tasks[key]
+	status.LastFinishedAt = time.Now().UTC()
+	status.Entities = entities
+	if err != nil {
+		status.State = entity.TaskFailed
+		if errors.Is(err, ports.ErrInterrupted) || errors.Is(err, ports.ErrReassigned) {
+			status.State = entity.TaskInterrupted
+		}
+		status.LastError = err.Error()
+		return
+	}
+	status.State = entity.TaskSucceeded
+	status.LastSuccessAt = status.LastFinishedAt
+	status.LastError = ""
+}
+
+// task returns a copy of the status of a task
+func (t *statusTracker) task(key taskKey) entity.TaskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if status, ok := t.tasks[key]; ok {
+		return *status
+	}
+	return entity.TaskStatus{Endpoint: key.endpoint, QueryType: key.queryType, State: entity.TaskIdle}
+}
+
+// startRun records the start of a run
+func (t *statusTracker) startRun(runID string) {
+	t.mu.Lock()
+	t.lastRun = entity.RunStatus{ID: runID, StartedAt: time.Now().UTC()}
+	t.mu.Unlock()
+}
+
+// finishRun records the outcome of the current run
+func (t *statusTracker) finishRun(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastRun.FinishedAt = time.Now().UTC()
+	if err != nil {
+		t.lastRun.Error = err.Error()
+		return
+	}
+	t.lastSuccess = t.lastRun
+}
+
+// Tasks returns the status of every endpoint and query type along with the
+// cursor the next extraction resumes from
+func (s *ExtractionService) Tasks(ctx context.Context) ([]entity.TaskStatus, error) {
+	pairs := s.pairs()
+	tasks := make([]entity.TaskStatus, 0, len(pairs))
+	for _, key := range pairs {
+		status := s.status.task(key)
+
+		cursor, err := s.repository.GetLatestCursor(ctx, key.queryType, key.endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cursor of %s from %s: %w", key.queryType, key.endpoint, err)
+		}
+		status.Cursor = cursor
+		status.Schedule, status.Priority = s.planner.Resolve(key.endpoint, key.queryType)
+		status.Owned = s.shards.owns(key)
+
+		tasks = append(tasks, status)
+	}
+	return tasks, nil
+}
+
+// LastRun returns the status of the current or last extraction run, if any
+func (s *ExtractionService) LastRun() (entity.RunStatus, bool) {
+	s.status.mu.Lock()
+	defer s.status.mu.Unlock()
+	return s.status.lastRun, s.status.lastRun.ID != ""
+}
+
+// LastSuccessfulRun returns the status of the last run that finished without errors, if any
+func (s *ExtractionService) LastSuccessfulRun() (entity.RunStatus, bool) {
+	s.status.mu.Lock()
+	defer s.status.mu.Unlock()
+	return s.status.lastSuccess, s.status.lastSuccess.ID != ""
+}
+
+// ExtractPair extracts a single query type from an endpoint, resuming from its cursor.
+// It returns ports.ErrUnknownTask for pairs that are not configured, ports.ErrNotOwner
+// for pairs owned by another instance and ports.ErrTaskRunning while the pair is
+// being extracted.
+func (s *ExtractionService) ExtractPair(ctx context.Context, endpoint, queryType string) error {
+	if !s.life.enter() {
+		return ports.ErrShuttingDown
+	}
+	defer s.life.leave()
+
+	key := taskKey{endpoint: endpoint, queryType: queryType}
+	if err := s.beginPair(key); err != nil {
+		return err
+	}
+	return s.runPair(ctx, key)
+}
+
+// StartPair starts the extraction of a single query type from an endpoint in
+// the background. It fails like ExtractPair when the extraction cannot start.
+func (s *ExtractionService) StartPair(ctx context.Context, endpoint, queryType string) error {
+	if !s.life.enter() {
+		return ports.ErrShuttingDown
+	}
+
+	key := taskKey{endpoint: endpoint, queryType: queryType}
+	if err := s.beginPair(key); err != nil {
+		s.life.leave()
+		return err
+	}
+	go func() {
+		defer s.life.leave()
+		s.runPair(ctx, key)
+	}()
+	return nil
+}
+
+// beginPair checks that a pair is configured and owned and marks it as running
+func (s *ExtractionService) beginPair(key taskKey) error {
+	if !s.hasPair(key) {
+		return fmt.Errorf("%w: %s from %s", ports.ErrUnknownTask, key.queryType, key.endpoint)
+	}
+	if !s.shards.owns(key) {
+		return fmt.Errorf("%w: %s from %s", ports.ErrNotOwner, key.queryType, key.endpoint)
+	}
+	if !s.status.begin(key) {
+		return fmt.Errorf("%w: %s from %s", ports.ErrTaskRunning, key.queryType, key.endpoint)
+	}
+	return nil
+}
+
+// runPair extracts a pair marked as running and records its outcome
+func (s *ExtractionService) runPair(ctx context.Context, key taskKey) error {
+	ctx, release := s.life.bind(ctx)
+	defer release()
+
+	if entity.RunIDFromContext(ctx) == "" {
+		ctx = entity.WithRunID(ctx, uuid.New().String())
+	}
+	log.Info().
+		Str("runId", entity.RunIDFromContext(ctx)).
+		Str("endpoint", key.endpoint).
+		Str("queryType", key.queryType).
+		Msg("Starting on-demand extraction")
+
+	report := newRunReport(entity.RunIDFromContext(ctx))
+	ctx = withPriority(ctx, s.planner.Priority(key.endpoint, key.queryType))
+	taskReport, publishErrs, err := s.extractTask(ctx, key.endpoint, key.queryType)
+	report.add(taskReport)
+	if err == nil && len(publishErrs) > 0 {
+		err = fmt.Errorf("%d entities could not be published: %w", len(publishErrs), publishErrs[0])
+	}
+	s.status.finish(key, taskReport.Entities, err)
+	s.recordRun(ctx, report.finish(err))
+
+	if err != nil {
+		log.Error().
+			Str("endpoint", key.endpoint).
+			Str("queryType", key.queryType).
+			Err(err).
+			Msg("On-demand extraction failed")
+	}
+	return err
+}
+
+// pairs returns the endpoints and query types to extract, skipping query
+// types that have no paginated query for an endpoint
+func (s *ExtractionService) pairs() []taskKey {
+	pairs := make([]taskKey, 0, len(s.endpoints)*len(s.queryTypes))
+	for _, endpoint := range s.endpoints {
+		for _, queryType := range s.queryTypes {
+			if s.queryGenerator.GeneratePaginatedQuery(endpoint, queryType, "", s.pageSize) == "" {
+				continue
+			}
+			pairs = append(pairs, taskKey{endpoint: endpoint, queryType: queryType})
+		}
+	}
+	return pairs
+}
+
+// tasks returns the pairs to extract with their priorities
+func (s *ExtractionService) tasks() []schedule.Task {
+	pairs := s.pairs()
+	tasks := make([]schedule.Task, 0, len(pairs))
+	for _, key := range pairs {
+		tasks = append(tasks, schedule.Task{
+			Endpoint:  key.endpoint,
+			QueryType: key.queryType,
+			Priority:  s.planner.Priority(key.endpoint, key.queryType),
+		})
+	}
+	return tasks
+}
+
+// hasPair reports whether a pair is extracted by the service
+func (s *ExtractionService) hasPair(key taskKey) bool {
+	for _, pair := range s.pairs() {
+		if pair == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of extraction runs. Spans are dropped until a
+// tracer provider is installed.
+var tracer = otel.Tracer("github.com/panoramablock/thegraph-data-extraction/internal/domain/service")
+
+// endSpan ends a span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
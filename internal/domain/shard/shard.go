@@ -0,0 +1,136 @@
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of points each member gets on a ring
+const DefaultReplicas = 128
+
+// Assignment tells which tasks an instance owns
+type Assignment interface {
+	// Owns reports whether the instance extracts a query type from an endpoint
+	Owns(endpoint, queryType string) bool
+}
+
+// All is the assignment of an instance owning every task
+type All struct{}
+
+// Owns returns true
+func (All) Owns(endpoint, queryType string) bool { return true }
+
+// None is the assignment of an instance owning no task
+type None struct{}
+
+// Owns returns false
+func (None) Owns(endpoint, queryType string) bool { return false }
+
+// Slots owns the tasks hashing to a set of slots
+type Slots struct {
+	// Count is the number of slots the tasks are hashed to
+	Count int
+
+	// Owned are the slots of the instance
+	Owned map[int]bool
+}
+
+// Owns reports whether the task hashes to an owned slot
+func (s Slots) Owns(endpoint, queryType string) bool {
+	return s.Owned[SlotOf(endpoint, queryType, s.Count)]
+}
+
+// SlotOf returns the slot a task hashes to among count slots
+func SlotOf(endpoint, queryType string, count int) int {
+	if count <= 0 {
+		return 0
+	}
+	return int(hash(TaskKey(endpoint, queryType)) % uint64(count))
+}
+
+// TaskKey is the key a task is hashed by
+func TaskKey(endpoint, queryType string) string {
+	return endpoint + "/" + queryType
+}
+
+// Ring assigns keys to members by consistent hashing, so that a member
+// joining or leaving only moves the keys it takes or gives up
+type Ring struct {
+	hashes  []uint64
+	members map[uint64]string
+}
+
+// NewRing creates a ring placing replicas points per member (default DefaultReplicas)
+func NewRing(members []string, replicas int) *Ring {
+	// Set defaults for configuration
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	ring := &Ring{members: make(map[uint64]string, len(members)*replicas)}
+	for _, member := range members {
+		for i := 0; i < replicas; i++ {
+			point := hash(member + "#" + strconv.Itoa(i))
+			// Keep the smallest member on a collision so every instance builds the same ring
+			if other, ok := ring.members[point]; ok && other < member {
+				continue
+			}
+			if _, ok := ring.members[point]; !ok {
+				ring.hashes = append(ring.hashes, point)
+			}
+			ring.members[point] = member
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// Owner returns the member owning a key, empty when the ring has no member
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	point := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= point })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.members[r.hashes[i]]
+}
+
+// Member is the assignment of one member of a ring
+type Member struct {
+	Ring *Ring
+	Name string
+}
+
+// Owns reports whether the member owns the task on the ring
+func (m Member) Owns(endpoint, queryType string) bool {
+	return m.Ring.Owner(TaskKey(endpoint, queryType)) == m.Name
+}
+
+// NewMember creates the assignment of a member of a static ring
+func NewMember(members []string, name string) (Member, error) {
+	for _, member := range members {
+		if member == name {
+			return Member{Ring: NewRing(members, 0), Name: name}, nil
+		}
+	}
+	return Member{}, fmt.Errorf("shard member %q is not one of %v", name, members)
+}
+
+// hash returns the 64-bit FNV-1a hash of a key, mixed so that keys
+// differing in their last characters spread over the whole ring
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
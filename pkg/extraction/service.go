@@ -3,6 +3,7 @@ package extraction
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -10,14 +11,42 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/panoramablock/thegraph-data-extraction/internal/domain/routing"
 	"github.com/panoramablock/thegraph-data-extraction/internal/queries"
 	"github.com/panoramablock/thegraph-data-extraction/pkg/client"
 )
 
+// tracer creates the spans of extraction runs; spans are dropped until a tracer provider is installed
+var tracer = otel.Tracer("github.com/panoramablock/thegraph-data-extraction/pkg/extraction")
+
 // DataCallback is a function type for handling extracted data
 type DataCallback func(endpoint, queryType string, data map[string]interface{}) error
 
+// Publisher publishes the JSON response of a query under a key to a topic.
+// The Kafka, NATS, RabbitMQ, webhook and spool publishers implement it.
+type Publisher interface {
+	PublishRaw(ctx context.Context, key string, data []byte, topic string) error
+	Close() error
+}
+
+// Router names the topic and the key of the response of a query
+type Router interface {
+	Topic(endpoint, queryType string) (string, error)
+	RawKey(endpoint, queryType string) string
+}
+
+// Metrics records queries, published entities and runs
+type Metrics interface {
+	ObserveQuery(endpoint, queryType string, duration time.Duration, err error)
+	RecordEntities(endpoint, queryType string, extracted, published, deadLettered, lost int)
+	RecordRun(duration time.Duration, err error)
+}
+
 // Service handles data extraction from The Graph API
 type Service struct {
 	client          *client.TheGraphClient
@@ -25,8 +54,10 @@ type Service struct {
 	outputDir       string
 	queryTypes      []string
 	concurrency     int
-	kafkaWriter     *kafka.Writer
+	publisher       Publisher
 	kafkaTopicPrefix string
+	router          Router
+	metrics         Metrics
 	dataCallback    DataCallback
 }
 
@@ -58,14 +89,37 @@ func (s *Service) SetConcurrency(n int) {
 
 // SetKafkaWriter sets the Kafka writer for publishing data
 func (s *Service) SetKafkaWriter(writer *kafka.Writer) {
-	s.kafkaWriter = writer
+	s.publisher = &kafkaWriterPublisher{writer: writer}
 }
 
-// SetKafkaTopicPrefix sets the prefix for Kafka topics
+// SetPublisher sets the publisher for extracted data, in place of a Kafka writer
+func (s *Service) SetPublisher(publisher Publisher) {
+	s.publisher = publisher
+}
+
+// SetKafkaTopicPrefix sets the prefix for Kafka topics used by the default router
 func (s *Service) SetKafkaTopicPrefix(prefix string) {
 	s.kafkaTopicPrefix = prefix
 }
 
+// SetRouter sets the router that names topics and keys for published data
+func (s *Service) SetRouter(router Router) {
+	s.router = router
+}
+
+// getRouter returns the configured router or a default one using the topic prefix
+func (s *Service) getRouter() (Router, error) {
+	if s.router != nil {
+		return s.router, nil
+	}
+	return routing.NewRouter(routing.Config{Prefix: s.kafkaTopicPrefix})
+}
+
+// SetMetrics sets the recorder of query, entity and run metrics
+func (s *Service) SetMetrics(metrics Metrics) {
+	s.metrics = metrics
+}
+
 // SetDataCallback sets a callback function to be called with extracted data
 func (s *Service) SetDataCallback(callback DataCallback) {
 	s.dataCallback = callback
@@ -77,7 +131,22 @@ func (s *Service) ExtractAll() error {
 }
 
 // ExtractAllWithContext extracts all data types from all endpoints with context support
-func (s *Service) ExtractAllWithContext(ctx context.Context) error {
+func (s *Service) ExtractAllWithContext(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "extraction.run", trace.WithAttributes(
+		attribute.Int("run.endpoints", len(s.endpoints)),
+		attribute.Int("run.query_types", len(s.queryTypes)),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if s.metrics != nil {
+		startTime := time.Now()
+		defer func() {
+			s.metrics.RecordRun(time.Since(startTime), err)
+		}()
+	}
+
 	/* DISABLED: Create output directory if it doesn't exist
 	if err := os.MkdirAll(s.outputDir, 0755); err != nil {
 		return err
@@ -132,12 +201,25 @@ func (s *Service) ExtractAllWithContext(ctx context.Context) error {
 				semaphore <- struct{}{}
 				defer func() { <-semaphore }()
 
+				ctx, span := tracer.Start(ctx, "extraction.task", trace.WithAttributes(
+					attribute.String("endpoint", endpoint),
+					attribute.String("query_type", queryType),
+				))
+				defer span.End()
+
 				// Set the client endpoint
 				s.client.SetEndpoint(endpoint)
 
 				// Execute the query
 				response := make(map[string]interface{})
-				if err := s.client.QueryWithTimeout(query, &response, 30*time.Second); err != nil {
+				queryStart := time.Now()
+				_, querySpan := tracer.Start(ctx, "graphql.query")
+				err := s.client.QueryWithTimeout(query, &response, 30*time.Second)
+				endSpan(querySpan, err)
+				if s.metrics != nil {
+					s.metrics.ObserveQuery(endpoint, queryType, time.Since(queryStart), err)
+				}
+				if err != nil {
 					errorMsg := fmt.Errorf("error querying %s from %s: %w", queryType, endpoint, err)
 					log.Error().
 						Err(err).
@@ -169,9 +251,18 @@ func (s *Service) ExtractAllWithContext(ctx context.Context) error {
 						Msg("Extracted data")
 				}
 
-				// Send data to Kafka if writer is configured
-				if s.kafkaWriter != nil {
-					if err := s.publishToKafka(ctx, endpointID, queryType, response); err != nil {
+				// Send data to Kafka if a publisher is configured
+				if s.publisher != nil {
+					err := s.publishToKafka(ctx, endpoint, queryType, response)
+					if s.metrics != nil {
+						items, _ := response[queryType].([]interface{})
+						if err != nil {
+							s.metrics.RecordEntities(endpoint, queryType, len(items), 0, 0, len(items))
+						} else {
+							s.metrics.RecordEntities(endpoint, queryType, len(items), len(items), 0, 0)
+						}
+					}
+					if err != nil {
 						log.Error().
 							Err(err).
 							Str("endpointID", endpointID).
@@ -229,9 +320,17 @@ func (s *Service) ExtractAllWithContext(ctx context.Context) error {
 }
 
 // publishToKafka publishes extracted data to Kafka
-func (s *Service) publishToKafka(ctx context.Context, endpointID, queryType string, data map[string]interface{}) error {
-	if s.kafkaWriter == nil {
-		return fmt.Errorf("kafka writer not configured")
+func (s *Service) publishToKafka(ctx context.Context, endpoint, queryType string, data map[string]interface{}) (err error) {
+	ctx, span := tracer.Start(ctx, "extraction.publish_batch", trace.WithAttributes(
+		attribute.String("endpoint", endpoint),
+		attribute.String("query_type", queryType),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if s.publisher == nil {
+		return fmt.Errorf("kafka publisher not configured")
 	}
 
 	// Serialize data to JSON
@@ -240,17 +339,56 @@ func (s *Service) publishToKafka(ctx context.Context, endpointID, queryType stri
 		return fmt.Errorf("failed to marshal data to JSON: %w", err)
 	}
 
-	// Create Kafka message
-	topic := fmt.Sprintf("%s_%s_%s", s.kafkaTopicPrefix, endpointID, queryType)
-	message := kafka.Message{
-		Topic: topic,
-		Key:   []byte(fmt.Sprintf("%s-%s", endpointID, queryType)),
-		Value: jsonData,
-		Time:  time.Now(),
+	// Route the message
+	router, err := s.getRouter()
+	if err != nil {
+		return err
+	}
+	topic, err := router.Topic(endpoint, queryType)
+	if err != nil {
+		return err
 	}
 
 	// Publish message with context
-	return s.kafkaWriter.WriteMessages(ctx, message)
+	return s.publisher.PublishRaw(ctx, router.RawKey(endpoint, queryType), jsonData, topic)
+}
+
+// Topics returns the Kafka topics the service publishes to, leaving out
+// the topics the router cannot name.
+//
+// Deprecated: Use ResolveTopics, which reports the topics that cannot be named.
+func (s *Service) Topics() []string {
+	topics, err := s.ResolveTopics()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to resolve Kafka topics")
+	}
+	return topics
+}
+
+// ResolveTopics returns the Kafka topics the service publishes to, along
+// with the errors of the topics the router cannot name
+func (s *Service) ResolveTopics() ([]string, error) {
+	router, err := s.getRouter()
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []string
+	var errs []error
+	for _, endpoint := range s.endpoints {
+		for _, queryType := range s.queryTypes {
+			if queries.GetQueryForEndpoint(endpoint, queryType) == "" {
+				continue
+			}
+			topic, err := router.Topic(endpoint, queryType)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			topics = append(topics, topic)
+		}
+	}
+	return topics, errors.Join(errs...)
 }
 
 // saveJSON saves data to a JSON file
@@ -265,10 +403,39 @@ func (s *Service) saveJSON(filename string, data interface{}) error {
 	return os.WriteFile(filename, jsonData, 0644)
 }
 
-// Close closes the Kafka writer if configured
+// Close closes the publisher if configured
 func (s *Service) Close() error {
-	if s.kafkaWriter != nil {
-		return s.kafkaWriter.Close()
+	if s.publisher != nil {
+		return s.publisher.Close()
 	}
 	return nil
 }
+
+// kafkaWriterPublisher publishes through a Kafka writer set with SetKafkaWriter
+type kafkaWriterPublisher struct {
+	writer *kafka.Writer
+}
+
+// PublishRaw writes a message to a topic
+func (p *kafkaWriterPublisher) PublishRaw(ctx context.Context, key string, data []byte, topic string) error {
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(key),
+		Value: data,
+		Time:  time.Now(),
+	})
+}
+
+// Close closes the Kafka writer
+func (p *kafkaWriterPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// endSpan ends a span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}